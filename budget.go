@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExhausted is returned for ad-hoc forecasts from a source whose daily budget is used up.
+var ErrBudgetExhausted = errors.New("daily request budget exhausted")
+
+// Budget counts the forecasts requested from each source per day (UTC), shared by scheduled locations
+// and ad-hoc queries, and refuses requests once a source's daily limit is reached.
+type Budget struct {
+	lock   sync.Mutex
+	limits map[string]BudgetConfig
+	used   map[string]int
+	// day is the UTC date that used counts requests for.
+	day string
+}
+
+// NewBudget creates a Budget with the limits for each source. Sources without a limit are unlimited.
+func NewBudget(limits map[string]BudgetConfig) *Budget {
+	return &Budget{
+		limits: limits,
+		used:   make(map[string]int),
+	}
+}
+
+// SetLimits replaces the limits, e.g. after the config is reloaded. Requests already made today still count.
+func (b *Budget) SetLimits(limits map[string]BudgetConfig) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.limits = limits
+}
+
+// Take uses one request from the source's budget, returning false if none are left. Ad-hoc requests
+// can't use the requests reserved for scheduled locations.
+func (b *Budget) Take(src string, adHoc bool) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.rollover()
+	limit, ok := b.limits[src]
+	if !ok || limit.Daily == 0 {
+		return true
+	}
+	available := limit.Daily
+	if adHoc {
+		available -= limit.Reserved
+	}
+	if b.used[src] >= available {
+		return false
+	}
+	b.used[src]++
+	return true
+}
+
+// Remaining returns the number of requests left today for each source with a limit, and the limits.
+func (b *Budget) Remaining() (remaining map[string]int, limits map[string]BudgetConfig) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.rollover()
+	remaining = make(map[string]int)
+	for src, limit := range b.limits {
+		if limit.Daily > 0 {
+			remaining[src] = max(limit.Daily-b.used[src], 0)
+		}
+	}
+	return remaining, maps.Clone(b.limits)
+}
+
+// rollover resets the counts at the start of each UTC day. It should only be called while holding the lock.
+func (b *Budget) rollover() {
+	day := time.Now().UTC().Format(time.DateOnly)
+	if day != b.day {
+		b.day = day
+		clear(b.used)
+	}
+}
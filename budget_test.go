@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudget_Take(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits map[string]BudgetConfig
+		// takes are whether each request is ad-hoc
+		takes    []bool
+		expected []bool
+	}{
+		{
+			name:     "unlimited source",
+			limits:   map[string]BudgetConfig{"nws": {Daily: 1}},
+			takes:    []bool{true, true, false},
+			expected: []bool{true, true, true},
+		},
+		{
+			name:     "daily limit",
+			limits:   map[string]BudgetConfig{"visualcrossing": {Daily: 2}},
+			takes:    []bool{true, false, false, true},
+			expected: []bool{true, true, false, false},
+		},
+		{
+			name:     "ad-hoc can't use the reserved requests",
+			limits:   map[string]BudgetConfig{"visualcrossing": {Daily: 3, Reserved: 2}},
+			takes:    []bool{true, true, false, false, false},
+			expected: []bool{true, false, true, true, false},
+		},
+		{
+			name:     "scheduled can use the unreserved requests",
+			limits:   map[string]BudgetConfig{"visualcrossing": {Daily: 3, Reserved: 1}},
+			takes:    []bool{false, false, true, false},
+			expected: []bool{true, true, false, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBudget(tt.limits)
+			var results []bool
+			for _, adHoc := range tt.takes {
+				results = append(results, b.Take("visualcrossing", adHoc))
+			}
+			assert.Equal(t, tt.expected, results)
+		})
+	}
+}
+
+func TestBudget_Remaining(t *testing.T) {
+	limits := map[string]BudgetConfig{"visualcrossing": {Daily: 3, Reserved: 1}, "nws": {}}
+	b := NewBudget(limits)
+	b.Take("visualcrossing", true)
+	b.Take("nws", true)
+	remaining, got := b.Remaining()
+	assert.Equal(t, map[string]int{"visualcrossing": 2}, remaining)
+	assert.Equal(t, limits, got)
+
+	// lowering the limit below what has been used doesn't go negative
+	b.SetLimits(map[string]BudgetConfig{"visualcrossing": {Daily: 1}})
+	b.Take("visualcrossing", false)
+	remaining, _ = b.Remaining()
+	assert.Equal(t, map[string]int{"visualcrossing": 0}, remaining)
+}
+
+func TestBudget_Rollover(t *testing.T) {
+	b := NewBudget(map[string]BudgetConfig{"visualcrossing": {Daily: 1}})
+	assert.True(t, b.Take("visualcrossing", false))
+	assert.False(t, b.Take("visualcrossing", false))
+	// pretend the requests were made yesterday (UTC)
+	b.day = "2024-06-01"
+	assert.True(t, b.Take("visualcrossing", false))
+	assert.False(t, b.Take("visualcrossing", false))
+}
@@ -1,21 +1,111 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"slices"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/coordinates"
+	"github.com/tedpearson/ForecastMetrics/v3/internal/cron"
+	"github.com/tedpearson/ForecastMetrics/v3/internal/logging"
 )
 
-// Location is a name plus geo coordinates.
+// Location is a name plus geo coordinates, and options for scheduled locations.
 type Location struct {
-	Name      string
-	Latitude  string
-	Longitude string
+	Name      string `json:"name"`
+	Latitude  string `json:"latitude"`
+	Longitude string `json:"longitude"`
+	// Sources are the sources to export forecasts from. Defaults to every enabled source.
+	Sources []string `yaml:"sources,omitempty" json:"sources,omitempty"`
+	// Interval is how often to export forecasts, a whole number of hours such as "3h". Defaults to hourly.
+	Interval string `yaml:"interval,omitempty" json:"interval,omitempty"`
+	// Tags are extra tags written with every point.
+	Tags map[string]string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	// Timezone is the IANA time zone of the location, used to align the Interval to local midnight.
+	// Defaults to the local time zone.
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+}
+
+// reservedTags are the tags written by ForecastMetrics, which can't be used in Location.Tags.
+var reservedTags = []string{"source", "location", "forecast_time", "phenomenon", "significance"}
+
+// UpdateInterval returns how often the location is exported.
+func (l Location) UpdateInterval() time.Duration {
+	interval, err := time.ParseDuration(l.Interval)
+	if err != nil || interval < time.Hour {
+		return time.Hour
+	}
+	return interval
+}
+
+// TimeZone returns the location's time zone, or the local time zone if it has none.
+func (l Location) TimeZone() *time.Location {
+	if l.Timezone == "" {
+		return time.Local
+	}
+	tz, err := time.LoadLocation(l.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return tz
+}
+
+// UsesSource returns whether forecasts from src are exported for the location.
+func (l Location) UsesSource(src string) bool {
+	return len(l.Sources) == 0 || slices.Contains(l.Sources, src)
+}
+
+// validate checks the location's coordinates and options.
+func (l Location) validate() error {
+	_, _, ok, err := coordinates.Parse(l.Latitude + "," + l.Longitude)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("invalid coordinates %s,%s", l.Latitude, l.Longitude)
+	}
+	for _, src := range l.Sources {
+		if !slices.Contains(SourceNames, src) {
+			return fmt.Errorf("unknown source %s", src)
+		}
+	}
+	if l.Interval != "" {
+		interval, err := time.ParseDuration(l.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %w", err)
+		}
+		if interval < time.Hour || interval%time.Hour != 0 {
+			return fmt.Errorf("interval must be a whole number of hours: %s", l.Interval)
+		}
+	}
+	for tag := range l.Tags {
+		if tag == "" || slices.Contains(reservedTags, tag) {
+			return fmt.Errorf("tag name %q is not allowed", tag)
+		}
+	}
+	if l.Timezone != "" {
+		if _, err := time.LoadLocation(l.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	return nil
 }
 
+var (
+	// ErrLocationExists is returned when adding a location with the same name as a scheduled location.
+	ErrLocationExists = errors.New("a location with this name already exists")
+	// ErrLocationNotFound is returned when changing a location that isn't scheduled.
+	ErrLocationNotFound = errors.New("location not found")
+	// ErrInvalidLocation is returned when adding or changing a location with invalid coordinates or options.
+	ErrInvalidLocation = errors.New("invalid location")
+)
+
 // InfluxConfig is the configuration for Influx/VictoriaMetrics.
 type InfluxConfig struct {
 	Host      string
@@ -24,6 +114,102 @@ type InfluxConfig struct {
 	Bucket    string
 }
 
+// RemoteWriteConfig is the configuration for a Prometheus remote_write endpoint.
+type RemoteWriteConfig struct {
+	Url         string
+	Username    string
+	Password    string
+	BearerToken string `yaml:"bearer_token"`
+	Headers     map[string]string
+}
+
+// OutputConfig is the configuration for one of multiple databases to write to.
+type OutputConfig struct {
+	// Name identifies the output in logs. Defaults to the type.
+	Name string
+	// Type is one of influxdb, remote_write or file. Retention targets may also be victoriametrics.
+	Type              string
+	InfluxConfig      `yaml:",inline"`
+	RemoteWriteConfig `yaml:",inline"`
+	// Path is the file to append influx line protocol to, for the file type.
+	Path string
+}
+
+// SpoolConfig is the configuration for persisting failed writes to disk so they can be retried.
+type SpoolConfig struct {
+	// Dir is where failed writes are stored, in a subdirectory for each output. Blank disables spooling.
+	Dir            string
+	MaxBytes       int64         `yaml:"max_bytes"`
+	MaxAge         time.Duration `yaml:"max_age"`
+	ReplayInterval time.Duration `yaml:"replay_interval"`
+}
+
+// RetentionConfig is the configuration for deleting old forecasts, which are written with a new
+// forecast_time each hour unless overwrite_data is set.
+type RetentionConfig struct {
+	// MaxAge deletes forecasts older than this. Zero disables deletion.
+	MaxAge time.Duration `yaml:"max_age"`
+	// DownsampleAge keeps only the forecast made at DownsampleHour each day once forecasts are older than this.
+	// Zero disables downsampling.
+	DownsampleAge  time.Duration `yaml:"downsample_age"`
+	DownsampleHour int           `yaml:"downsample_hour"`
+	// DryRun only logs which forecasts would be deleted.
+	DryRun bool `yaml:"dry_run"`
+	// Targets are the databases to clean up, of type influxdb or victoriametrics. Defaults to the influxdb config.
+	Targets []OutputConfig
+}
+
+// SchedulerConfig is the configuration for fetching the forecasts of scheduled locations concurrently.
+type SchedulerConfig struct {
+	// Workers is the number of forecasts fetched at once. Defaults to 4.
+	Workers int
+	// SourceWorkers limits the forecasts fetched at once from each source. Defaults to Workers.
+	SourceWorkers map[string]int `yaml:"source_workers"`
+	// Timeout is how long to wait for each forecast to be fetched and written. Defaults to 2 minutes.
+	Timeout time.Duration
+	// Jitter delays the start of each fetch by a random time up to this long, so that requests are spread out
+	// instead of all being made at the scheduled time.
+	Jitter time.Duration
+}
+
+// ProxyConfig is the configuration for answering queries for scheduled locations from the database,
+// so that past data and previous forecasts are included.
+type ProxyConfig struct {
+	// Type is prometheus (or VictoriaMetrics), or influxdb, which uses the influxdb config. Blank disables proxying.
+	Type        string
+	Url         string
+	Username    string
+	Password    string
+	BearerToken string `yaml:"bearer_token"`
+}
+
+// GeocoderConfig is the configuration for a single Geocoder used to look up ad-hoc locations.
+type GeocoderConfig struct {
+	// Type is one of azure, nominatim, census or offline.
+	Type string
+	// Key is the Azure Maps shared key. Defaults to azure_shared_key.
+	Key string
+	// Url is the nominatim or census instance to use instead of the public one.
+	Url       string
+	UserAgent string `yaml:"user_agent"`
+	// Files are the gazetteer files for the offline geocoder.
+	Files []string
+}
+
+// LocationsApiConfig is the configuration for the /api/locations REST api.
+type LocationsApiConfig struct {
+	// Token is the "user:password" for basic auth. The api is disabled if it's blank.
+	Token string `yaml:"token"`
+}
+
+// LogConfig configures logging.
+type LogConfig struct {
+	// Level is debug, info, warn or error. Defaults to info.
+	Level string `yaml:"level"`
+	// Format is text or json. Defaults to text.
+	Format string `yaml:"format"`
+}
+
 type ServerConfig struct {
 	Port     int64
 	CertFile string `yaml:"cert_file"`
@@ -32,60 +218,280 @@ type ServerConfig struct {
 
 // Config is the configuration for ForecastMetrics.
 type Config struct {
-	InfluxDB                 InfluxConfig `yaml:"influxdb"`
-	ForecastMeasurementName  string       `yaml:"forecast_measurement_name"`
-	AstronomyMeasurementName string       `yaml:"astronomy_measurement_name"`
-	PrecipProbability        float64      `yaml:"precip_probability"`
-	HttpCacheDir             string       `yaml:"http_cache_dir"`
-	OverwriteData            bool         `yaml:"overwrite_data"`
-	AzureSharedKey           string       `yaml:"azure_shared_key"`
-	ServerConfig             ServerConfig `yaml:"server"`
-	AdHocCacheEntries        int          `yaml:"ad_hoc_cache_entries"`
-	Sources                  struct {
+	InfluxDB                 InfluxConfig       `yaml:"influxdb"`
+	RemoteWrite              RemoteWriteConfig  `yaml:"remote_write"`
+	Outputs                  []OutputConfig     `yaml:"outputs"`
+	Spool                    SpoolConfig        `yaml:"spool"`
+	Retention                RetentionConfig    `yaml:"retention"`
+	Proxy                    ProxyConfig        `yaml:"proxy"`
+	Scheduler                SchedulerConfig    `yaml:"scheduler"`
+	ForecastMeasurementName  string             `yaml:"forecast_measurement_name"`
+	AstronomyMeasurementName string             `yaml:"astronomy_measurement_name"`
+	PrecipProbability        float64            `yaml:"precip_probability"`
+	HttpCacheDir             string             `yaml:"http_cache_dir"`
+	OverwriteData            bool               `yaml:"overwrite_data"`
+	AzureSharedKey           string             `yaml:"azure_shared_key"`
+	Geocoders                []GeocoderConfig   `yaml:"geocoders"`
+	ServerConfig             ServerConfig       `yaml:"server"`
+	AdHocCacheEntries        int                `yaml:"ad_hoc_cache_entries"`
+	Log                      LogConfig          `yaml:"log"`
+	LocationsApi             LocationsApiConfig `yaml:"locations_api"`
+	// ShutdownTimeout is how long to wait for requests and forecasts in progress when stopping.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	Sources         struct {
 		Enabled        []string
 		VisualCrossing struct {
 			Key string
 		} `yaml:"visualcrossing"`
+		METNorway struct {
+			UserAgent string `yaml:"user_agent"`
+		} `yaml:"metno"`
+		// Schedules are cron schedules for scheduled forecasts from each source. Defaults to hourly.
+		Schedules map[string]string
+		// Budgets limit the forecasts requested from each source per day.
+		Budgets map[string]BudgetConfig
+	}
+}
+
+// BudgetConfig limits the number of forecasts requested from a source per day (UTC), such as
+// the free tier of VisualCrossing.
+type BudgetConfig struct {
+	// Daily is the number of forecasts that may be requested per day. Zero is unlimited.
+	Daily int
+	// Reserved is the part of Daily that only scheduled locations may use, so ad-hoc queries can't use it up.
+	Reserved int
+}
+
+// Validate checks the config for errors that would otherwise only show up when forecasts are fetched.
+func (c Config) Validate() error {
+	for _, src := range c.Sources.Enabled {
+		if !slices.Contains(SourceNames, src) {
+			return fmt.Errorf("unknown source %s in sources.enabled", src)
+		}
+	}
+	for src, schedule := range c.Sources.Schedules {
+		if !slices.Contains(SourceNames, src) {
+			return fmt.Errorf("unknown source %s in sources.schedules", src)
+		}
+		if _, err := cron.Parse(schedule); err != nil {
+			return fmt.Errorf("sources.schedules.%s: %w", src, err)
+		}
+	}
+	for src, budget := range c.Sources.Budgets {
+		if !slices.Contains(SourceNames, src) {
+			return fmt.Errorf("unknown source %s in sources.budgets", src)
+		}
+		if budget.Daily < 0 || budget.Reserved < 0 || budget.Reserved > budget.Daily {
+			return fmt.Errorf("sources.budgets.%s: reserved must be between 0 and daily", src)
+		}
+	}
+	for src := range c.Scheduler.SourceWorkers {
+		if !slices.Contains(SourceNames, src) {
+			return fmt.Errorf("unknown source %s in scheduler.source_workers", src)
+		}
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		return fmt.Errorf("log.level: %w", err)
+	}
+	if c.Log.Format != "" && !slices.Contains(logging.Formats, c.Log.Format) {
+		return fmt.Errorf("log.format must be one of %v: %s", logging.Formats, c.Log.Format)
+	}
+	if c.PrecipProbability < 0 || c.PrecipProbability > 1 {
+		return fmt.Errorf("precip_probability must be between 0 and 1: %v", c.PrecipProbability)
+	}
+	return nil
+}
+
+// Secrets returns the keys, tokens and passwords in the config, which are redacted from logs.
+func (c Config) Secrets() []string {
+	secrets := []string{c.Sources.VisualCrossing.Key, c.AzureSharedKey, c.InfluxDB.AuthToken,
+		c.RemoteWrite.Password, c.RemoteWrite.BearerToken, c.Proxy.Password, c.Proxy.BearerToken, c.LocationsApi.Token}
+	for _, oc := range slices.Concat(c.Outputs, c.Retention.Targets) {
+		secrets = append(secrets, oc.AuthToken, oc.Password, oc.BearerToken)
+	}
+	for _, gc := range c.Geocoders {
+		secrets = append(secrets, gc.Key)
+	}
+	return secrets
+}
+
+// skipInvalidLocations removes the locations that don't have a unique name, valid coordinates and valid options,
+// logging a warning for each, so that a locations file with mistakes or from an older version still loads.
+// The yaml nodes of the skipped locations are set aside in the returned locationsYaml.
+func skipInvalidLocations(locationsFile string, locations []Location, ly locationsYaml) ([]Location, locationsYaml) {
+	valid := make([]Location, 0, len(locations))
+	nodes := make([]*yaml.Node, 0, len(locations))
+	names := make(map[string]bool, len(locations))
+	for i, location := range locations {
+		err := ValidateLocations([]Location{location})
+		if err == nil && names[location.Name] {
+			err = fmt.Errorf("duplicate location name %s", location.Name)
+		}
+		if err != nil {
+			slog.Warn("Skipping invalid location", "file", locationsFile, "line", ly.nodes[i].Line, "error", err)
+			ly.skipped = append(ly.skipped, ly.nodes[i])
+			continue
+		}
+		names[location.Name] = true
+		valid = append(valid, location)
+		nodes = append(nodes, ly.nodes[i])
+	}
+	ly.nodes = nodes
+	return valid, ly
+}
+
+// ValidateLocations checks that every location has a unique name, valid coordinates and valid options.
+func ValidateLocations(locations []Location) error {
+	names := make(map[string]bool, len(locations))
+	for _, location := range locations {
+		if location.Name == "" {
+			return fmt.Errorf("location %s,%s has no name", location.Latitude, location.Longitude)
+		}
+		if names[location.Name] {
+			return fmt.Errorf("duplicate location name %s", location.Name)
+		}
+		names[location.Name] = true
+		if err := location.validate(); err != nil {
+			return fmt.Errorf("location %s: %w", location.Name, err)
+		}
 	}
+	return nil
 }
 
 // ConfigService provides a way to update and get the latest list of locations that have regular
-// forecasts exported to the database.
+// forecasts exported to the database, and the latest config. Both are reloaded from their files
+// by Reload when the files change.
 type ConfigService struct {
-	Config        Config
+	configFile    string
 	locationsFile string
 	lock          *sync.Mutex
+	config        Config
 	locations     []Location
+	// locationsYaml is the parsed locations file, used to keep its comments when saving.
+	locationsYaml locationsYaml
+	// configStat and locationsStat identify the versions of the files that were last read.
+	configStat    fileStat
+	locationsStat fileStat
+}
+
+// fileStat is the modification time and size of a file, used to tell when it has changed.
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFile returns the fileStat of a file, or the zero fileStat if it can't be read.
+func statFile(name string) fileStat {
+	info, err := os.Stat(name)
+	if err != nil {
+		return fileStat{}
+	}
+	return fileStat{modTime: info.ModTime(), size: info.Size()}
 }
 
 // NewConfigService initializes a ConfigService by parsing the main config and the locations files.
-// It panics if it can't read or parse the configs.
+// It panics if it can't read, parse or validate the main config, or read or parse the locations file.
 func NewConfigService(configFile, locationsFile string) *ConfigService {
-	// read config
+	c := &ConfigService{
+		configFile:    configFile,
+		locationsFile: locationsFile,
+		lock:          &sync.Mutex{},
+		configStat:    statFile(configFile),
+		locationsStat: statFile(locationsFile),
+	}
+	var err error
+	c.config, err = readConfig(configFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	c.locations, c.locationsYaml, err = readLocations(locationsFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	return c
+}
+
+// readConfig reads, parses and validates the main config file.
+func readConfig(configFile string) (Config, error) {
 	cf, err := os.ReadFile(configFile)
 	if err != nil {
-		panic(fmt.Sprintf("Error reading config file %s: %s", configFile, err))
+		return Config{}, fmt.Errorf("error reading config file %s: %w", configFile, err)
 	}
 	var config Config
 	err = yaml.Unmarshal(cf, &config)
 	if err != nil {
-		panic(fmt.Sprintf("Error loading config from %s: %s", configFile, err))
+		return Config{}, fmt.Errorf("error loading config from %s: %w", configFile, err)
 	}
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config in %s: %w", configFile, err)
+	}
+	return config, nil
+}
+
+// readLocations reads and parses the locations file, skipping invalid locations.
+func readLocations(locationsFile string) ([]Location, locationsYaml, error) {
 	lf, err := os.ReadFile(locationsFile)
 	if err != nil {
-		panic(fmt.Sprintf("Error reading locations file %s: %s", locationsFile, err))
+		return nil, locationsYaml{}, fmt.Errorf("error reading locations file %s: %w", locationsFile, err)
 	}
-	var locations []Location
-	err = yaml.Unmarshal(lf, &locations)
+	locations, ly, err := parseLocations(lf)
 	if err != nil {
-		panic(fmt.Sprintf("Error loading locations from %s: %s", locationsFile, err))
+		return nil, locationsYaml{}, fmt.Errorf("error loading locations from %s: %w", locationsFile, err)
 	}
-	return &ConfigService{
-		Config:        config,
-		locationsFile: locationsFile,
-		lock:          &sync.Mutex{},
-		locations:     locations,
+	locations, ly = skipInvalidLocations(locationsFile, locations, ly)
+	return locations, ly, nil
+}
+
+// GetConfig returns the current config.
+func (c *ConfigService) GetConfig() Config {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.config
+}
+
+// Reload re-reads the config and locations files if they have changed since they were last read,
+// or unconditionally if force is set. If a file can't be read or is invalid, the current config
+// or locations are kept and the error is returned. It returns the previous config if the config
+// was replaced, or nil.
+func (c *ConfigService) Reload(force bool) (*Config, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var errs []error
+	var old *Config
+	if stat := statFile(c.configFile); force || stat != c.configStat {
+		config, err := readConfig(c.configFile)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			prev := c.config
+			old = &prev
+			c.config = config
+		}
+		c.configStat = stat
 	}
+	if err := c.reloadLocations(force); err != nil {
+		errs = append(errs, err)
+	}
+	return old, errors.Join(errs...)
+}
+
+// reloadLocations re-reads the locations file if it changed since it was last read, or if force is set,
+// so that edits made outside the process aren't lost. The current locations are kept if the file is invalid.
+// It should only be called while holding the lock.
+func (c *ConfigService) reloadLocations(force bool) error {
+	stat := statFile(c.locationsFile)
+	if !force && stat == c.locationsStat {
+		return nil
+	}
+	locations, ly, err := readLocations(c.locationsFile)
+	if err != nil {
+		return err
+	}
+	c.locations = locations
+	c.locationsYaml = ly
+	c.locationsStat = stat
+	slog.Info("Reloaded locations", "count", len(locations), "file", c.locationsFile)
+	return nil
 }
 
 // GetLocations returns a copy of all actively exported locations.
@@ -97,42 +503,99 @@ func (c *ConfigService) GetLocations() []Location {
 	return locsCopy
 }
 
-// AddLocation adds a new location to be regularly exported. It is saved to the config file.
-func (c *ConfigService) AddLocation(location Location) {
+// GetLocation returns the actively exported location with the given name.
+func (c *ConfigService) GetLocation(name string) (Location, bool) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.locations = append(c.locations, location)
-	c.marshall()
+	idx := c.index(name)
+	if idx < 0 {
+		return Location{}, false
+	}
+	return c.locations[idx], true
 }
 
-// RemoveLocation removes a location from being regularly exported, and removes it from the config file.
-func (c *ConfigService) RemoveLocation(location Location) {
+// AddLocation adds a new location to be regularly exported. It is saved to the locations file.
+// It returns ErrInvalidLocation if the location's coordinates or options are invalid,
+// ErrLocationExists if there is already a location with the same name, or an error if
+// the locations file can't be saved, in which case the location isn't added.
+func (c *ConfigService) AddLocation(location Location) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	locs := c.locations
-	idx := slices.Index(locs, location)
-	if idx > -1 {
-		c.locations = append(locs[:idx], locs[idx+1:]...)
-		c.marshall()
+	if err := c.reloadLocations(false); err != nil {
+		return fmt.Errorf("not saving over a locations file with errors: %w", err)
 	}
+	if err := ValidateLocations([]Location{location}); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidLocation, err)
+	}
+	if c.index(location.Name) > -1 {
+		return ErrLocationExists
+	}
+	locations := append(slices.Clip(c.locations), location)
+	nodes := append(slices.Clip(c.locationsYaml.nodes), nil)
+	return c.save(locations, nodes)
 }
 
-// marshall writes the current configuration to the config file.
-// It should only be called while holding the lock.
-func (c *ConfigService) marshall() {
-	f, err := os.OpenFile(c.locationsFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		panic(fmt.Sprintf("Error opening locations file %s: %s", c.locationsFile, err))
+// UpdateLocation replaces the location with the given name, e.g. to rename it or change its coordinates.
+// It is saved to the locations file, and is left unchanged if saving fails.
+func (c *ConfigService) UpdateLocation(name string, location Location) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.reloadLocations(false); err != nil {
+		return fmt.Errorf("not saving over a locations file with errors: %w", err)
 	}
-	defer f.Close()
-	encoder := yaml.NewEncoder(f)
-	encoder.SetIndent(2)
-	err = encoder.Encode(c.locations)
-	if err != nil {
-		panic(fmt.Sprintf("Error saving locations to %s: %s", c.locationsFile, err))
+	idx := c.index(name)
+	if idx < 0 {
+		return ErrLocationNotFound
 	}
-	err = encoder.Close()
+	if err := ValidateLocations([]Location{location}); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidLocation, err)
+	}
+	if other := c.index(location.Name); other > -1 && other != idx {
+		return ErrLocationExists
+	}
+	locations := slices.Clone(c.locations)
+	locations[idx] = location
+	return c.save(locations, c.locationsYaml.nodes)
+}
+
+// RemoveLocation removes the location with the given name from being regularly exported,
+// and removes it from the locations file. It is left in place if saving fails.
+func (c *ConfigService) RemoveLocation(name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.reloadLocations(false); err != nil {
+		return fmt.Errorf("not saving over a locations file with errors: %w", err)
+	}
+	idx := c.index(name)
+	if idx < 0 {
+		return ErrLocationNotFound
+	}
+	locations := slices.Delete(slices.Clone(c.locations), idx, idx+1)
+	nodes := slices.Delete(slices.Clone(c.locationsYaml.nodes), idx, idx+1)
+	return c.save(locations, nodes)
+}
+
+// index returns the index of the location with the given name, or -1.
+// It should only be called while holding the lock.
+func (c *ConfigService) index(name string) int {
+	return slices.IndexFunc(c.locations, func(l Location) bool {
+		return l.Name == name
+	})
+}
+
+// save writes locations to the locations file, keeping its comments and a backup of the previous version,
+// then makes them the current locations. nodes are the yaml nodes of the locations, nil for new ones.
+// It should only be called while holding the lock.
+func (c *ConfigService) save(locations []Location, nodes []*yaml.Node) error {
+	data, err := c.locationsYaml.encode(locations, nodes)
 	if err != nil {
-		panic(fmt.Sprintf("Error saving locations to %s: %s", c.locationsFile, err))
+		return fmt.Errorf("error encoding locations: %w", err)
+	}
+	if err := writeFileAtomic(c.locationsFile, data, locationsBackups); err != nil {
+		return fmt.Errorf("error saving locations to %s: %w", c.locationsFile, err)
 	}
+	c.locations = locations
+	c.locationsYaml.nodes = c.locationsYaml.doc.Content[0].Content[:len(locations)]
+	c.locationsStat = statFile(c.locationsFile)
+	return nil
 }
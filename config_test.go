@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocation_Validate(t *testing.T) {
+	valid := Location{Name: "Home", Latitude: "38.9", Longitude: "-77.03"}
+	tests := []struct {
+		name   string
+		change func(l *Location)
+		err    string
+	}{
+		{name: "valid", change: func(*Location) {}},
+		{
+			name: "every option",
+			change: func(l *Location) {
+				l.Sources = []string{"nws", "metno"}
+				l.Interval = "3h"
+				l.Tags = map[string]string{"region": "east"}
+				l.Timezone = "America/New_York"
+			},
+		},
+		{name: "latitude out of range", change: func(l *Location) { l.Latitude = "91" }},
+		{name: "missing longitude", change: func(l *Location) { l.Longitude = "" }},
+		{name: "unknown source", change: func(l *Location) { l.Sources = []string{"nws", "darksky"} }, err: "unknown source darksky"},
+		{name: "invalid interval", change: func(l *Location) { l.Interval = "daily" }, err: `invalid interval: time: invalid duration "daily"`},
+		{name: "short interval", change: func(l *Location) { l.Interval = "30m" }, err: "interval must be a whole number of hours: 30m"},
+		{name: "partial hours", change: func(l *Location) { l.Interval = "90m" }, err: "interval must be a whole number of hours: 90m"},
+		{name: "empty tag", change: func(l *Location) { l.Tags = map[string]string{"": "x"} }, err: `tag name "" is not allowed`},
+		{name: "invalid timezone", change: func(l *Location) { l.Timezone = "Mars/Olympus" }, err: "invalid timezone: unknown time zone Mars/Olympus"},
+	}
+	for _, tag := range reservedTags {
+		tests = append(tests, struct {
+			name   string
+			change func(l *Location)
+			err    string
+		}{
+			name:   "reserved tag " + tag,
+			change: func(l *Location) { l.Tags = map[string]string{"region": "east", tag: "x"} },
+			err:    `tag name "` + tag + `" is not allowed`,
+		})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := valid
+			tt.change(&l)
+			err := l.validate()
+			switch {
+			case tt.err != "":
+				assert.EqualError(t, err, tt.err)
+			case l.Latitude != valid.Latitude || l.Longitude != valid.Longitude:
+				assert.Error(t, err)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+const invalidLocations = `- name: Home
+  latitude: "38.9"
+  longitude: "-77.03"
+# no name
+- latitude: "39.1"
+  longitude: "-77.2"
+- name: Home
+  latitude: "40"
+  longitude: "-78"
+# fix the coordinates
+- name: Work
+  latitude: "139.1"
+  longitude: "-77.2"
+- name: Cabin
+  latitude: "40"
+  longitude: "-78"
+  tags:
+    source: cabin
+- name: Beach
+  latitude: "38.3"
+  longitude: "-75.1"
+`
+
+func TestSkipInvalidLocations(t *testing.T) {
+	logs := captureLogs(t)
+	locations, ly, err := parseLocations([]byte(invalidLocations))
+	require.NoError(t, err)
+	locations, ly = skipInvalidLocations("locations.yaml", locations, ly)
+
+	assert.Equal(t, []Location{
+		{Name: "Home", Latitude: "38.9", Longitude: "-77.03"},
+		{Name: "Beach", Latitude: "38.3", Longitude: "-75.1"},
+	}, locations)
+	assert.Len(t, ly.nodes, 2)
+	assert.Len(t, ly.skipped, 4)
+	for _, expected := range []string{
+		"line=5 error=\"location 39.1,-77.2 has no name\"",
+		"line=7 error=\"duplicate location name Home\"",
+		"line=11 error=\"location Work: latitude 139.1 in '139.1,-77.2' is out of range -90 to 90\"",
+		"line=14 error=\"location Cabin: tag name \\\"source\\\" is not allowed\"",
+	} {
+		assert.Contains(t, logs.String(), "msg=\"Skipping invalid location\" file=locations.yaml "+expected)
+	}
+}
+
+func TestConfigService_SkippedLocationsAreKept(t *testing.T) {
+	captureLogs(t)
+	c := newTestConfigService(t, invalidLocations)
+	assert.Equal(t, []string{"Home", "Beach"}, locationNames(c.GetLocations()))
+
+	require.NoError(t, c.AddLocation(Location{Name: "Office", Latitude: "38.8", Longitude: "-77.1"}))
+	require.NoError(t, c.RemoveLocation("Beach"))
+	data, err := os.ReadFile(c.locationsFile)
+	require.NoError(t, err)
+	assert.Equal(t, `- name: Home
+  latitude: "38.9"
+  longitude: "-77.03"
+- name: Office
+  latitude: "38.8"
+  longitude: "-77.1"
+# no name
+- latitude: "39.1"
+  longitude: "-77.2"
+- name: Home
+  latitude: "40"
+  longitude: "-78"
+# fix the coordinates
+- name: Work
+  latitude: "139.1"
+  longitude: "-77.2"
+- name: Cabin
+  latitude: "40"
+  longitude: "-78"
+  tags:
+    source: cabin
+`, string(data))
+	assert.Equal(t, []string{"Home", "Office"}, locationNames(c.GetLocations()))
+	// the file still loads the same
+	require.NoError(t, c.reloadLocations(true))
+	assert.Equal(t, []string{"Home", "Office"}, locationNames(c.GetLocations()))
+}
+
+// locationNames returns the names of locations.
+func locationNames(locations []Location) []string {
+	names := make([]string, len(locations))
+	for i, l := range locations {
+		names[i] = l.Name
+	}
+	return names
+}
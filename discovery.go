@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/promql"
+	"github.com/tedpearson/ForecastMetrics/v3/proxy"
+	"github.com/tedpearson/ForecastMetrics/v3/source"
+)
+
+// DiscoveryResponse is the prometheus response format for the label, series and metadata apis.
+type DiscoveryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Data   any    `json:"data"`
+}
+
+// MetricMetadata is the prometheus description of a metric.
+type MetricMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// metricUnits are the units of forecast fields, for metric metadata.
+var metricUnits = map[string]string{
+	"temperature":               "°F",
+	"dewpoint":                  "°F",
+	"feels_like":                "°F",
+	"sky_cover":                 "ratio",
+	"wind_direction":            "degrees",
+	"wind_speed":                "mph",
+	"wind_gust":                 "mph",
+	"precipitation_probability": "ratio",
+	"precipitation_amount":      "inches",
+	"snow_amount":               "inches",
+	"ice_amount":                "inches",
+	"sun_up":                    "boolean",
+	"moon_up":                   "boolean",
+	"full_moon_ratio":           "ratio",
+	"civil_twilight":            "boolean",
+	"nautical_twilight":         "boolean",
+	"astronomical_twilight":     "boolean",
+}
+
+// discoveryLabels are the labels of series served by ForecastMetrics.
+var discoveryLabels = []string{"__name__", "location", "phenomenon", "significance", "source"}
+
+// MetricNames returns the names of all metrics that can be queried, limited to AllowedMetricNames.
+func (s *Server) MetricNames() []string {
+	names := []string{"accumulated_precip", s.PromConverter.ForecastMeasurementName + "_hazard"}
+	names = append(names, fieldMetrics(s.PromConverter.ForecastMeasurementName, source.WeatherRecord{})...)
+	names = append(names, fieldMetrics(s.PromConverter.AstronomyMeasurementName, source.AstroEvent{})...)
+	names = slices.DeleteFunc(names, func(name string) bool {
+		return !slices.ContainsFunc(s.AllowedMetricNames, func(str string) bool {
+			return strings.HasPrefix(name, str)
+		})
+	})
+	slices.Sort(names)
+	return names
+}
+
+// fieldMetrics returns a metric name for each field of a forecast struct, except Time.
+func fieldMetrics(measurement string, record any) []string {
+	t := reflect.TypeOf(record)
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name := t.Field(i).Name; name != "Time" {
+			names = append(names, measurement+"_"+strcase.ToSnake(name))
+		}
+	}
+	return names
+}
+
+// metricMetadata describes a metric for the metadata api.
+func (s *Server) metricMetadata(metric string) MetricMetadata {
+	if metric == "accumulated_precip" {
+		return MetricMetadata{
+			Type: "gauge",
+			Help: fmt.Sprintf("Running total of forecast precipitation in hours with a probability over %g",
+				s.PromConverter.PrecipProbability),
+			Unit: "inches",
+		}
+	}
+	if metric == s.PromConverter.ForecastMeasurementName+"_hazard" {
+		return MetricMetadata{
+			Type: "gauge",
+			Help: "1 while a weather hazard is in effect, labelled with NWS VTEC phenomenon and significance codes",
+		}
+	}
+	measurements := []string{s.PromConverter.ForecastMeasurementName, s.PromConverter.AstronomyMeasurementName}
+	measurement, field, _ := proxy.SplitMetricName(metric, measurements)
+	kind := "Forecast"
+	if measurement == s.PromConverter.AstronomyMeasurementName {
+		kind = "Astronomy forecast"
+	}
+	return MetricMetadata{
+		Type: "gauge",
+		Help: kind + " " + strcase.ToDelimited(field, ' '),
+		Unit: metricUnits[field],
+	}
+}
+
+// seriesLabels returns the label sets of every series that can be queried for scheduled locations.
+// Hazard series are not listed since they depend on the current forecast. Ad-hoc locations can
+// be queried but aren't listed, since they can be anything.
+func (s *Server) seriesLabels() []map[string]string {
+	series := []map[string]string{}
+	for _, metric := range s.MetricNames() {
+		if metric == s.PromConverter.ForecastMeasurementName+"_hazard" {
+			continue
+		}
+		for _, src := range s.Forecasters.Names() {
+			for _, loc := range s.ConfigService.GetLocations() {
+				if !loc.UsesSource(src) {
+					continue
+				}
+				series = append(series, map[string]string{
+					"__name__": metric,
+					"source":   src,
+					"location": loc.Name,
+				})
+			}
+		}
+	}
+	return series
+}
+
+// labelValues returns the known values of a label.
+func (s *Server) labelValues(label string) []string {
+	values := []string{}
+	switch label {
+	case "__name__":
+		values = s.MetricNames()
+	case "source":
+		values = s.Forecasters.Names()
+	case "location":
+		for _, loc := range s.ConfigService.GetLocations() {
+			values = append(values, loc.Name)
+		}
+	}
+	slices.Sort(values)
+	return slices.Compact(values)
+}
+
+// matchSeries returns the series matching any of the selectors. No selectors match all series.
+func matchSeries(series []map[string]string, selectors []string) ([]map[string]string, error) {
+	if len(selectors) == 0 {
+		return series, nil
+	}
+	all := make([]*promql.VectorSelector, 0, len(selectors))
+	for _, selector := range selectors {
+		expr, err := promql.Parse(selector)
+		if err != nil {
+			return nil, err
+		}
+		vs, ok := expr.(*promql.VectorSelector)
+		if !ok {
+			return nil, fmt.Errorf("invalid series selector: %s", selector)
+		}
+		all = append(all, vs)
+	}
+	return slices.DeleteFunc(slices.Clone(series), func(labels map[string]string) bool {
+		return !slices.ContainsFunc(all, func(vs *promql.VectorSelector) bool {
+			return vs.Matches(labels)
+		})
+	}), nil
+}
+
+// DiscoveryHandler serves the prometheus label, series and metadata apis, so that Grafana
+// can autocomplete metric names and labels.
+type DiscoveryHandler struct {
+	*Server
+}
+
+// ServeHTTP implements http.Handler by serving the prometheus label, series and metadata apis.
+func (d DiscoveryHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if !Auth(req.Header.Get("Authorization"), d.AuthToken) {
+		resp.Header().Set("WWW-Authenticate", `Basic realm="ForecastMetrics", charset="UTF-8"`)
+		resp.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	err := req.ParseForm()
+	if err != nil {
+		writeDiscovery(resp, http.StatusBadRequest, nil, err)
+		return
+	}
+	selectors := req.Form["match[]"]
+	path := req.URL.Path
+	switch {
+	case path == "/api/v1/labels":
+		if len(selectors) == 0 {
+			writeDiscovery(resp, http.StatusOK, discoveryLabels, nil)
+			return
+		}
+		series, err := matchSeries(d.seriesLabels(), selectors)
+		if err != nil {
+			writeDiscovery(resp, http.StatusBadRequest, nil, err)
+			return
+		}
+		labels := []string{}
+		for _, labelSet := range series {
+			for k := range labelSet {
+				labels = append(labels, k)
+			}
+		}
+		slices.Sort(labels)
+		writeDiscovery(resp, http.StatusOK, slices.Compact(labels), nil)
+	case strings.HasPrefix(path, "/api/v1/label/") && strings.HasSuffix(path, "/values"):
+		label := strings.TrimSuffix(strings.TrimPrefix(path, "/api/v1/label/"), "/values")
+		if len(selectors) == 0 {
+			writeDiscovery(resp, http.StatusOK, d.labelValues(label), nil)
+			return
+		}
+		series, err := matchSeries(d.seriesLabels(), selectors)
+		if err != nil {
+			writeDiscovery(resp, http.StatusBadRequest, nil, err)
+			return
+		}
+		values := []string{}
+		for _, labelSet := range series {
+			if v, ok := labelSet[label]; ok {
+				values = append(values, v)
+			}
+		}
+		slices.Sort(values)
+		writeDiscovery(resp, http.StatusOK, slices.Compact(values), nil)
+	case path == "/api/v1/series":
+		if len(selectors) == 0 {
+			writeDiscovery(resp, http.StatusBadRequest, nil, fmt.Errorf("no match[] parameter provided"))
+			return
+		}
+		series, err := matchSeries(d.seriesLabels(), selectors)
+		if err != nil {
+			writeDiscovery(resp, http.StatusBadRequest, nil, err)
+			return
+		}
+		writeDiscovery(resp, http.StatusOK, series, nil)
+	case path == "/api/v1/metadata":
+		metadata := make(map[string][]MetricMetadata)
+		limit, _ := strconv.Atoi(req.Form.Get("limit"))
+		for _, metric := range d.MetricNames() {
+			if m := req.Form.Get("metric"); m != "" && m != metric {
+				continue
+			}
+			if limit > 0 && len(metadata) >= limit {
+				break
+			}
+			metadata[metric] = []MetricMetadata{d.metricMetadata(metric)}
+		}
+		writeDiscovery(resp, http.StatusOK, metadata, nil)
+	default:
+		// don't 404 on other prometheus endpoints
+		resp.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeDiscovery writes a DiscoveryResponse with the data, or the error if not nil.
+func writeDiscovery(resp http.ResponseWriter, status int, data any, err error) {
+	dr := DiscoveryResponse{Status: "success", Data: data}
+	if err != nil {
+		slog.Warn("Error serving discovery request", "error", err)
+		dr = DiscoveryResponse{Status: "error", Error: err.Error()}
+	}
+	respJson, err := json.Marshal(dr)
+	if err != nil {
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Add("content-type", "application/json")
+	resp.WriteHeader(status)
+	_, err = resp.Write(respJson)
+	if err != nil {
+		slog.Warn("Error writing response to client", "error", err)
+	}
+}
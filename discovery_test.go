@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoveryHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		params   url.Values
+		status   int
+		expected string
+	}{
+		{
+			name:     "labels",
+			path:     "/api/v1/labels",
+			status:   http.StatusOK,
+			expected: `{"status":"success","data":["__name__","location","phenomenon","significance","source"]}`,
+		},
+		{
+			name:     "labels of matching series",
+			path:     "/api/v1/labels",
+			params:   url.Values{"match[]": {`forecast_temperature`}},
+			status:   http.StatusOK,
+			expected: `{"status":"success","data":["__name__","location","source"]}`,
+		},
+		{
+			name:     "source values",
+			path:     "/api/v1/label/source/values",
+			status:   http.StatusOK,
+			expected: `{"status":"success","data":["metno","nws"]}`,
+		},
+		{
+			name:     "location values",
+			path:     "/api/v1/label/location/values",
+			status:   http.StatusOK,
+			expected: `{"status":"success","data":["Home","Work"]}`,
+		},
+		{
+			name:     "location values of a source",
+			path:     "/api/v1/label/location/values",
+			params:   url.Values{"match[]": {`forecast_temperature{source="metno"}`}},
+			status:   http.StatusOK,
+			expected: `{"status":"success","data":["Work"]}`,
+		},
+		{
+			name:     "values of unknown label",
+			path:     "/api/v1/label/foo/values",
+			status:   http.StatusOK,
+			expected: `{"status":"success","data":[]}`,
+		},
+		{
+			name:   "series",
+			path:   "/api/v1/series",
+			params: url.Values{"match[]": {`forecast_temperature`}},
+			status: http.StatusOK,
+			expected: `{"status":"success","data":[
+				{"__name__":"forecast_temperature","location":"Work","source":"metno"},
+				{"__name__":"forecast_temperature","location":"Home","source":"nws"},
+				{"__name__":"forecast_temperature","location":"Work","source":"nws"}
+			]}`,
+		},
+		{
+			name:   "series matching any selector",
+			path:   "/api/v1/series",
+			params: url.Values{"match[]": {`astronomy_sun_up{location="Home"}`, `accumulated_precip{source="metno"}`}},
+			status: http.StatusOK,
+			expected: `{"status":"success","data":[
+				{"__name__":"accumulated_precip","location":"Work","source":"metno"},
+				{"__name__":"astronomy_sun_up","location":"Home","source":"nws"}
+			]}`,
+		},
+		{
+			name:     "series without selectors",
+			path:     "/api/v1/series",
+			status:   http.StatusBadRequest,
+			expected: `{"status":"error","error":"no match[] parameter provided","data":null}`,
+		},
+		{
+			name:     "series with invalid selector",
+			path:     "/api/v1/series",
+			params:   url.Values{"match[]": {`sum(forecast_temperature)`}},
+			status:   http.StatusBadRequest,
+			expected: `{"status":"error","error":"invalid series selector: sum(forecast_temperature)","data":null}`,
+		},
+		{
+			name:   "metadata",
+			path:   "/api/v1/metadata",
+			params: url.Values{"metric": {"forecast_temperature"}},
+			status: http.StatusOK,
+			expected: `{"status":"success","data":{
+				"forecast_temperature":[{"type":"gauge","help":"Forecast temperature","unit":"°F"}]
+			}}`,
+		},
+		{
+			name:   "astronomy metadata",
+			path:   "/api/v1/metadata",
+			params: url.Values{"metric": {"astronomy_full_moon_ratio"}},
+			status: http.StatusOK,
+			expected: `{"status":"success","data":{
+				"astronomy_full_moon_ratio":[{"type":"gauge","help":"Astronomy forecast full moon ratio","unit":"ratio"}]
+			}}`,
+		},
+		{
+			name:   "hazard metadata",
+			path:   "/api/v1/metadata",
+			params: url.Values{"metric": {"forecast_hazard"}},
+			status: http.StatusOK,
+			expected: `{"status":"success","data":{"forecast_hazard":[{"type":"gauge",
+				"help":"1 while a weather hazard is in effect, labelled with NWS VTEC phenomenon and significance codes",
+				"unit":""}]}}`,
+		},
+		{
+			name:   "limited metadata",
+			path:   "/api/v1/metadata",
+			params: url.Values{"limit": {"1"}},
+			status: http.StatusOK,
+			expected: `{"status":"success","data":{"accumulated_precip":[{"type":"gauge",
+				"help":"Running total of forecast precipitation in hours with a probability over 0.5",
+				"unit":"inches"}]}}`,
+		},
+		{
+			name:   "other api",
+			path:   "/api/v1/status/buildinfo",
+			status: http.StatusNoContent,
+		},
+	}
+	handler := DiscoveryHandler{newTestServer(t)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path+"?"+tt.params.Encode(), nil)
+			req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(apiToken)))
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assert.Equal(t, tt.status, resp.Code)
+			if tt.expected != "" {
+				assert.JSONEq(t, tt.expected, resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestDiscoveryHandler_Auth(t *testing.T) {
+	handler := DiscoveryHandler{newTestServer(t)}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/labels", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.NotEmpty(t, resp.Header().Get("WWW-Authenticate"))
+}
+
+func TestServer_MetricNames(t *testing.T) {
+	s := newTestServer(t)
+	s.AllowedMetricNames = []string{"astronomy"}
+	assert.Equal(t, []string{"astronomy_astronomical_twilight", "astronomy_civil_twilight", "astronomy_full_moon_ratio",
+		"astronomy_moon_up", "astronomy_nautical_twilight", "astronomy_sun_up"}, s.MetricNames())
+}
+
+func TestServer_MetricMetadata(t *testing.T) {
+	s := newTestServer(t)
+	s.PromConverter.ForecastMeasurementName = "weather_forecast"
+	s.PromConverter.AstronomyMeasurementName = "weather_forecast_astronomy"
+	tests := []struct {
+		metric   string
+		expected MetricMetadata
+	}{
+		{"weather_forecast_wind_speed", MetricMetadata{Type: "gauge", Help: "Forecast wind speed", Unit: "mph"}},
+		{"weather_forecast_astronomy_sun_up", MetricMetadata{Type: "gauge", Help: "Astronomy forecast sun up", Unit: "boolean"}},
+		{"weather_forecast_hazard", MetricMetadata{Type: "gauge",
+			Help: "1 while a weather hazard is in effect, labelled with NWS VTEC phenomenon and significance codes"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metric, func(t *testing.T) {
+			assert.Equal(t, tt.expected, s.metricMetadata(tt.metric))
+		})
+	}
+}
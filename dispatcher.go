@@ -1,45 +1,78 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"slices"
+	"sync"
 	"time"
 
 	cache "github.com/Code-Hex/go-generics-cache"
 	"github.com/Code-Hex/go-generics-cache/policy/lru"
 
+	"github.com/tedpearson/ForecastMetrics/v3/internal/logging"
 	"github.com/tedpearson/ForecastMetrics/v3/source"
 )
 
 // Dispatcher handles ad-hoc forecast requests, allowing multiple requests for a given
 // Location simultaneously, while only running one forecast thread per location at a time.
 type Dispatcher struct {
-	cache         *cache.Cache[CacheKey, Reply]
-	forecasters   map[string]source.Forecaster
+	cache *cache.Cache[CacheKey, Reply]
+	// lastGood keeps the last successful reply for each key after it expires from cache,
+	// to answer with when a source's budget is used up.
+	lastGood      *cache.Cache[CacheKey, Reply]
+	budget        *Budget
+	forecasters   *Forecasters
 	scheduler     Scheduler
 	configService *ConfigService
 	requests      chan Request
 	results       chan Result
-	awaiting      map[CacheKey]*[]Request
+	abandoned     chan Request
+	awaiting      map[CacheKey]*fetch
+	// ctx is the parent of the fetches' contexts, cancelled by cancel when shutdown times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stop stops the run loop, which closes stopped once it has answered the fetches that finished.
+	// wg tracks the goroutines it starts.
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+	wg       sync.WaitGroup
+}
+
+// fetch is a forecast being fetched, and the requests waiting for it.
+type fetch struct {
+	requests []Request
+	// cancel stops the fetch, once every request has been abandoned.
+	cancel context.CancelFunc
 }
 
 // CacheKey represents the key used in the request cache
 type CacheKey struct {
-	Location Location
-	Source   string
+	Name      string
+	Latitude  string
+	Longitude string
+	Source    string
 }
 
 // Request represents a call to Dispatcher.GetForecast
 type Request struct {
 	CacheKey
-	AdHoc bool
-	Reply chan Reply
+	// Location is the requested location, including the options to schedule it with if it isn't AdHoc.
+	Location Location
+	AdHoc    bool
+	Reply    chan Reply
+	// RequestID is the id of the http request asking for the forecast, if any, which is logged while fetching it.
+	RequestID string
 }
 
 // Result represents a result from a Forecaster
 type Result struct {
 	CacheKey
 	Reply Reply
+	fetch *fetch
 }
 
 // Reply represents a reply to a call to Dispatcher.GetForecast
@@ -49,16 +82,25 @@ type Reply struct {
 }
 
 // NewDispatcher creates a dispatcher, creating the internal channels and cache needed for operation.
-// It also starts the dispatcher goroutine.
-func NewDispatcher(forecasters map[string]source.Forecaster, configService *ConfigService, scheduler Scheduler, cacheCapacity int) *Dispatcher {
+// It also starts the dispatcher goroutine. Ad-hoc forecasts are limited by budget, if not nil.
+func NewDispatcher(forecasters *Forecasters, configService *ConfigService, scheduler Scheduler, budget *Budget,
+	cacheCapacity int) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
 	d := &Dispatcher{
 		cache:         cache.New(cache.AsLRU[CacheKey, Reply](lru.WithCapacity(cacheCapacity))),
+		lastGood:      cache.New(cache.AsLRU[CacheKey, Reply](lru.WithCapacity(cacheCapacity))),
+		budget:        budget,
 		forecasters:   forecasters,
 		scheduler:     scheduler,
 		configService: configService,
 		requests:      make(chan Request, 10),
 		results:       make(chan Result, 10),
-		awaiting:      make(map[CacheKey]*[]Request),
+		abandoned:     make(chan Request, 10),
+		awaiting:      make(map[CacheKey]*fetch),
+		ctx:           ctx,
+		cancel:        cancel,
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
 	}
 	go d.runLoop()
 	return d
@@ -67,86 +109,303 @@ func NewDispatcher(forecasters map[string]source.Forecaster, configService *Conf
 // runLoop is where forecast requests and replies are handled so that only one forecast per
 // Location is running at once.
 func (d *Dispatcher) runLoop() {
+	defer close(d.stopped)
 	for {
+		d.recordAwaiting()
 		select {
+		case <-d.stop:
+			// answer fetches that finished just before stopping
+			for {
+				select {
+				case result := <-d.results:
+					d.handleResult(result, true)
+				default:
+					return
+				}
+			}
 		case req := <-d.requests:
 			// check cache and return if cached
 			if value, ok := d.cache.Get(req.CacheKey); ok {
+				dispatcherCacheHits.Inc(req.Source)
 				req.Reply <- value
 				continue
 			}
+			dispatcherCacheMisses.Inc(req.Source)
 			// check if we are already making a request for this location and if so, add to awaiting
-			if chans, ok := d.awaiting[req.CacheKey]; ok {
-				*chans = append(*chans, req)
+			if f, ok := d.awaiting[req.CacheKey]; ok {
+				f.requests = append(f.requests, req)
 			} else {
 				// if not already making request, spawn a new goroutine to make the request and return the result
-				d.awaiting[req.CacheKey] = &[]Request{req}
-				go d.forwardRequest(req.CacheKey)
+				ctx := d.ctx
+				if req.RequestID != "" {
+					ctx = logging.WithRequestID(ctx, req.RequestID)
+				}
+				ctx, cancel := context.WithCancel(ctx)
+				f := &fetch{requests: []Request{req}, cancel: cancel}
+				d.awaiting[req.CacheKey] = f
+				d.wg.Add(1)
+				go func() {
+					defer d.wg.Done()
+					d.forwardRequest(ctx, f, req.CacheKey, req.Location, req.AdHoc)
+				}()
 			}
-		case result := <-d.results:
-			// if newly registered location, async (update metrics, update configuration)
-			awaiting := *d.awaiting[result.CacheKey]
-			delete(d.awaiting, result.CacheKey)
-			adhoc := slices.ContainsFunc(awaiting, func(r Request) bool {
-				return !r.AdHoc
-			})
-			// don't allow schedule updates if no name specified
-			if adhoc && result.Location.Name != "" {
-				go d.addScheduledLocation(result.Location)
+		case req := <-d.abandoned:
+			// stop waiting for the forecast, and stop fetching it if nobody else is waiting
+			f, ok := d.awaiting[req.CacheKey]
+			if !ok {
+				continue
 			}
-			// update cache (for both adhoc and registered, there might be another request before the update config finishes)
-			d.cache.Set(result.CacheKey, result.Reply, cache.WithExpiration(time.Hour))
-			// return result
-			for _, a := range awaiting {
-				a.Reply <- result.Reply
+			f.requests = slices.DeleteFunc(f.requests, func(r Request) bool {
+				return r.Reply == req.Reply
+			})
+			if len(f.requests) == 0 {
+				f.cancel()
+				delete(d.awaiting, req.CacheKey)
 			}
+		case result := <-d.results:
+			d.handleResult(result, false)
 		}
 	}
 }
 
+// handleResult answers the requests waiting for a fetch, caches the reply, and saves the location if it was
+// requested, unless the dispatcher is stopping. It should only be called by the run loop.
+func (d *Dispatcher) handleResult(result Result, stopping bool) {
+	result.fetch.cancel()
+	if d.awaiting[result.CacheKey] != result.fetch {
+		// every request was abandoned
+		return
+	}
+	// if newly registered location, async (update metrics, update configuration)
+	awaiting := result.fetch.requests
+	delete(d.awaiting, result.CacheKey)
+	save := slices.IndexFunc(awaiting, func(r Request) bool {
+		return !r.AdHoc
+	})
+	// don't allow schedule updates if no name specified
+	if save > -1 && result.Name != "" {
+		if stopping {
+			slog.Warn("Not adding location to regularly updated locations while shutting down",
+				"location", result.Name)
+		} else {
+			d.saveLocation(awaiting[save].Location)
+		}
+	}
+	// update cache (for both adhoc and registered, there might be another request before the update config finishes).
+	// a used up budget isn't cached, so that requests succeed again once the budget allows.
+	if !errors.Is(result.Reply.Error, ErrBudgetExhausted) {
+		d.cache.Set(result.CacheKey, result.Reply, cache.WithExpiration(time.Hour))
+	}
+	if result.Reply.Error == nil {
+		d.lastGood.Set(result.CacheKey, result.Reply)
+	}
+	// return result
+	for _, a := range awaiting {
+		a.Reply <- result.Reply
+	}
+}
+
+// saveLocation adds a location to the config in the background.
+func (d *Dispatcher) saveLocation(location Location) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.addScheduledLocation(location)
+	}()
+}
+
+// recordAwaiting updates the metrics of forecasts being fetched and the requests waiting for them.
+func (d *Dispatcher) recordAwaiting() {
+	var waiting int
+	for _, f := range d.awaiting {
+		waiting += len(f.requests)
+	}
+	dispatcherFetches.Set(float64(len(d.awaiting)))
+	dispatcherWaiting.Set(float64(waiting))
+}
+
+// Shutdown waits for forecasts being fetched and locations being saved, then stops the run loop.
+// It should be called once nothing is calling GetForecast anymore. If ctx is done first, the fetches are
+// cancelled and the run loop is stopped, and it waits up to cancelGrace for them to return before
+// returning ctx.Err().
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		d.stopRunLoop()
+		return nil
+	case <-ctx.Done():
+	}
+	d.cancel()
+	d.stopRunLoop()
+	select {
+	case <-done:
+	case <-time.After(cancelGrace):
+	}
+	return ctx.Err()
+}
+
+// stopRunLoop stops the run loop, if it hasn't been stopped already.
+func (d *Dispatcher) stopRunLoop() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+}
+
+// sendResult puts a result on the results channel for the run loop, unless the run loop has stopped.
+func (d *Dispatcher) sendResult(result Result) {
+	select {
+	case d.results <- result:
+	case <-d.stopped:
+	}
+}
+
 // forwardRequest gets the forecast from a forecaster and puts the response on the results channel for the run loop.
-func (d *Dispatcher) forwardRequest(key CacheKey) {
-	if forecaster, ok := d.forecasters[key.Source]; ok {
-		fmt.Printf("Getting ad-hoc forecast for %s from %T\n", key.Location.Name, forecaster)
-		forecast, err := forecaster.GetForecast(key.Location.Latitude, key.Location.Longitude)
-		d.results <- Result{
+// If the source's budget is used up, the last forecast for the location is returned instead, if there is one.
+// Only ad-hoc requests are limited to the part of the budget that isn't reserved for scheduled locations.
+// The forecaster gives up when ctx is cancelled.
+func (d *Dispatcher) forwardRequest(ctx context.Context, f *fetch, key CacheKey, location Location, adHoc bool) {
+	if d.budget != nil && !d.budget.Take(key.Source, adHoc) {
+		reply, ok := d.lastGood.Get(key)
+		if ok {
+			slog.InfoContext(ctx, "Budget used up, returning the last forecast", "source", key.Source,
+				"location", location.Name)
+		} else {
+			reply = Reply{Error: fmt.Errorf("%w for %s", ErrBudgetExhausted, key.Source)}
+		}
+		d.sendResult(Result{CacheKey: key, Reply: reply, fetch: f})
+		return
+	}
+	if forecaster, ok := d.forecasters.Get(key.Source); ok {
+		slog.InfoContext(ctx, "Getting ad-hoc forecast", "location", location.Name, "source", key.Source)
+		forecast, err := fetchForecast(ctx, forecaster, location, key.Source, adHoc)
+		if err == nil {
+			AddAstronomy(forecast, location)
+		}
+		d.sendResult(Result{
 			CacheKey: key,
 			Reply: Reply{
 				Forecast: forecast,
 				Error:    err,
 			},
-		}
+			fetch: f,
+		})
 	} else {
-		d.results <- Result{
+		d.sendResult(Result{
 			CacheKey: key,
 			Reply: Reply{
 				Error: fmt.Errorf("unable to find forecast source %s", key.Source),
 			},
-		}
+			fetch: f,
+		})
 	}
 }
 
+// errDispatcherStopped is returned for forecasts requested after the dispatcher is shut down.
+var errDispatcherStopped = errors.New("dispatcher is shut down")
+
 // GetForecast requests a forecast, placing the request on the requests channel for the run loop.
-func (d *Dispatcher) GetForecast(location Location, source string, adHoc bool) (*source.Forecast, error) {
-	// send messages around
-	reply := make(chan Reply)
-	d.requests <- Request{
+// If ctx is done first, the request is abandoned, and the forecast stops being fetched if no other
+// request is waiting for it.
+func (d *Dispatcher) GetForecast(ctx context.Context, location Location, source string, adHoc bool) (*source.Forecast, error) {
+	// send messages around. buffered so the run loop doesn't block if the request is abandoned
+	reply := make(chan Reply, 1)
+	req := Request{
 		CacheKey: CacheKey{
-			Location: location,
-			Source:   source,
+			Name:      location.Name,
+			Latitude:  location.Latitude,
+			Longitude: location.Longitude,
+			Source:    source,
 		},
-		AdHoc: adHoc,
-		Reply: reply,
+		Location: location,
+		AdHoc:    adHoc,
+		Reply:    reply,
+	}
+	req.RequestID, _ = logging.RequestIDFrom(ctx)
+	select {
+	case d.requests <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-d.stopped:
+		return nil, errDispatcherStopped
 	}
 	// wait for reply back and send back msg.
-	r := <-reply
-	return r.Forecast, r.Error
+	select {
+	case r := <-reply:
+		return r.Forecast, r.Error
+	case <-ctx.Done():
+		// the run loop is gone once stopped, and nothing is fetched anymore
+		select {
+		case d.abandoned <- req:
+		case <-d.stopped:
+		}
+		return nil, ctx.Err()
+	case <-d.stopped:
+		// the reply may have been sent just before stopping
+		select {
+		case r := <-reply:
+			return r.Forecast, r.Error
+		default:
+			return nil, errDispatcherStopped
+		}
+	}
+}
+
+// SourceForecast is a forecast from a single source.
+type SourceForecast struct {
+	Source   string
+	Forecast *source.Forecast
+}
+
+// GetForecasts requests forecasts from several sources concurrently, returning them in the order of sources.
+// Sources that fail are left out, and their errors are joined in the returned error.
+// Unless adHoc is set, the location is saved once any source has succeeded.
+func (d *Dispatcher) GetForecasts(ctx context.Context, location Location, sources []string, adHoc bool) ([]SourceForecast, error) {
+	replies := make([]Reply, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// saving the location updates every source, so save it once they're done
+			forecast, err := d.GetForecast(ctx, location, src, true)
+			replies[i] = Reply{Forecast: forecast, Error: err}
+		}()
+	}
+	wg.Wait()
+	var forecasts []SourceForecast
+	var errs []error
+	for i, reply := range replies {
+		if reply.Error != nil {
+			errs = append(errs, fmt.Errorf("source %s: %w", sources[i], reply.Error))
+			continue
+		}
+		forecasts = append(forecasts, SourceForecast{Source: sources[i], Forecast: reply.Forecast})
+	}
+	// don't allow schedule updates if no name specified
+	if !adHoc && len(forecasts) > 0 && location.Name != "" {
+		select {
+		case <-d.stopped:
+			slog.Warn("Not adding location to regularly updated locations while shutting down",
+				"location", location.Name)
+		default:
+			d.saveLocation(location)
+		}
+	}
+	return forecasts, errors.Join(errs...)
 }
 
 // addScheduledLocation populates the database with the first forecast for this location,
 // then adds the location to the config.
 func (d *Dispatcher) addScheduledLocation(location Location) {
-	fmt.Printf("Adding %s to regularly updated locations in config\n", location.Name)
+	slog.Info("Adding location to regularly updated locations in config", "location", location.Name)
 	d.scheduler.UpdateForecast(location)
-	d.configService.AddLocation(location)
+	err := d.configService.AddLocation(location)
+	if err != nil {
+		slog.Error("Failed to add location to config", "location", location.Name, "error", err)
+	}
 }
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tedpearson/ForecastMetrics/v3/source"
+)
+
+// forecasterFunc adapts a function to a source.Forecaster.
+type forecasterFunc func(ctx context.Context, lat, lon string) (*source.Forecast, error)
+
+func (f forecasterFunc) GetForecast(ctx context.Context, lat, lon string) (*source.Forecast, error) {
+	return f(ctx, lat, lon)
+}
+
+var testLocation = Location{Latitude: "38.9", Longitude: "-77.03"}
+
+// newTestDispatcher creates a Dispatcher with forecasters for ad-hoc forecasts, shutting it down after the test.
+func newTestDispatcher(t *testing.T, forecasters map[string]source.Forecaster) *Dispatcher {
+	d := NewDispatcher(NewForecasters(forecasters), nil, Scheduler{}, nil, 10)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = d.Shutdown(ctx)
+	})
+	return d
+}
+
+// requestsWaiting returns whether n requests are waiting for forecasts, as last recorded by a dispatcher.
+func requestsWaiting(n int) func() bool {
+	return func() bool {
+		var b strings.Builder
+		_ = selfMetrics.WriteText(&b)
+		return strings.Contains(b.String(), "forecastmetrics_dispatcher_requests_waiting "+strconv.Itoa(n)+"\n")
+	}
+}
+
+func TestDispatcher_AbandonCancelsFetch(t *testing.T) {
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	d := newTestDispatcher(t, map[string]source.Forecaster{
+		"nws": forecasterFunc(func(ctx context.Context, _, _ string) (*source.Forecast, error) {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := d.GetForecast(ctx, testLocation, "nws", true)
+		errs <- err
+	}()
+	<-started
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("fetch wasn't cancelled after its only request was abandoned")
+	}
+}
+
+func TestDispatcher_AbandonKeepsFetchForOthers(t *testing.T) {
+	started, release, cancelled := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	d := newTestDispatcher(t, map[string]source.Forecaster{
+		"nws": forecasterFunc(func(ctx context.Context, _, _ string) (*source.Forecast, error) {
+			close(started)
+			select {
+			case <-release:
+				return &source.Forecast{}, nil
+			case <-ctx.Done():
+				close(cancelled)
+				return nil, ctx.Err()
+			}
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := d.GetForecast(ctx, testLocation, "nws", true)
+		first <- err
+	}()
+	<-started
+	second := make(chan Reply, 1)
+	go func() {
+		forecast, err := d.GetForecast(context.Background(), testLocation, "nws", true)
+		second <- Reply{Forecast: forecast, Error: err}
+	}()
+	require.Eventually(t, requestsWaiting(2), time.Second, time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+	require.Eventually(t, requestsWaiting(1), time.Second, time.Millisecond)
+	close(release)
+	reply := <-second
+	assert.NoError(t, reply.Error)
+	assert.NotNil(t, reply.Forecast)
+	select {
+	case <-cancelled:
+		t.Fatal("fetch was cancelled while a request was still waiting")
+	default:
+	}
+}
+
+func TestDispatcher_GetForecastAfterShutdown(t *testing.T) {
+	d := newTestDispatcher(t, map[string]source.Forecaster{
+		"nws": forecasterFunc(func(context.Context, string, string) (*source.Forecast, error) {
+			return &source.Forecast{}, nil
+		}),
+	})
+	require.NoError(t, d.Shutdown(context.Background()))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// more than fit in the channels to the stopped run loop
+		for range 25 {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			_, err := d.GetForecast(ctx, testLocation, "nws", true)
+			cancel()
+			assert.Error(t, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetForecast blocked after shutdown")
+	}
+}
+
+func TestDispatcher_ShutdownWaitsForFetch(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	d := newTestDispatcher(t, map[string]source.Forecaster{
+		"nws": forecasterFunc(func(context.Context, string, string) (*source.Forecast, error) {
+			close(started)
+			<-release
+			return &source.Forecast{}, nil
+		}),
+	})
+	reply := make(chan error, 1)
+	go func() {
+		_, err := d.GetForecast(context.Background(), testLocation, "nws", true)
+		reply <- err
+	}()
+	<-started
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- d.Shutdown(context.Background())
+	}()
+	close(release)
+	assert.NoError(t, <-reply)
+	assert.NoError(t, <-shutdown)
+}
+
+func TestDispatcher_ShutdownTimeout(t *testing.T) {
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	d := newTestDispatcher(t, map[string]source.Forecaster{
+		"nws": forecasterFunc(func(ctx context.Context, _, _ string) (*source.Forecast, error) {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		}),
+	})
+	reply := make(chan error, 1)
+	go func() {
+		_, err := d.GetForecast(context.Background(), testLocation, "nws", true)
+		reply <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, d.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), cancelGrace)
+	// the fetch is cancelled and the run loop stopped, so the waiting request is answered
+	<-cancelled
+	assert.Error(t, <-reply)
+	select {
+	case <-d.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("run loop wasn't stopped")
+	}
+}
+
+func TestDispatcher_GetForecasts(t *testing.T) {
+	ok := forecasterFunc(func(context.Context, string, string) (*source.Forecast, error) {
+		return &source.Forecast{}, nil
+	})
+	failing := forecasterFunc(func(context.Context, string, string) (*source.Forecast, error) {
+		return nil, errors.New("unavailable")
+	})
+	tests := []struct {
+		name        string
+		forecasters map[string]source.Forecaster
+		// cached are sources to fetch ad-hoc first
+		cached  []string
+		adHoc   bool
+		sources []string
+		err     string
+		saved   bool
+	}{
+		{
+			name:        "every source succeeds",
+			forecasters: map[string]source.Forecaster{"nws": ok, "metno": ok},
+			sources:     []string{"nws", "metno"},
+			saved:       true,
+		},
+		{
+			name:        "first source fails",
+			forecasters: map[string]source.Forecaster{"nws": failing, "metno": ok},
+			sources:     []string{"metno"},
+			err:         "source nws: unavailable",
+			saved:       true,
+		},
+		{
+			name:        "first source is cached",
+			forecasters: map[string]source.Forecaster{"nws": ok, "metno": ok},
+			cached:      []string{"nws"},
+			sources:     []string{"nws", "metno"},
+			saved:       true,
+		},
+		{
+			name:        "every source fails",
+			forecasters: map[string]source.Forecaster{"nws": failing, "metno": failing},
+			err:         "source nws: unavailable\nsource metno: unavailable",
+		},
+		{
+			name:        "ad-hoc",
+			forecasters: map[string]source.Forecaster{"nws": ok, "metno": ok},
+			adHoc:       true,
+			sources:     []string{"nws", "metno"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configService := newTestConfigService(t, apiLocations)
+			forecasters := NewForecasters(tt.forecasters)
+			scheduler := Scheduler{
+				ConfigService: configService,
+				MetricUpdater: MetricUpdater{writer: discardOutput{}},
+				Forecasters:   forecasters,
+				Pool:          NewFetchPool(SchedulerConfig{}),
+			}
+			d := NewDispatcher(forecasters, configService, scheduler, nil, 10)
+			location := Location{Name: "Cabin", Latitude: "40", Longitude: "-78"}
+			for _, src := range tt.cached {
+				_, err := d.GetForecast(context.Background(), location, src, true)
+				require.NoError(t, err)
+			}
+
+			forecasts, err := d.GetForecasts(context.Background(), location, []string{"nws", "metno"}, tt.adHoc)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			var sources []string
+			for _, f := range forecasts {
+				sources = append(sources, f.Source)
+			}
+			assert.Equal(t, tt.sources, sources)
+			// shutting down waits for the location to be saved
+			require.NoError(t, d.Shutdown(context.Background()))
+			_, saved := configService.GetLocation("Cabin")
+			assert.Equal(t, tt.saved, saved)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package geocode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/valyala/fastjson"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/logging"
+)
+
+// Azure looks up locations using the Azure Maps Get Geocoding API.
+type Azure struct {
+	SharedKey string
+	Client    *http.Client
+}
+
+// Geocode implements Geocoder using the Azure Maps Get Geocoding API.
+func (a Azure) Geocode(ctx context.Context, query string) (*Result, error) {
+	q := url.Values{}
+	q.Add("api-version", "2023-06-01")
+	q.Add("query", query)
+	q.Add("subscription-key", a.SharedKey)
+	client := a.Client
+	if client == nil {
+		client = defaultClient
+	}
+	// note: errors are returned with a json body, so don't use get()
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://atlas.microsoft.com/geocode?"+q.Encode(), nil)
+	if err != nil {
+		return nil, logging.RedactURLError(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// the url includes the key
+		return nil, logging.RedactURLError(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read azure response: %w", err)
+	}
+	val, err := fastjson.ParseBytes(body)
+	if err != nil {
+		slog.Debug("Failed to parse azure response", "query", query, "status", resp.Status, "body_size", len(body))
+		return nil, fmt.Errorf("failed to parse azure response: %w", err)
+	}
+	errorCode := val.GetStringBytes("error", "code")
+	errorMsg := val.GetStringBytes("error", "message")
+	if errorMsg != nil {
+		return nil, fmt.Errorf("failed to look up location '%s', error: %s, %s", query, string(errorCode), string(errorMsg))
+	}
+	record := val.Get("features", "0")
+	coords := record.GetArray("geometry", "coordinates")
+	if record == nil || len(coords) < 2 {
+		return nil, ErrNotFound
+	}
+	lat, err := coords[1].Float64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latitude of location '%s': %w", query, err)
+	}
+	lon, err := coords[0].Float64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get longitude of location '%s': %w", query, err)
+	}
+	name := record.GetStringBytes("properties", "address", "formattedAddress")
+	if name == nil {
+		return nil, fmt.Errorf("failed to look up name of location '%s'", query)
+	}
+	return &Result{
+		Name:      string(name),
+		Latitude:  lat,
+		Longitude: lon,
+	}, nil
+}
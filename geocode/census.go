@@ -0,0 +1,63 @@
+package geocode
+
+// https://geocoding.geo.census.gov/geocoder/Geocoding_Services_API.html
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const censusBaseUrl = "https://geocoding.geo.census.gov"
+
+// Census looks up US street addresses using the US Census Bureau geocoder. It doesn't require a key,
+// but only supports addresses, not city names.
+type Census struct {
+	// BaseUrl overrides the api host. Defaults to the public api.
+	BaseUrl string
+	Client  *http.Client
+}
+
+// Geocode implements Geocoder using the Census one line address api.
+func (c Census) Geocode(ctx context.Context, query string) (*Result, error) {
+	base := c.BaseUrl
+	if base == "" {
+		base = censusBaseUrl
+	}
+	q := url.Values{}
+	q.Add("address", query)
+	q.Add("benchmark", "Public_AR_Current")
+	q.Add("format", "json")
+	body, err := get(ctx, c.Client, strings.TrimSuffix(base, "/")+"/geocoder/locations/onelineaddress?"+q.Encode(), "")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	var response struct {
+		Result struct {
+			AddressMatches []struct {
+				MatchedAddress string `json:"matchedAddress"`
+				Coordinates    struct {
+					X float64 `json:"x"`
+					Y float64 `json:"y"`
+				} `json:"coordinates"`
+			} `json:"addressMatches"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, err
+	}
+	matches := response.Result.AddressMatches
+	if len(matches) == 0 {
+		return nil, ErrNotFound
+	}
+	return &Result{
+		Name:      matches[0].MatchedAddress,
+		Latitude:  matches[0].Coordinates.Y,
+		Longitude: matches[0].Coordinates.X,
+	}, nil
+}
@@ -0,0 +1,87 @@
+// Package geocode looks up the coordinates of place names, using online services or offline gazetteer files.
+package geocode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultClient is used by geocoders without a Client. Queries wait for the geocoder, so it gives up quickly.
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Result is a geocoded place.
+type Result struct {
+	// Name is the full name of the place as formatted by the geocoder, e.g. "Denver, CO, US".
+	Name      string
+	Latitude  float64
+	Longitude float64
+}
+
+// Geocoder can look up the coordinates of a place name or address, giving up when ctx is done.
+type Geocoder interface {
+	Geocode(ctx context.Context, query string) (*Result, error)
+}
+
+// ErrNotFound is returned when a Geocoder has no results for a query.
+var ErrNotFound = errors.New("location not found")
+
+// Chain is a Geocoder which tries each Geocoder in order, returning the first successful result.
+type Chain []Geocoder
+
+// Geocode implements Geocoder by returning the first successful result from the chain.
+// If every Geocoder fails, all the errors are returned. The rest of the chain is skipped once ctx is done.
+func (c Chain) Geocode(ctx context.Context, query string) (*Result, error) {
+	if len(c) == 0 {
+		return nil, errors.New("no geocoders configured")
+	}
+	var errs []error
+	for _, g := range c {
+		result, err := g.Geocode(ctx, query)
+		if err == nil {
+			return result, nil
+		}
+		errs = append(errs, fmt.Errorf("%T: %w", g, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("failed to look up location '%s': %w", query, errors.Join(errs...))
+}
+
+// get makes a GET request with the given user agent, returning the body if the status is 200.
+// Callers are responsible for closing the body.
+func get(ctx context.Context, client *http.Client, url string, userAgent string) (io.ReadCloser, error) {
+	if client == nil {
+		client = defaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("http error: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// parseFloat parses a coordinate returned as a string.
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coordinate %s: %w", s, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,176 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffline_Geocode(t *testing.T) {
+	ctx := context.Background()
+	o, err := NewOffline("testdata/cities.txt", "testdata/postal.txt", "testdata/zcta.txt")
+	require.NoError(t, err)
+	var tests = []struct {
+		query string
+		name  string
+		lat   float64
+		lon   float64
+	}{
+		{"Denver", "Denver, CO, US", 39.73915, -104.9847},
+		{"denver,  co", "Denver, CO, US", 39.73915, -104.9847},
+		{"Denver, IA", "Denver, IA, US", 42.67359, -92.3374},
+		{"Denver City", "Denver, CO, US", 39.73915, -104.9847},
+		{"Paris", "Paris, 11, FR", 48.85341, 2.3488},
+		{"Paris, TX, US", "Paris, TX, US", 33.66094, -95.55551},
+		{"20001", "Washington, DC 20001", 38.9122, -77.0177},
+		{"80202", "ZIP 80202", 39.749109, -104.994375},
+		{"75001", "Paris 01 Louvre, 11 75001", 48.8592, 2.3417},
+		{"75001, FR", "Paris 01 Louvre, 11 75001", 48.8592, 2.3417},
+		{"75001, us", "Addison, TX 75001", 32.9601, -96.8385},
+		{"75001, TX, US", "Addison, TX 75001", 32.9601, -96.8385},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			r, err := o.Geocode(ctx, test.query)
+			require.NoError(t, err)
+			assert.Equal(t, &Result{Name: test.name, Latitude: test.lat, Longitude: test.lon}, r)
+		})
+	}
+	_, err = o.Geocode(ctx, "Denver, FR")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = o.Geocode(ctx, "75001, DE")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestOffline_GeocodeCensusAfterGeoNames(t *testing.T) {
+	// GeoNames postal codes have place names, so they are used whichever file is loaded first
+	for _, files := range [][]string{
+		{"testdata/postal.txt", "testdata/zcta.txt"},
+		{"testdata/zcta.txt", "testdata/postal.txt"},
+	} {
+		o, err := NewOffline(files...)
+		require.NoError(t, err)
+		r, err := o.Geocode(context.Background(), "20001")
+		require.NoError(t, err)
+		assert.Equal(t, &Result{Name: "Washington, DC 20001", Latitude: 38.9122, Longitude: -77.0177}, r)
+		r, err = o.Geocode(context.Background(), "75001, US")
+		require.NoError(t, err)
+		assert.Equal(t, &Result{Name: "Addison, TX 75001", Latitude: 32.9601, Longitude: -96.8385}, r)
+	}
+}
+
+func TestNewOffline_Invalid(t *testing.T) {
+	_, err := NewOffline("testdata/missing.txt")
+	assert.Error(t, err)
+	_, err = NewOffline("geocode.go")
+	assert.Error(t, err)
+}
+
+func TestNominatim_Geocode(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/search", r.URL.Path)
+		assert.Equal(t, "test agent", r.Header.Get("User-Agent"))
+		if r.URL.Query().Get("q") != "Denver, CO" {
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"place_id":1,"lat":"39.7392364","lon":"-104.984862","display_name":"Denver, Colorado, United States"}]`))
+	}))
+	defer server.Close()
+	n := &Nominatim{BaseUrl: server.URL, UserAgent: "test agent", Client: server.Client(), Interval: time.Millisecond}
+	r, err := n.Geocode(ctx, "Denver, CO")
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Name: "Denver, Colorado, United States", Latitude: 39.7392364, Longitude: -104.984862}, r)
+	_, err = n.Geocode(ctx, "Nowhere")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCensus_Geocode(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/geocoder/locations/onelineaddress", r.URL.Path)
+		_, _ = w.Write([]byte(`{"result":{"addressMatches":[{"matchedAddress":"1600 PENNSYLVANIA AVE NW, WASHINGTON, DC, 20500","coordinates":{"x":-77.03518753691,"y":38.89869893252}}]}}`))
+	}))
+	defer server.Close()
+	c := Census{BaseUrl: server.URL, Client: server.Client()}
+	r, err := c.Geocode(ctx, "1600 Pennsylvania Ave NW, Washington, DC")
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Name: "1600 PENNSYLVANIA AVE NW, WASHINGTON, DC, 20500", Latitude: 38.89869893252, Longitude: -77.03518753691}, r)
+}
+
+// geocoderFunc adapts a function to a Geocoder.
+type geocoderFunc func(string) (*Result, error)
+
+func (f geocoderFunc) Geocode(_ context.Context, query string) (*Result, error) {
+	return f(query)
+}
+
+func TestChain_Geocode(t *testing.T) {
+	ctx := context.Background()
+	failing := geocoderFunc(func(string) (*Result, error) {
+		return nil, errors.New("offline")
+	})
+	notFound := geocoderFunc(func(string) (*Result, error) {
+		return nil, ErrNotFound
+	})
+	found := geocoderFunc(func(q string) (*Result, error) {
+		return &Result{Name: q}, nil
+	})
+	r, err := Chain{failing, notFound, found}.Geocode(ctx, "somewhere")
+	require.NoError(t, err)
+	assert.Equal(t, "somewhere", r.Name)
+
+	_, err = Chain{failing, notFound}.Geocode(ctx, "somewhere")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorContains(t, err, "offline")
+
+	_, err = Chain{}.Geocode(ctx, "somewhere")
+	assert.Error(t, err)
+}
+
+func TestChain_GeocodeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	cancelling := geocoderFunc(func(string) (*Result, error) {
+		calls++
+		cancel()
+		return nil, context.Canceled
+	})
+	_, err := Chain{cancelling, cancelling}.Geocode(ctx, "somewhere")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls)
+}
+
+func TestNominatim_RateLimit(t *testing.T) {
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, time.Now())
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	n := &Nominatim{BaseUrl: server.URL, Client: server.Client(), Interval: 50 * time.Millisecond}
+	for range 3 {
+		_, err := n.Geocode(context.Background(), "Nowhere")
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	require.Len(t, requests, 3)
+	for i := 1; i < len(requests); i++ {
+		assert.GreaterOrEqual(t, requests[i].Sub(requests[i-1]), 45*time.Millisecond)
+	}
+
+	// waiting for a turn gives up when ctx is done
+	n.Interval = time.Hour
+	_, _ = n.Geocode(context.Background(), "Nowhere")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := n.Geocode(ctx, "Nowhere")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, requests, 4)
+}
@@ -0,0 +1,105 @@
+package geocode
+
+// https://nominatim.org/release-docs/latest/api/Search/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const nominatimBaseUrl = "https://nominatim.openstreetmap.org"
+
+// Nominatim looks up locations using OpenStreetMap Nominatim, either the public instance
+// (which requires an identifying UserAgent and at most 1 request per second) or a self-hosted one.
+// It must not be copied after first use, since it keeps the time of the last request.
+type Nominatim struct {
+	// BaseUrl is the Nominatim instance to use. Defaults to the public OpenStreetMap instance.
+	BaseUrl   string
+	UserAgent string
+	Client    *http.Client
+	// Interval is the least time between requests. Defaults to 1 second, as the public instance requires.
+	Interval time.Duration
+
+	lock sync.Mutex
+	// next is when the next request may be made.
+	next time.Time
+}
+
+// Geocode implements Geocoder using the Nominatim search API.
+func (n *Nominatim) Geocode(ctx context.Context, query string) (*Result, error) {
+	if err := n.wait(ctx); err != nil {
+		return nil, err
+	}
+	base := n.BaseUrl
+	if base == "" {
+		base = nominatimBaseUrl
+	}
+	q := url.Values{}
+	q.Add("q", query)
+	q.Add("format", "jsonv2")
+	q.Add("limit", "1")
+	body, err := get(ctx, n.Client, strings.TrimSuffix(base, "/")+"/search?"+q.Encode(), n.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	var results []struct {
+		Lat         string `json:"lat"`
+		Lon         string `json:"lon"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := json.NewDecoder(body).Decode(&results); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrNotFound
+	}
+	lat, err := parseFloat(results[0].Lat)
+	if err != nil {
+		return nil, err
+	}
+	lon, err := parseFloat(results[0].Lon)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Name:      results[0].DisplayName,
+		Latitude:  lat,
+		Longitude: lon,
+	}, nil
+}
+
+// wait waits until Interval has passed since the previous request, or returns ctx.Err() if ctx is done first.
+func (n *Nominatim) wait(ctx context.Context) error {
+	interval := n.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	n.lock.Lock()
+	// take the next turn, so that concurrent lookups queue up
+	start := time.Now()
+	if n.next.After(start) {
+		start = n.next
+	}
+	n.next = start.Add(interval)
+	n.lock.Unlock()
+	delay := time.Until(start)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,245 @@
+package geocode
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Offline looks up locations in gazetteer files loaded into memory, so it works without network access.
+// Supported files are:
+//   - GeoNames cities files, e.g. cities15000.txt from https://download.geonames.org/export/dump/
+//   - GeoNames postal code files, e.g. US.txt from https://download.geonames.org/export/zip/
+//   - US Census ZCTA gazetteer files, e.g. 2020_Gaz_zcta_national.txt
+//
+// Queries may be a postal code, a city name, or a city name qualified by admin1 (state) code
+// and/or country code, e.g. "Denver, CO" or "Paris, FR". The most populous match is returned.
+// Postal codes may be qualified the same way, e.g. "75001, FR", since the same code is used in
+// several countries. Without a qualifier, the code from the first file loaded is returned.
+type Offline struct {
+	places map[string][]place
+	// postal has the places with each postal code in different countries, in the order they were loaded.
+	postal map[string][]postalCode
+}
+
+// place is a city from a GeoNames cities file.
+type place struct {
+	name       string
+	admin1     string
+	country    string
+	lat        float64
+	lon        float64
+	population int64
+}
+
+// postalCode is the location of a postal code in a country.
+type postalCode struct {
+	admin1  string
+	country string
+	result  Result
+	// census is set for Census ZCTA codes, which don't replace GeoNames codes since those have place names.
+	census bool
+}
+
+// NewOffline loads the given gazetteer files.
+func NewOffline(files ...string) (*Offline, error) {
+	o := &Offline{
+		places: make(map[string][]place),
+		postal: make(map[string][]postalCode),
+	}
+	for _, file := range files {
+		if err := o.load(file); err != nil {
+			return nil, fmt.Errorf("error loading gazetteer %s: %w", file, err)
+		}
+	}
+	return o, nil
+}
+
+// load loads a single gazetteer file, detecting its format by the number of columns.
+func (o *Offline) load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var header map[string]int
+	line := 0
+	for scanner.Scan() {
+		line++
+		cols := strings.Split(scanner.Text(), "\t")
+		for i := range cols {
+			cols[i] = strings.TrimSpace(cols[i])
+		}
+		if line == 1 && cols[0] == "GEOID" {
+			// census gazetteer header
+			header = make(map[string]int)
+			for i, c := range cols {
+				header[c] = i
+			}
+			continue
+		}
+		switch {
+		case header != nil:
+			err = o.addCensus(header, cols)
+		case len(cols) == 19:
+			err = o.addCity(cols)
+		case len(cols) == 12:
+			err = o.addPostal(cols)
+		default:
+			err = fmt.Errorf("unknown format with %d columns", len(cols))
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// addCity adds a line from a GeoNames cities file.
+func (o *Offline) addCity(cols []string) error {
+	lat, err := parseFloat(cols[4])
+	if err != nil {
+		return err
+	}
+	lon, err := parseFloat(cols[5])
+	if err != nil {
+		return err
+	}
+	population, _ := strconv.ParseInt(cols[14], 10, 64)
+	p := place{
+		name:       cols[1],
+		admin1:     cols[10],
+		country:    cols[8],
+		lat:        lat,
+		lon:        lon,
+		population: population,
+	}
+	names := map[string]bool{normalize(cols[1]): true, normalize(cols[2]): true}
+	for _, alt := range strings.Split(cols[3], ",") {
+		if alt != "" {
+			names[normalize(alt)] = true
+		}
+	}
+	for name := range names {
+		o.places[name] = append(o.places[name], p)
+	}
+	return nil
+}
+
+// addPostal adds a line from a GeoNames postal code file.
+func (o *Offline) addPostal(cols []string) error {
+	lat, err := parseFloat(cols[9])
+	if err != nil {
+		return err
+	}
+	lon, err := parseFloat(cols[10])
+	if err != nil {
+		return err
+	}
+	o.addPostalCode(cols[1], postalCode{
+		admin1:  cols[4],
+		country: cols[0],
+		result: Result{
+			Name:      fmt.Sprintf("%s, %s %s", cols[2], cols[4], cols[1]),
+			Latitude:  lat,
+			Longitude: lon,
+		},
+	})
+	return nil
+}
+
+// addCensus adds a line from a Census ZCTA gazetteer file.
+func (o *Offline) addCensus(header map[string]int, cols []string) error {
+	geoid, latI, lonI := header["GEOID"], header["INTPTLAT"], header["INTPTLONG"]
+	if latI == 0 || lonI == 0 || max(geoid, latI, lonI) >= len(cols) {
+		return fmt.Errorf("missing GEOID, INTPTLAT or INTPTLONG column")
+	}
+	lat, err := parseFloat(cols[latI])
+	if err != nil {
+		return err
+	}
+	lon, err := parseFloat(cols[lonI])
+	if err != nil {
+		return err
+	}
+	o.addPostalCode(cols[geoid], postalCode{
+		country: "US",
+		result: Result{
+			Name:      "ZIP " + cols[geoid],
+			Latitude:  lat,
+			Longitude: lon,
+		},
+		census: true,
+	})
+	return nil
+}
+
+// addPostalCode adds a postal code, replacing the same code in the same country unless only the existing one
+// is from GeoNames.
+func (o *Offline) addPostalCode(code string, pc postalCode) {
+	key := normalize(code)
+	codes := o.postal[key]
+	i := slices.IndexFunc(codes, func(c postalCode) bool {
+		return c.country == pc.country
+	})
+	switch {
+	case i < 0:
+		o.postal[key] = append(codes, pc)
+	case pc.census && !codes[i].census:
+		// keep the place name from GeoNames
+	default:
+		codes[i] = pc
+	}
+}
+
+// Geocode implements Geocoder by looking up the query in the loaded gazetteers.
+func (o *Offline) Geocode(_ context.Context, query string) (*Result, error) {
+	parts := strings.Split(query, ",")
+	for _, pc := range o.postal[normalize(parts[0])] {
+		if matchesQualifiers(pc.admin1, pc.country, parts[1:]) {
+			r := pc.result
+			return &r, nil
+		}
+	}
+	var best *place
+	for _, p := range o.places[normalize(parts[0])] {
+		if !matchesQualifiers(p.admin1, p.country, parts[1:]) {
+			continue
+		}
+		if best == nil || p.population > best.population {
+			best = &p
+		}
+	}
+	if best == nil {
+		return nil, ErrNotFound
+	}
+	return &Result{
+		Name:      fmt.Sprintf("%s, %s, %s", best.name, best.admin1, best.country),
+		Latitude:  best.lat,
+		Longitude: best.lon,
+	}, nil
+}
+
+// matchesQualifiers returns true if every qualifier is the admin1 or country code.
+func matchesQualifiers(admin1, country string, qualifiers []string) bool {
+	for _, q := range qualifiers {
+		q = normalize(q)
+		if q != normalize(admin1) && q != normalize(country) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize makes names comparable.
+func normalize(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
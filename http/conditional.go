@@ -0,0 +1,103 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	cache "github.com/Code-Hex/go-generics-cache"
+	"github.com/Code-Hex/go-generics-cache/policy/lru"
+	"github.com/cenkalti/backoff/v3"
+)
+
+// defaultConditionalEntries is the number of responses a ConditionalCache keeps if MaxEntries isn't set.
+const defaultConditionalEntries = 1000
+
+// ConditionalCache remembers responses along with their Expires and Last-Modified headers,
+// so that an api isn't requested again before the data expires, and afterward is only
+// requested conditionally with If-Modified-Since.
+// Entries are kept after they expire for If-Modified-Since, so the least recently used entries
+// are removed once there are MaxEntries of them. The zero value is ready to use.
+type ConditionalCache struct {
+	// MaxEntries is the number of responses to keep. Defaults to 1000.
+	MaxEntries int
+	once       sync.Once
+	entries    *cache.Cache[string, conditionalEntry]
+}
+
+// conditionalEntry is a cached response body and its caching headers.
+type conditionalEntry struct {
+	body         []byte
+	lastModified string
+	expires      time.Time
+}
+
+// store returns the entries, creating them on first use.
+func (c *ConditionalCache) store() *cache.Cache[string, conditionalEntry] {
+	c.once.Do(func() {
+		capacity := c.MaxEntries
+		if capacity <= 0 {
+			capacity = defaultConditionalEntries
+		}
+		c.entries = cache.New(cache.AsLRU[string, conditionalEntry](lru.WithCapacity(capacity)))
+	})
+	return c.entries
+}
+
+// get returns the cache entry for a url, if any.
+func (c *ConditionalCache) get(url string) (conditionalEntry, bool) {
+	return c.store().Get(url)
+}
+
+// set updates the cache entry for a url, removing the least recently used entry if the cache is full.
+func (c *ConditionalCache) set(url string, entry conditionalEntry) {
+	c.store().Set(url, entry)
+}
+
+// RetryConditionalRequest is like RetryRequest, but honors the Expires and Last-Modified headers
+// of previous responses to the same url stored in cache. Until the previous response expires,
+// its body is returned without making a request. After that, the request is made with
+// If-Modified-Since, and the previous body is returned if the server replies 304 Not Modified.
+// Callers are responsible for closing the body.
+func (r Retryer) RetryConditionalRequest(ctx context.Context, url string, off *backoff.ExponentialBackOff,
+	cache *ConditionalCache) (io.ReadCloser, error) {
+	entry, cached := cache.get(url)
+	if cached && time.Now().Before(entry.expires) {
+		return io.NopCloser(bytes.NewReader(entry.body)), nil
+	}
+	header := http.Header{}
+	if cached && entry.lastModified != "" {
+		header.Set("If-Modified-Since", entry.lastModified)
+	}
+	var resp *http.Response
+	err := backoff.RetryNotify(r.doRequest(ctx, url, header, &resp), backoff.WithContext(off, ctx), r.OnRetry)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusNotModified && cached {
+		if lm := resp.Header.Get("Last-Modified"); lm != "" {
+			entry.lastModified = lm
+		}
+	} else {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		entry = conditionalEntry{
+			body:         body,
+			lastModified: resp.Header.Get("Last-Modified"),
+		}
+	}
+	entry.expires = time.Time{}
+	if expires, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+		entry.expires = expires
+	}
+	cache.set(url, entry)
+	return io.NopCloser(bytes.NewReader(entry.body)), nil
+}
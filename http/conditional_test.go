@@ -0,0 +1,60 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryer_RetryConditionalRequest(t *testing.T) {
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	var requests []string
+	expires := time.Now().Add(time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.URL.Path)
+		w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+		if req.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		_, _ = w.Write([]byte(req.URL.Path))
+	}))
+	defer server.Close()
+	cache := &ConditionalCache{MaxEntries: 2}
+	get := func(path string) string {
+		body, err := Retryer{Client: server.Client()}.RetryConditionalRequest(context.Background(),
+			server.URL+path, backoff.NewExponentialBackOff(), cache)
+		require.NoError(t, err)
+		defer body.Close()
+		b, err := io.ReadAll(body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	// responses aren't requested again until they expire
+	assert.Equal(t, "/a", get("/a"))
+	assert.Equal(t, "/a", get("/a"))
+	assert.Equal(t, []string{"/a"}, requests)
+
+	// the least recently used response is removed when the cache is full
+	assert.Equal(t, "/b", get("/b"))
+	assert.Equal(t, "/c", get("/c"))
+	assert.Equal(t, "/a", get("/a"))
+	assert.Equal(t, "/c", get("/c"))
+	assert.Equal(t, []string{"/a", "/b", "/c", "/a"}, requests)
+
+	// expired responses are requested with If-Modified-Since, and kept if not modified
+	expires = time.Now().Add(-time.Hour)
+	cache.set(server.URL+"/a", conditionalEntry{body: []byte("/a"), lastModified: lastModified})
+	assert.Equal(t, "/a", get("/a"))
+	assert.Equal(t, "/a", get("/a"))
+	assert.Equal(t, []string{"/a", "/b", "/c", "/a", "/a", "/a"}, requests)
+}
@@ -1,53 +1,74 @@
 package http
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/cenkalti/backoff/v3"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/logging"
 )
 
+// DefaultUserAgent identifies this project to weather apis.
+// user-agent required by weather.gov and api.met.no with contact information
+const DefaultUserAgent = "https://github.com/tedpearson/ForecastMetrics by ted@tedpearson.com"
+
 // Retryer retries an http GET request with exponential backoff.
 type Retryer struct {
 	Client *http.Client
+	// UserAgent overrides DefaultUserAgent if not empty.
+	UserAgent string
+	// OnRetry is called, if not nil, after each failed attempt that will be retried.
+	OnRetry func(err error, wait time.Duration)
 }
 
-// RetryRequest retries a given GET request with the given exponential backoff.
+// RetryRequest retries a given GET request with the given exponential backoff, until ctx is done.
 // It returns the body of the response. Callers are responsible for closing the body.
-func (r Retryer) RetryRequest(url string, off *backoff.ExponentialBackOff) (io.ReadCloser, error) {
-	var body *io.ReadCloser
-	err := backoff.Retry(r.doRequest(url, &body), off)
+func (r Retryer) RetryRequest(ctx context.Context, url string, off *backoff.ExponentialBackOff) (io.ReadCloser, error) {
+	var resp *http.Response
+	err := backoff.RetryNotify(r.doRequest(ctx, url, nil, &resp), backoff.WithContext(off, ctx), r.OnRetry)
 	if err != nil {
 		return nil, err
 	}
-	return *body, nil
+	return resp.Body, nil
 }
 
-// doRequest makes the actual http request, not retrying 4xx errors, and setting the user agent.
-// It returns the body of the request.
-func (r Retryer) doRequest(url string, body **io.ReadCloser) func() error {
+// doRequest makes the actual http request, not retrying 4xx errors, and setting the user agent
+// and any extra headers. 304 Not Modified is returned as a successful response.
+// It sets the response, whose body must be closed by the caller. The request is cancelled when ctx is done.
+func (r Retryer) doRequest(ctx context.Context, url string, header http.Header, response **http.Response) func() error {
 	return func() error {
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 		if err != nil {
 			return backoff.Permanent(err)
 		}
-		// user-agent required by weather.gov with email
-		req.Header.Set("User-Agent", "https://github.com/tedpearson/ForecastMetrics by ted@tedpearson.com")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		userAgent := r.UserAgent
+		if userAgent == "" {
+			userAgent = DefaultUserAgent
+		}
+		req.Header.Set("User-Agent", userAgent)
 		resp, err := r.Client.Do(req)
 		if err != nil {
-			return backoff.Permanent(err)
+			// errors are shown to users, so don't include keys in the url
+			return backoff.Permanent(logging.RedactURLError(err))
 		}
 		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-			return backoff.Permanent(fmt.Errorf("http error for url %s: %s", url, resp.Status))
+			_ = resp.Body.Close()
+			return backoff.Permanent(fmt.Errorf("http error for url %s: %s", logging.Redact(url), resp.Status))
 		}
-		if resp.StatusCode != 200 {
-			msg := fmt.Sprintf("Error status %d: %s", resp.StatusCode, resp.Status)
-			fmt.Println(msg)
-			return errors.New(msg)
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+			_ = resp.Body.Close()
+			slog.WarnContext(ctx, "Error status from server", "url", url, "status", resp.Status)
+			return fmt.Errorf("error status %d: %s", resp.StatusCode, resp.Status)
 		}
-		*body = &resp.Body
+		*response = resp
 		return nil
 	}
 }
@@ -0,0 +1,282 @@
+// Package astronomy computes sun and moon positions, rise and set times, twilight and lunar phase
+// for a geo coordinate, without any network access.
+//
+// The formulas are based on https://github.com/mourner/suncalc, which in turn is based on
+// https://aa.quae.nl/en/reken/zonpositie.html and https://aa.quae.nl/en/reken/hemelpositie.html.
+// Accuracy is within a few minutes for rise and set times, which is plenty for dashboards.
+package astronomy
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	rad     = math.Pi / 180
+	dayMs   = 1000 * 60 * 60 * 24
+	j1970   = 2440588.0
+	j2000   = 2451545.0
+	j0      = 0.0009
+	obliq   = rad * 23.4397 // obliquity of the Earth
+	sunDist = 149598000.0   // km
+)
+
+// Altitudes of the sun and moon used for rise, set and twilight times, in degrees.
+const (
+	SunriseAngle      = -0.833
+	CivilAngle        = -6.0
+	NauticalAngle     = -12.0
+	AstronomicalAngle = -18.0
+	// MoonriseAngle is the altitude of the moon at moonrise and moonset, accounting for
+	// the moon's radius. MoonAltitude is already corrected for refraction.
+	MoonriseAngle = 0.133
+)
+
+// SunTimes are the sun events for a single day. A zero time means the event doesn't happen
+// that day, e.g. during polar day or night.
+type SunTimes struct {
+	SolarNoon        time.Time
+	Sunrise          time.Time
+	Sunset           time.Time
+	CivilDawn        time.Time
+	CivilDusk        time.Time
+	NauticalDawn     time.Time
+	NauticalDusk     time.Time
+	AstronomicalDawn time.Time
+	AstronomicalDusk time.Time
+}
+
+// MoonTimes are the moon rise and set within 24 hours of the start time. A zero time means
+// the event doesn't happen in that period.
+type MoonTimes struct {
+	Rise time.Time
+	Set  time.Time
+}
+
+// MoonIllumination describes the moon's phase.
+type MoonIllumination struct {
+	// Fraction is the illuminated fraction of the moon, from 0 (new) to 1 (full).
+	Fraction float64
+	// Phase goes from 0 (new moon) through 0.5 (full moon) to 1 (new moon again).
+	Phase float64
+}
+
+// toJulian converts a time to a Julian date.
+func toJulian(t time.Time) float64 {
+	return float64(t.UnixMilli())/dayMs - 0.5 + j1970
+}
+
+// fromJulian converts a Julian date to a time.
+func fromJulian(j float64) time.Time {
+	return time.UnixMilli(int64(math.Round((j + 0.5 - j1970) * dayMs))).UTC()
+}
+
+// toDays converts a time to days since J2000.
+func toDays(t time.Time) float64 {
+	return toJulian(t) - j2000
+}
+
+func rightAscension(l, b float64) float64 {
+	return math.Atan2(math.Sin(l)*math.Cos(obliq)-math.Tan(b)*math.Sin(obliq), math.Cos(l))
+}
+
+func declination(l, b float64) float64 {
+	return math.Asin(math.Sin(b)*math.Cos(obliq) + math.Cos(b)*math.Sin(obliq)*math.Sin(l))
+}
+
+func altitude(h, phi, dec float64) float64 {
+	return math.Asin(math.Sin(phi)*math.Sin(dec) + math.Cos(phi)*math.Cos(dec)*math.Cos(h))
+}
+
+func siderealTime(d, lw float64) float64 {
+	return rad*(280.16+360.9856235*d) - lw
+}
+
+func astroRefraction(h float64) float64 {
+	// the formula works for positive altitudes only
+	h = max(h, 0)
+	return 0.0002967 / math.Tan(h+0.00312536/(h+0.08901179))
+}
+
+func solarMeanAnomaly(d float64) float64 {
+	return rad * (357.5291 + 0.98560028*d)
+}
+
+func eclipticLongitude(m float64) float64 {
+	// equation of center
+	c := rad * (1.9148*math.Sin(m) + 0.02*math.Sin(2*m) + 0.0003*math.Sin(3*m))
+	// perihelion of the Earth
+	p := rad * 102.9372
+	return m + c + p + math.Pi
+}
+
+// sunCoords returns the sun's declination and right ascension.
+func sunCoords(d float64) (dec, ra float64) {
+	l := eclipticLongitude(solarMeanAnomaly(d))
+	return declination(l, 0), rightAscension(l, 0)
+}
+
+// SunAltitude returns the altitude of the sun above the horizon in degrees.
+func SunAltitude(t time.Time, lat, lon float64) float64 {
+	lw := rad * -lon
+	phi := rad * lat
+	d := toDays(t)
+	dec, ra := sunCoords(d)
+	h := siderealTime(d, lw) - ra
+	return altitude(h, phi, dec) / rad
+}
+
+func julianCycle(d, lw float64) float64 {
+	return math.Round(d - j0 - lw/(2*math.Pi))
+}
+
+func approxTransit(ht, lw, n float64) float64 {
+	return j0 + (ht+lw)/(2*math.Pi) + n
+}
+
+func solarTransitJ(ds, m, l float64) float64 {
+	return j2000 + ds + 0.0053*math.Sin(m) - 0.0069*math.Sin(2*l)
+}
+
+func hourAngle(h, phi, dec float64) float64 {
+	return math.Acos((math.Sin(h) - math.Sin(phi)*math.Sin(dec)) / (math.Cos(phi) * math.Cos(dec)))
+}
+
+// GetSunTimes returns the sun events for the solar day nearest to t.
+func GetSunTimes(t time.Time, lat, lon float64) SunTimes {
+	lw := rad * -lon
+	phi := rad * lat
+	d := toDays(t)
+	n := julianCycle(d, lw)
+	ds := approxTransit(0, lw, n)
+	m := solarMeanAnomaly(ds)
+	l := eclipticLongitude(m)
+	dec := declination(l, 0)
+	jNoon := solarTransitJ(ds, m, l)
+
+	// riseSet returns the rise and set times for the sun at the given angle
+	riseSet := func(angle float64) (time.Time, time.Time) {
+		w := hourAngle(angle*rad, phi, dec)
+		if math.IsNaN(w) {
+			return time.Time{}, time.Time{}
+		}
+		jSet := solarTransitJ(approxTransit(w, lw, n), m, l)
+		jRise := jNoon - (jSet - jNoon)
+		return fromJulian(jRise), fromJulian(jSet)
+	}
+	times := SunTimes{SolarNoon: fromJulian(jNoon)}
+	times.Sunrise, times.Sunset = riseSet(SunriseAngle)
+	times.CivilDawn, times.CivilDusk = riseSet(CivilAngle)
+	times.NauticalDawn, times.NauticalDusk = riseSet(NauticalAngle)
+	times.AstronomicalDawn, times.AstronomicalDusk = riseSet(AstronomicalAngle)
+	return times
+}
+
+// moonCoords returns the moon's declination, right ascension, and distance in km.
+func moonCoords(d float64) (dec, ra, dist float64) {
+	l := rad * (218.316 + 13.176396*d) // ecliptic longitude
+	m := rad * (134.963 + 13.064993*d) // mean anomaly
+	f := rad * (93.272 + 13.229350*d)  // mean distance
+
+	lng := l + rad*6.289*math.Sin(m)
+	lat := rad * 5.128 * math.Sin(f)
+	dist = 385001 - 20905*math.Cos(m)
+	return declination(lng, lat), rightAscension(lng, lat), dist
+}
+
+// MoonAltitude returns the altitude of the moon above the horizon in degrees, corrected for refraction.
+func MoonAltitude(t time.Time, lat, lon float64) float64 {
+	lw := rad * -lon
+	phi := rad * lat
+	d := toDays(t)
+	dec, ra, _ := moonCoords(d)
+	h := siderealTime(d, lw) - ra
+	alt := altitude(h, phi, dec)
+	return (alt + astroRefraction(alt)) / rad
+}
+
+// GetMoonIllumination returns the moon's phase at time t.
+func GetMoonIllumination(t time.Time) MoonIllumination {
+	d := toDays(t)
+	sDec, sRa := sunCoords(d)
+	mDec, mRa, mDist := moonCoords(d)
+
+	// geocentric elongation of the moon from the sun
+	phi := math.Acos(math.Sin(sDec)*math.Sin(mDec) + math.Cos(sDec)*math.Cos(mDec)*math.Cos(sRa-mRa))
+	// selenocentric elongation of the earth from the sun
+	inc := math.Atan2(sunDist*math.Sin(phi), mDist-sunDist*math.Cos(phi))
+	angle := math.Atan2(math.Cos(sDec)*math.Sin(sRa-mRa),
+		math.Sin(sDec)*math.Cos(mDec)-math.Cos(sDec)*math.Sin(mDec)*math.Cos(sRa-mRa))
+	sign := 1.0
+	if angle < 0 {
+		sign = -1
+	}
+	return MoonIllumination{
+		Fraction: (1 + math.Cos(inc)) / 2,
+		Phase:    0.5 + 0.5*inc*sign/math.Pi,
+	}
+}
+
+// GetMoonTimes finds the moon rise and set in the 24 hours after start, by checking the moon's
+// altitude every hour and interpolating with a quadratic through each 3 consecutive hours.
+func GetMoonTimes(start time.Time, lat, lon float64) MoonTimes {
+	hoursLater := func(h float64) time.Time {
+		return start.Add(time.Duration(h * float64(time.Hour)))
+	}
+	alt := func(h float64) float64 {
+		return MoonAltitude(hoursLater(h), lat, lon) - MoonriseAngle
+	}
+	var rise, set *float64
+	h0 := alt(0)
+	for i := 1.0; i <= 24; i += 2 {
+		h1 := alt(i)
+		h2 := alt(i + 1)
+		a := (h0+h2)/2 - h1
+		b := (h2 - h0) / 2
+		xe := -b / (2 * a)
+		ye := (a*xe+b)*xe + h1
+		d := b*b - 4*a*h1
+		roots := 0
+		var x1, x2 float64
+		if d >= 0 {
+			dx := math.Sqrt(d) / (math.Abs(a) * 2)
+			x1 = xe - dx
+			x2 = xe + dx
+			if math.Abs(x1) <= 1 {
+				roots++
+			}
+			if math.Abs(x2) <= 1 {
+				roots++
+			}
+			if x1 < -1 {
+				x1 = x2
+			}
+		}
+		if roots == 1 {
+			v := i + x1
+			if h0 < 0 {
+				rise = &v
+			} else {
+				set = &v
+			}
+		} else if roots == 2 {
+			r, s := i+x1, i+x2
+			if ye < 0 {
+				r, s = i+x2, i+x1
+			}
+			rise, set = &r, &s
+		}
+		if rise != nil && set != nil {
+			break
+		}
+		h0 = h2
+	}
+	var times MoonTimes
+	if rise != nil {
+		times.Rise = hoursLater(*rise)
+	}
+	if set != nil {
+		times.Set = hoursLater(*set)
+	}
+	return times
+}
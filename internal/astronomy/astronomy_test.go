@@ -0,0 +1,58 @@
+package astronomy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertNear asserts that actual is within tolerance of expected.
+func assertNear(t *testing.T, expected, actual time.Time, tolerance time.Duration) {
+	t.Helper()
+	diff := actual.Sub(expected).Abs()
+	assert.LessOrEqual(t, diff, tolerance, "expected %s, got %s", expected, actual)
+}
+
+func TestGetSunTimes(t *testing.T) {
+	// Washington Monument, June 1, 2024 (times from the US Naval Observatory)
+	times := GetSunTimes(time.Date(2024, 6, 1, 16, 0, 0, 0, time.UTC), 38.8895, -77.0352)
+	assertNear(t, time.Date(2024, 6, 1, 9, 44, 0, 0, time.UTC), times.Sunrise, 3*time.Minute)
+	assertNear(t, time.Date(2024, 6, 2, 0, 28, 0, 0, time.UTC), times.Sunset, 3*time.Minute)
+	assertNear(t, time.Date(2024, 6, 1, 9, 13, 0, 0, time.UTC), times.CivilDawn, 3*time.Minute)
+	assertNear(t, time.Date(2024, 6, 2, 0, 59, 0, 0, time.UTC), times.CivilDusk, 3*time.Minute)
+	assert.True(t, times.NauticalDawn.Before(times.CivilDawn))
+	assert.True(t, times.AstronomicalDawn.Before(times.NauticalDawn))
+	assert.True(t, times.AstronomicalDusk.After(times.NauticalDusk))
+}
+
+func TestGetSunTimesPolar(t *testing.T) {
+	// Longyearbyen has midnight sun in June
+	times := GetSunTimes(time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC), 78.22, 15.65)
+	assert.True(t, times.Sunrise.IsZero())
+	assert.True(t, times.Sunset.IsZero())
+	assert.Greater(t, SunAltitude(time.Date(2024, 6, 21, 23, 0, 0, 0, time.UTC), 78.22, 15.65), 0.0)
+}
+
+func TestGetMoonIllumination(t *testing.T) {
+	// full moon on June 22, 2024 01:08 UTC
+	full := GetMoonIllumination(time.Date(2024, 6, 22, 1, 8, 0, 0, time.UTC))
+	assert.InDelta(t, 1, full.Fraction, 0.01)
+	assert.InDelta(t, 0.5, full.Phase, 0.02)
+	// new moon on June 6, 2024 12:38 UTC
+	newMoon := GetMoonIllumination(time.Date(2024, 6, 6, 12, 38, 0, 0, time.UTC))
+	assert.InDelta(t, 0, newMoon.Fraction, 0.01)
+}
+
+func TestGetMoonTimes(t *testing.T) {
+	lat, lon := 38.8895, -77.0352
+	start := time.Date(2024, 6, 1, 4, 0, 0, 0, time.UTC)
+	times := GetMoonTimes(start, lat, lon)
+	assert.False(t, times.Rise.IsZero())
+	assert.False(t, times.Set.IsZero())
+	// the moon is at the horizon at rise and set, rising and setting respectively
+	assert.InDelta(t, MoonriseAngle, MoonAltitude(times.Rise, lat, lon), 0.5)
+	assert.InDelta(t, MoonriseAngle, MoonAltitude(times.Set, lat, lon), 0.5)
+	assert.Greater(t, MoonAltitude(times.Rise.Add(time.Hour), lat, lon), MoonriseAngle)
+	assert.Less(t, MoonAltitude(times.Set.Add(time.Hour), lat, lon), MoonriseAngle)
+}
@@ -20,6 +20,10 @@ func KmhToMph(kmh float64) float64 {
 	return Round(kmh*0.6213711922, 2)
 }
 
+func MpsToMph(mps float64) float64 {
+	return Round(mps*2.2369362921, 2)
+}
+
 func PercentToRatio(percent float64) float64 {
 	return Round(percent/100, 3)
 }
@@ -0,0 +1,276 @@
+// Package coordinates parses geo coordinates written in the common human and machine formats.
+package coordinates
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/convert"
+)
+
+// Parse parses a coordinate string. Supported formats are:
+//
+//   - signed decimal degrees or integers: "38.8895,-77.0352", "38 -77"
+//   - hemisphere letters as a prefix or suffix: "38.8895N, 77.0352W", "N38.8895 W77.0352"
+//   - degrees, minutes and seconds: `38°53'22"N 77°2'7"W`, "38 53 22.2 N, 77 2 6.7 W", "38:53:22,-77:02:07"
+//   - degrees and decimal minutes: "38°53.37'N 77°2.11'W"
+//   - geohashes with a "geohash:" prefix: "geohash:u4pruydqqvj"
+//   - full Open Location Codes (plus codes): "87C4VXP7+9V"
+//
+// If s is not in one of these formats, ok is false and s should be treated as a place name.
+// If s is in one of these formats but is invalid, e.g. out of range, err describes the problem.
+func Parse(s string) (lat float64, lon float64, ok bool, err error) {
+	s = strings.TrimSpace(s)
+	if hash, found := cutPrefixFold(s, "geohash:"); found {
+		lat, lon, err = DecodeGeohash(strings.TrimSpace(hash))
+		return lat, lon, true, err
+	}
+	if plusCodeRe.MatchString(strings.ToUpper(s)) {
+		lat, lon, err = DecodePlusCode(s)
+		return lat, lon, true, err
+	}
+	return parsePair(s)
+}
+
+// cutPrefixFold is strings.CutPrefix, ignoring case.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+// axis is which coordinate a hemisphere letter applies to.
+type axis int
+
+const (
+	anyAxis axis = iota
+	latAxis
+	lonAxis
+)
+
+// component is a single parsed latitude or longitude.
+type component struct {
+	value float64
+	axis  axis
+}
+
+// parsePair parses a latitude and longitude separated by a comma or whitespace.
+func parsePair(s string) (float64, float64, bool, error) {
+	var candidates [][2]string
+	if left, right, found := strings.Cut(s, ","); found {
+		candidates = append(candidates, [2]string{left, right})
+	} else {
+		// without a comma, try splitting at each space to find the two halves
+		tokens := strings.Fields(s)
+		for i := 1; i < len(tokens); i++ {
+			candidates = append(candidates, [2]string{
+				strings.Join(tokens[:i], " "),
+				strings.Join(tokens[i:], " "),
+			})
+		}
+	}
+	var parsed [][2]component
+	for _, c := range candidates {
+		first, ok1 := parseComponent(c[0])
+		second, ok2 := parseComponent(c[1])
+		if ok1 && ok2 {
+			parsed = append(parsed, [2]component{first, second})
+		}
+	}
+	if len(parsed) == 0 {
+		return 0, 0, false, nil
+	}
+	if len(parsed) > 1 {
+		return 0, 0, true, fmt.Errorf("ambiguous coordinates '%s': separate latitude and longitude with a comma", s)
+	}
+	first, second := parsed[0][0], parsed[0][1]
+	if first.axis != anyAxis && first.axis == second.axis {
+		return 0, 0, true, fmt.Errorf("coordinates '%s' have two %s", s, axisName(first.axis))
+	}
+	// longitude may come first if labelled with a hemisphere
+	if first.axis == lonAxis || second.axis == latAxis {
+		first, second = second, first
+	}
+	lat, lon := first.value, second.value
+	if lat < -90 || lat > 90 {
+		return 0, 0, true, fmt.Errorf("latitude %s in '%s' is out of range -90 to 90", format(lat), s)
+	}
+	if lon < -180 || lon > 180 {
+		return 0, 0, true, fmt.Errorf("longitude %s in '%s' is out of range -180 to 180", format(lon), s)
+	}
+	return lat, lon, true, nil
+}
+
+// axisName returns the plural name of an axis for error messages.
+func axisName(a axis) string {
+	if a == latAxis {
+		return "latitudes"
+	}
+	return "longitudes"
+}
+
+var (
+	degreesRe = regexp.MustCompile(`^[+-]?\d+(\.\d+)?$`)
+	minSecRe  = regexp.MustCompile(`^\d+(\.\d+)?$`)
+	// symbols which separate degrees, minutes and seconds
+	dmsReplacer = strings.NewReplacer("°", " ", "º", " ", "'", " ", "′", " ", "\"", " ", "″", " ", "’", " ", "”", " ", ":", " ")
+)
+
+// parseComponent parses a single latitude or longitude in decimal degrees or degrees, minutes and seconds,
+// with an optional hemisphere letter before or after. It returns false if s is not a coordinate.
+func parseComponent(s string) (component, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return component{}, false
+	}
+	var c component
+	sign := 1.0
+	hemisphere := ""
+	if strings.ContainsAny(s[:1], "NSEW") {
+		hemisphere, s = s[:1], s[1:]
+	} else if strings.ContainsAny(s[len(s)-1:], "NSEW") {
+		hemisphere, s = s[len(s)-1:], s[:len(s)-1]
+	}
+	switch hemisphere {
+	case "N":
+		c.axis = latAxis
+	case "S":
+		c.axis = latAxis
+		sign = -1
+	case "E":
+		c.axis = lonAxis
+	case "W":
+		c.axis = lonAxis
+		sign = -1
+	}
+	fields := strings.Fields(dmsReplacer.Replace(s))
+	if len(fields) == 0 || len(fields) > 3 || !degreesRe.MatchString(fields[0]) {
+		return component{}, false
+	}
+	degrees, _ := strconv.ParseFloat(fields[0], 64)
+	if strings.HasPrefix(fields[0], "-") {
+		if hemisphere != "" {
+			// e.g. -38N is contradictory
+			return component{}, false
+		}
+		sign = -1
+		degrees = -degrees
+	}
+	// minutes and seconds must be whole numbers less than 60 if followed by a smaller unit
+	divisor := 1.0
+	for i, field := range fields[1:] {
+		if !minSecRe.MatchString(field) || strings.Contains(fields[i], ".") {
+			return component{}, false
+		}
+		v, _ := strconv.ParseFloat(field, 64)
+		if v >= 60 {
+			return component{}, false
+		}
+		divisor *= 60
+		degrees += v / divisor
+	}
+	c.value = sign * degrees
+	return c, true
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// DecodeGeohash returns the center of the area described by a geohash.
+func DecodeGeohash(hash string) (float64, float64, error) {
+	if hash == "" || len(hash) > 22 {
+		return 0, 0, fmt.Errorf("invalid geohash '%s': must be 1 to 22 characters", hash)
+	}
+	latLow, latHigh := -90.0, 90.0
+	lonLow, lonHigh := -180.0, 180.0
+	even := true
+	for _, r := range strings.ToLower(hash) {
+		idx := strings.IndexRune(geohashAlphabet, r)
+		if idx < 0 {
+			return 0, 0, fmt.Errorf("invalid geohash '%s': '%c' is not a geohash character", hash, r)
+		}
+		// each character is 5 bits, alternating between longitude and latitude
+		for bit := 4; bit >= 0; bit-- {
+			set := idx&(1<<bit) != 0
+			if even {
+				mid := (lonLow + lonHigh) / 2
+				if set {
+					lonLow = mid
+				} else {
+					lonHigh = mid
+				}
+			} else {
+				mid := (latLow + latHigh) / 2
+				if set {
+					latLow = mid
+				} else {
+					latHigh = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return round((latLow + latHigh) / 2), round((lonLow + lonHigh) / 2), nil
+}
+
+const plusCodeAlphabet = "23456789CFGHJMPQRVWX"
+
+// plusCodeRe matches full and short plus codes, including padded codes like "87C40000+".
+var plusCodeRe = regexp.MustCompile(`^[023456789CFGHJMPQRVWX]{2,8}\+[23456789CFGHJMPQRVWX]*$`)
+
+// DecodePlusCode returns the center of the area described by a full Open Location Code.
+// See https://github.com/google/open-location-code/blob/main/Documentation/Specification/olc_definition.adoc
+func DecodePlusCode(code string) (float64, float64, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	prefix, suffix, _ := strings.Cut(code, "+")
+	if len(prefix) != 8 {
+		return 0, 0, fmt.Errorf("invalid plus code '%s': short plus codes are not supported, use the full code", code)
+	}
+	// remove padding, which must be whole pairs at the end of the prefix
+	digits := strings.TrimRight(prefix, "0")
+	if strings.Contains(digits, "0") || len(digits)%2 != 0 || len(digits) < 2 || (len(digits) < 8 && suffix != "") {
+		return 0, 0, fmt.Errorf("invalid plus code '%s': bad padding", code)
+	}
+	if len(suffix) == 1 {
+		return 0, 0, fmt.Errorf("invalid plus code '%s': a single character after '+' is not allowed", code)
+	}
+	digits += suffix
+	lat, lon := -90.0, -180.0
+	latRes, lonRes := 400.0, 400.0
+	for i, r := range digits {
+		idx := float64(strings.IndexRune(plusCodeAlphabet, r))
+		if i < 10 {
+			// pairs of latitude and longitude digits in base 20
+			if i%2 == 0 {
+				latRes /= 20
+				lat += idx * latRes
+			} else {
+				lonRes /= 20
+				lon += idx * lonRes
+			}
+		} else {
+			// grid refinement: 4 columns by 5 rows
+			latRes /= 5
+			lonRes /= 4
+			lat += float64(int(idx)/4) * latRes
+			lon += float64(int(idx)%4) * lonRes
+		}
+	}
+	lat, lon = lat+latRes/2, lon+lonRes/2
+	if lat > 90 || lon > 180 {
+		return 0, 0, fmt.Errorf("invalid plus code '%s': out of range", code)
+	}
+	return round(lat), round(lon), nil
+}
+
+// round rounds decoded coordinates to 6 decimals, about 10cm.
+func round(f float64) float64 {
+	return convert.Round(f, 6)
+}
+
+// format formats a coordinate for error messages.
+func format(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,77 @@
+package coordinates
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		input string
+		lat   float64
+		lon   float64
+	}{
+		{"38.8895,-77.0352", 38.8895, -77.0352},
+		{"38.8895, -77.0352", 38.8895, -77.0352},
+		{"-33.8688 151.2093", -33.8688, 151.2093},
+		{"+38.8895,+77.0352", 38.8895, 77.0352},
+		{"38,-77", 38, -77},
+		{"38.8895N, 77.0352W", 38.8895, -77.0352},
+		{"38.8895 n 77.0352 w", 38.8895, -77.0352},
+		{"N38.8895 W77.0352", 38.8895, -77.0352},
+		{"77.0352W, 38.8895N", 38.8895, -77.0352},
+		{"33.8688S,151.2093E", -33.8688, 151.2093},
+		{`38°53'22"N 77°2'7"W`, 38.889444, -77.035278},
+		{`38° 53′ 22″ N, 77° 2′ 7″ W`, 38.889444, -77.035278},
+		{"38 53 22 N 77 2 7 W", 38.889444, -77.035278},
+		{"38:53:22,-77:02:07", 38.889444, -77.035278},
+		{"38°53.5'N 77°2.5'W", 38.891667, -77.041667},
+		{"geohash:ezs42", 42.605, -5.603},
+		{"GEOHASH: u4pruydqqvj", 57.64911, 10.40744},
+		{"7FG49QCJ+2V", 20.370062, 2.782188},
+		{"7fg49q00+", 20.375, 2.775},
+		{"8FVC9G8F+6XQ", 47.365581, 8.52498},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			lat, lon, ok, err := Parse(test.input)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.InDelta(t, test.lat, lat, 0.001)
+			assert.InDelta(t, test.lon, lon, 0.001)
+		})
+	}
+}
+
+func TestParseNotCoordinates(t *testing.T) {
+	for _, input := range []string{"Denver, CO", "Erie, PA", "Washington Monument", "20001", "S 5th St, Wes", ""} {
+		t.Run(input, func(t *testing.T) {
+			_, _, ok, err := Parse(input)
+			assert.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	var tests = []struct {
+		input string
+		err   string
+	}{
+		{"91,-77", "latitude 91 in '91,-77' is out of range -90 to 90"},
+		{"38,-181", "longitude -181 in '38,-181' is out of range -180 to 180"},
+		{"38N,77N", "coordinates '38N,77N' have two latitudes"},
+		{"38 30 20 10", "ambiguous coordinates '38 30 20 10': separate latitude and longitude with a comma"},
+		{"geohash:abc", "invalid geohash 'abc': 'a' is not a geohash character"},
+		{"VXP7+9V", "invalid plus code 'VXP7+9V': short plus codes are not supported, use the full code"},
+		{"87C40G00+", "invalid plus code '87C40G00+': bad padding"},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, _, ok, err := Parse(test.input)
+			assert.True(t, ok)
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Package cron parses standard 5 field cron schedules and matches them against times.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron schedule.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are set if the day of month or day of week field is "*". As in cron,
+	// if both are restricted, a time matches if either matches.
+	domAny, dowAny bool
+}
+
+// field is the range of values allowed in a cron field.
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// macros are the supported shorthand schedules.
+var macros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// Parse parses a cron schedule with the fields minute, hour, day of month, month and day of week.
+// Each field may be *, a number, a range such as 1-5, a step such as */3 or 0-12/2, or a comma separated
+// list of these. Sunday is 0 or 7. The macros @hourly, @daily, @midnight, @weekly and @monthly are also supported.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if macro, ok := macros[spec]; ok {
+		spec = macro
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return Schedule{}, fmt.Errorf("cron schedule %q must have %d fields", spec, len(fields))
+	}
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseField(part, fields[i])
+		if err != nil {
+			return Schedule{}, fmt.Errorf("cron schedule %q: %w", spec, err)
+		}
+		bits[i] = b
+	}
+	// sunday may be 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return Schedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(parts[2], "*"),
+		dowAny: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// parseField parses a single field, returning a bit set of the values it matches.
+func parseField(s string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepStr, f.name)
+			}
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			lo, err = parseValue(loStr, f)
+			if err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				hi, err = parseValue(hiStr, f)
+				if err != nil {
+					return 0, err
+				}
+				if hi < lo {
+					return 0, fmt.Errorf("invalid range %q in %s", rng, f.name)
+				}
+			} else if hasStep {
+				// e.g. 5/15 means every 15 starting at 5
+				hi = f.max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// parseValue parses a number in a field, checking that it's in range.
+func parseValue(s string, f field) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s", s, f.name)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s %d out of range %d-%d", f.name, v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Matches returns whether the schedule runs in the minute containing t, in t's time zone.
+func (s Schedule) Matches(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
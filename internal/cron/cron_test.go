@@ -0,0 +1,58 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatches(t *testing.T) {
+	// 2026-06-01 is a monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 6, day, hour, minute, 30, 0, time.UTC)
+	}
+	var tests = []struct {
+		spec  string
+		time  time.Time
+		match bool
+	}{
+		{"* * * * *", at(1, 13, 7), true},
+		{"@hourly", at(1, 13, 0), true},
+		{"@hourly", at(1, 13, 1), false},
+		{"0 */3 * * *", at(1, 12, 0), true},
+		{"0 */3 * * *", at(1, 13, 0), false},
+		{"0 6-18/6 * * *", at(1, 18, 0), true},
+		{"0 6-18/6 * * *", at(1, 0, 0), false},
+		{"15,45 * * * *", at(1, 2, 45), true},
+		{"5/20 * * * *", at(1, 2, 25), true},
+		{"5/20 * * * *", at(1, 2, 20), false},
+		{"0 0 * * 1-5", at(1, 0, 0), true},
+		{"0 0 * * 1-5", at(6, 0, 0), false},
+		{"0 0 * * 7", at(7, 0, 0), true},
+		{"0 0 * 6 *", at(7, 0, 0), true},
+		{"0 0 * 7 *", at(7, 0, 0), false},
+		// either day of month or day of week matches when both are restricted
+		{"0 0 15 * 0", at(7, 0, 0), true},
+		{"0 0 15 * 0", at(15, 0, 0), true},
+		{"0 0 15 * 0", at(16, 0, 0), false},
+	}
+	for _, test := range tests {
+		t.Run(test.spec+" "+test.time.Format(time.DateTime), func(t *testing.T) {
+			s, err := Parse(test.spec)
+			require.NoError(t, err)
+			assert.Equal(t, test.match, s.Matches(test.time))
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *",
+		"5-1 * * * *", "a * * * *", "@yearly"} {
+		t.Run(spec, func(t *testing.T) {
+			_, err := Parse(spec)
+			assert.Error(t, err)
+		})
+	}
+}
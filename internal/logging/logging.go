@@ -0,0 +1,206 @@
+// Package logging sets up structured logging with log/slog, with a level that can be changed while running,
+// request ids carried in contexts, and secrets redacted from every message and attribute.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// Redacted replaces secrets in logs.
+	Redacted = "REDACTED"
+	// RequestID is the attribute key of request ids.
+	RequestID = "request_id"
+)
+
+// secretParams matches url query parameters and headers that hold secrets, such as the VisualCrossing key
+// and the Azure Maps subscription key.
+var secretParams = regexp.MustCompile(`(?i)\b((?:subscription-)?key|api_?key|(?:access_|bearer_)?token|password|secret)=[^&\s"']+`)
+
+var (
+	// level is the level of the default logger, which may be changed by SetLevel.
+	level slog.LevelVar
+	// redactor removes secrets from the default logger, which may be changed by SetSecrets.
+	redactor Redactor
+)
+
+// Formats are the supported log formats. The first is the default.
+var Formats = []string{"text", "json"}
+
+// Setup makes a logger writing to w the default logger for slog, redacting secrets.
+// The level is debug, info (the default), warn or error, and the format is text (the default) or json.
+func Setup(w io.Writer, levelName, format string, secrets ...string) error {
+	l, err := ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	options := &slog.HandlerOptions{
+		Level: &level,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			return redactor.Attr(a)
+		},
+	}
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+	level.Set(l)
+	redactor.SetSecrets(secrets...)
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// ParseLevel parses a level name, defaulting to info if it is empty.
+func ParseLevel(name string) (slog.Level, error) {
+	var l slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return l, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+	}
+	return l, nil
+}
+
+// SetLevel changes the level of the default logger set up by Setup.
+func SetLevel(name string) error {
+	l, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// SetSecrets changes the secrets removed by the default logger set up by Setup.
+func SetSecrets(secrets ...string) {
+	redactor.SetSecrets(secrets...)
+}
+
+// Redact returns s with the secrets removed by the default logger replaced, for errors and other text
+// that may be shown to users.
+func Redact(s string) string {
+	return redactor.Redact(s)
+}
+
+// RedactURLError replaces secrets in the url of a *url.Error, such as those returned by http.Client,
+// and returns err. It must be called before err is wrapped, since wrapping formats the message.
+func RedactURLError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		ue.URL = Redact(ue.URL)
+	}
+	return err
+}
+
+// Redactor removes secrets from log messages and attributes. The zero value only removes secret url parameters.
+type Redactor struct {
+	lock    sync.RWMutex
+	secrets []string
+}
+
+// NewRedactor creates a Redactor that removes the given secrets, in addition to secret url parameters.
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.SetSecrets(secrets...)
+	return r
+}
+
+// SetSecrets replaces the secrets removed by the Redactor. Empty secrets are ignored.
+func (r *Redactor) SetSecrets(secrets ...string) {
+	secrets = slices.DeleteFunc(slices.Clone(secrets), func(s string) bool {
+		return s == ""
+	})
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.secrets = secrets
+}
+
+// Redact returns s with secrets replaced.
+func (r *Redactor) Redact(s string) string {
+	s = secretParams.ReplaceAllStringFunc(s, func(param string) string {
+		name, _, _ := strings.Cut(param, "=")
+		return name + "=" + Redacted
+	})
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+// Attr returns a with secrets replaced in string values, and in errors and other values formatted as strings.
+func (r *Redactor) Attr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.Redact(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			a.Value = slog.StringValue(r.Redact(v.Error()))
+		case fmt.Stringer:
+			a.Value = slog.StringValue(r.Redact(v.String()))
+		}
+	}
+	return a
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying a request id, which is added to everything logged with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request id carried by ctx, if any.
+func RequestIDFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// NewRequestID returns a random request id.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// contextHandler adds the request id from the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle implements slog.Handler.
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := RequestIDFrom(ctx); ok {
+		record.AddAttrs(slog.String(RequestID, id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler.
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
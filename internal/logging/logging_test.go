@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	r := NewRedactor("s3cret", "")
+	var tests = []struct {
+		in       string
+		expected string
+	}{
+		{"https://weather.visualcrossing.com/timeline/1,2?key=abc123&unitGroup=us",
+			"https://weather.visualcrossing.com/timeline/1,2?key=REDACTED&unitGroup=us"},
+		{"https://atlas.microsoft.com/search?api-version=1.0&subscription-key=abc123&query=x",
+			"https://atlas.microsoft.com/search?api-version=1.0&subscription-key=REDACTED&query=x"},
+		{`Get "http://localhost/?token=abc": refused`, `Get "http://localhost/?token=REDACTED": refused`},
+		{"auth failed for s3cret", "auth failed for REDACTED"},
+		{"monkey=1", "monkey=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			assert.Equal(t, tt.expected, r.Redact(tt.in))
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, Setup(&b, "warn", "json", "s3cret"))
+	ctx := WithRequestID(context.Background(), "1234")
+	slog.InfoContext(ctx, "not logged")
+	slog.WarnContext(ctx, "Failed to get forecast", "source", "visualcrossing",
+		"error", errors.New("http error for url https://example.com/?key=s3cret"))
+	assert.JSONEq(t, `{"level":"WARN","msg":"Failed to get forecast","source":"visualcrossing",
+		"error":"http error for url https://example.com/?key=REDACTED","request_id":"1234"}`,
+		removeTime(t, b.String()))
+
+	b.Reset()
+	require.NoError(t, SetLevel("debug"))
+	slog.Debug("logged")
+	assert.Contains(t, b.String(), `"msg":"logged"`)
+
+	assert.Error(t, Setup(&b, "", "xml"))
+	assert.Error(t, SetLevel("loud"))
+}
+
+// removeTime removes the time from a json log line.
+func removeTime(t *testing.T, line string) string {
+	_, after, ok := strings.Cut(line, `"time":"`)
+	require.True(t, ok)
+	_, after, ok = strings.Cut(after, `",`)
+	require.True(t, ok)
+	return "{" + after
+}
+
+func TestRedactURLError(t *testing.T) {
+	err := &url.Error{Op: "Get", URL: "https://atlas.microsoft.com/geocode?subscription-key=abc", Err: errors.New("timeout")}
+	redacted := RedactURLError(err)
+	assert.Same(t, err, redacted)
+	assert.EqualError(t, redacted, `Get "https://atlas.microsoft.com/geocode?subscription-key=REDACTED": timeout`)
+	assert.NoError(t, RedactURLError(nil))
+}
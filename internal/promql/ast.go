@@ -0,0 +1,244 @@
+// Package promql parses and evaluates a subset of PromQL over in-memory series, so that forecasts
+// for ad-hoc locations can be queried with functions, arithmetic and aggregation without a database.
+//
+// Supported are vector and range selectors with =, !=, =~ and !~ matchers, number literals,
+// arithmetic and comparison operators (with bool, on and ignoring), unary minus, aggregation
+// with by and without, and the functions listed in functions.go.
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Expr is a node of a parsed PromQL expression.
+type Expr interface {
+	String() string
+}
+
+// MatchType is the comparison of a label matcher.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher selects series by a label value. A missing label matches as the empty string, as in Prometheus.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher creates a Matcher, compiling the regular expression of regexp matchers,
+// which must match the whole value.
+func NewMatcher(name string, t MatchType, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Type: t, Value: value}
+	if t == MatchRegexp || t == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", value, err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// Matches returns true if the label value matches.
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+// String implements Stringer.
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+// NumberLiteral is a scalar such as 1.5.
+type NumberLiteral struct {
+	Val float64
+}
+
+func (n *NumberLiteral) String() string {
+	return fmt.Sprint(n.Val)
+}
+
+// VectorSelector selects series by metric name and label matchers, e.g. forecast_temperature{source="nws"}.
+// The metric name, if any, is also a matcher on __name__.
+type VectorSelector struct {
+	Name     string
+	Matchers []*Matcher
+}
+
+func (v *VectorSelector) String() string {
+	matchers := make([]string, 0, len(v.Matchers))
+	for _, m := range v.Matchers {
+		if m.Name != "__name__" || v.Name == "" {
+			matchers = append(matchers, m.String())
+		}
+	}
+	return v.Name + "{" + strings.Join(matchers, ",") + "}"
+}
+
+// Matcher returns the first matcher for a label, or nil if there isn't one.
+func (v *VectorSelector) Matcher(name string) *Matcher {
+	for _, m := range v.Matchers {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the labels match every matcher.
+func (v *VectorSelector) Matches(labels map[string]string) bool {
+	for _, m := range v.Matchers {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatrixSelector selects a range of points before each evaluation time, e.g. forecast_temperature{}[24h].
+type MatrixSelector struct {
+	Vector *VectorSelector
+	Range  time.Duration
+}
+
+func (m *MatrixSelector) String() string {
+	return fmt.Sprintf("%s[%s]", m.Vector, formatDuration(m.Range))
+}
+
+// formatDuration formats a duration in prometheus format, e.g. 1d12h.
+func formatDuration(d time.Duration) string {
+	units := []struct {
+		unit time.Duration
+		name string
+	}{{24 * time.Hour, "d"}, {time.Hour, "h"}, {time.Minute, "m"}, {time.Second, "s"}, {time.Millisecond, "ms"}}
+	var b strings.Builder
+	for _, u := range units {
+		if n := d / u.unit; n > 0 {
+			fmt.Fprintf(&b, "%d%s", n, u.name)
+			d -= n * u.unit
+		}
+	}
+	if b.Len() == 0 {
+		return "0s"
+	}
+	return b.String()
+}
+
+// Call is a function call.
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+func (c *Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.String()
+	}
+	return c.Func + "(" + strings.Join(args, ", ") + ")"
+}
+
+// UnaryExpr negates an expression.
+type UnaryExpr struct {
+	Expr Expr
+}
+
+func (u *UnaryExpr) String() string {
+	return "-" + parenthesize(u.Expr)
+}
+
+// parenthesize returns the string of an operand of an operator, in parentheses if it's an operation itself.
+func parenthesize(e Expr) string {
+	if b, ok := e.(*BinaryExpr); ok {
+		return "(" + b.String() + ")"
+	}
+	return e.String()
+}
+
+// BinaryExpr is an arithmetic or comparison operation. Vectors are matched on all labels
+// except the metric name, unless On or Ignoring labels are given.
+type BinaryExpr struct {
+	Op       string
+	LHS, RHS Expr
+	// ReturnBool makes comparisons return 0 or 1 instead of filtering.
+	ReturnBool bool
+	// On matches vectors only on MatchingLabels. Otherwise MatchingLabels are ignored.
+	On             bool
+	MatchingLabels []string
+}
+
+func (b *BinaryExpr) String() string {
+	modifiers := ""
+	if b.ReturnBool {
+		modifiers += " bool"
+	}
+	if b.On {
+		modifiers += " on(" + strings.Join(b.MatchingLabels, ", ") + ")"
+	} else if len(b.MatchingLabels) > 0 {
+		modifiers += " ignoring(" + strings.Join(b.MatchingLabels, ", ") + ")"
+	}
+	return fmt.Sprintf("%s %s%s %s", parenthesize(b.LHS), b.Op, modifiers, parenthesize(b.RHS))
+}
+
+// AggregateExpr aggregates a vector, e.g. avg by (location) (...).
+type AggregateExpr struct {
+	Op       string
+	Expr     Expr
+	Grouping []string
+	// Without groups by all labels except Grouping.
+	Without bool
+}
+
+func (a *AggregateExpr) String() string {
+	grouping := ""
+	if a.Without {
+		grouping = " without (" + strings.Join(a.Grouping, ", ") + ")"
+	} else if len(a.Grouping) > 0 {
+		grouping = " by (" + strings.Join(a.Grouping, ", ") + ")"
+	}
+	return fmt.Sprintf("%s%s (%s)", a.Op, grouping, a.Expr)
+}
+
+// Selectors returns every vector selector in an expression, including those in range selectors.
+func Selectors(expr Expr) []*VectorSelector {
+	switch e := expr.(type) {
+	case *VectorSelector:
+		return []*VectorSelector{e}
+	case *MatrixSelector:
+		return []*VectorSelector{e.Vector}
+	case *Call:
+		var selectors []*VectorSelector
+		for _, arg := range e.Args {
+			selectors = append(selectors, Selectors(arg)...)
+		}
+		return selectors
+	case *UnaryExpr:
+		return Selectors(e.Expr)
+	case *BinaryExpr:
+		return append(Selectors(e.LHS), Selectors(e.RHS)...)
+	case *AggregateExpr:
+		return Selectors(e.Expr)
+	}
+	return nil
+}
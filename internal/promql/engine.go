@@ -0,0 +1,513 @@
+package promql
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Point is a value at a time in unix seconds.
+type Point struct {
+	T int64
+	V float64
+}
+
+// Series is a set of labels, including the metric name as __name__, and points sorted by time.
+type Series struct {
+	Labels map[string]string
+	Points []Point
+}
+
+// Sample is a single value of a series at an evaluation time. T is the time of the underlying point.
+type Sample struct {
+	Labels map[string]string
+	T      int64
+	V      float64
+}
+
+// Vector is the value of an expression at a single time: a sample for each series.
+type Vector []Sample
+
+// Querier supplies the series for a vector selector. The series' labels don't need to match the
+// selector's matchers, since a Querier may interpret labels itself, e.g. by looking up a location.
+type Querier interface {
+	// Select returns the series for a vector selector, with at least the points from start to end in unix seconds.
+	// It should give up when ctx is done.
+	Select(ctx context.Context, vs *VectorSelector, start, end int64) ([]Series, error)
+}
+
+// Engine evaluates PromQL expressions over series from a Querier.
+type Engine struct {
+	// Lookback is how far back from an evaluation time a selector finds the latest point.
+	Lookback time.Duration
+	// MaxPoints limits the number of evaluation times in a query. Zero is unlimited.
+	MaxPoints int
+}
+
+// Query evaluates expr at each step from start to end, in unix seconds, returning a series for
+// each distinct set of labels in the results. An instant query has start equal to end.
+// Scalar results are returned as a single series without labels. ctx is passed to the Querier.
+func (e Engine) Query(ctx context.Context, q Querier, expr Expr, start, end, step int64) ([]Series, error) {
+	if end < start {
+		return nil, errors.New("end timestamp must not be before start time")
+	}
+	if step <= 0 {
+		if end > start {
+			return nil, errors.New("zero or negative query resolution step widths are not accepted")
+		}
+		step = 1
+	}
+	if points := (end-start)/step + 1; e.MaxPoints > 0 && points > int64(e.MaxPoints) {
+		return nil, fmt.Errorf("exceeded maximum resolution of %d points per timeseries", e.MaxPoints)
+	}
+	ev := evaluator{
+		data:     make(map[*VectorSelector][]Series),
+		lookback: int64(e.Lookback / time.Second),
+	}
+	ranges := selectorRanges(expr, e.Lookback)
+	for _, vs := range Selectors(expr) {
+		series, err := q.Select(ctx, vs, start-int64(ranges[vs]/time.Second), end)
+		if err != nil {
+			return nil, err
+		}
+		ev.data[vs] = sortPoints(series)
+	}
+	index := make(map[string]int)
+	var result []Series
+	for ts := start; ts <= end; ts += step {
+		v, err := ev.eval(expr, ts)
+		if err != nil {
+			return nil, err
+		}
+		var vector Vector
+		switch v := v.(type) {
+		case float64:
+			vector = Vector{{Labels: map[string]string{}, T: ts, V: v}}
+		case Vector:
+			vector = v
+		default:
+			return nil, errors.New("expression must return a scalar or instant vector, not a range")
+		}
+		for _, s := range vector {
+			key := labelsKey(s.Labels, nil, false)
+			i, ok := index[key]
+			if !ok {
+				i = len(result)
+				index[key] = i
+				result = append(result, Series{Labels: s.Labels})
+			}
+			result[i].Points = append(result[i].Points, Point{T: ts, V: s.V})
+		}
+	}
+	slices.SortFunc(result, func(a, b Series) int {
+		return strings.Compare(labelsKey(a.Labels, nil, false), labelsKey(b.Labels, nil, false))
+	})
+	return result, nil
+}
+
+// selectorRanges returns how far before the evaluation time each selector needs points:
+// the range of range selectors, otherwise the lookback.
+func selectorRanges(expr Expr, lookback time.Duration) map[*VectorSelector]time.Duration {
+	ranges := make(map[*VectorSelector]time.Duration)
+	for _, vs := range Selectors(expr) {
+		ranges[vs] = lookback
+	}
+	var walk func(Expr)
+	walk = func(expr Expr) {
+		switch e := expr.(type) {
+		case *MatrixSelector:
+			ranges[e.Vector] = e.Range
+		case *Call:
+			for _, arg := range e.Args {
+				walk(arg)
+			}
+		case *UnaryExpr:
+			walk(e.Expr)
+		case *BinaryExpr:
+			walk(e.LHS)
+			walk(e.RHS)
+		case *AggregateExpr:
+			walk(e.Expr)
+		}
+	}
+	walk(expr)
+	return ranges
+}
+
+// sortPoints returns the series with their points sorted by time.
+func sortPoints(series []Series) []Series {
+	sorted := make([]Series, len(series))
+	for i, s := range series {
+		points := slices.Clone(s.Points)
+		slices.SortFunc(points, func(a, b Point) int {
+			return cmp.Compare(a.T, b.T)
+		})
+		sorted[i] = Series{Labels: s.Labels, Points: points}
+	}
+	return sorted
+}
+
+// labelsKey returns a string identifying a label set. If include is true, only the named labels are used,
+// otherwise the named labels are excluded.
+func labelsKey(labels map[string]string, names []string, include bool) string {
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		if slices.Contains(names, k) != include {
+			continue
+		}
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(labels[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// evaluator evaluates an expression at a single time, with the series already fetched.
+type evaluator struct {
+	data     map[*VectorSelector][]Series
+	lookback int64
+}
+
+// eval returns a float64 for scalars, a Vector for instant vectors, or a []Series for ranges.
+func (ev *evaluator) eval(expr Expr, ts int64) (any, error) {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		return e.Val, nil
+	case *VectorSelector:
+		return ev.vector(e, ts), nil
+	case *MatrixSelector:
+		return ev.window(e, ts), nil
+	case *UnaryExpr:
+		v, err := ev.eval(e.Expr, ts)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case float64:
+			return -v, nil
+		case Vector:
+			result := make(Vector, len(v))
+			for i, s := range v {
+				result[i] = Sample{Labels: dropName(s.Labels), T: s.T, V: -s.V}
+			}
+			return result, nil
+		}
+		return nil, errors.New("unary minus is only allowed on scalars and instant vectors")
+	case *BinaryExpr:
+		return ev.binary(e, ts)
+	case *AggregateExpr:
+		v, err := ev.eval(e.Expr, ts)
+		if err != nil {
+			return nil, err
+		}
+		vector, ok := v.(Vector)
+		if !ok {
+			return nil, fmt.Errorf("%s expects an instant vector", e.Op)
+		}
+		return aggregate(e, vector), nil
+	case *Call:
+		args := make([]any, len(e.Args))
+		for i, arg := range e.Args {
+			v, err := ev.eval(arg, ts)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return functions[e.Func].call(args, ts)
+	}
+	return nil, fmt.Errorf("unsupported expression %s", expr)
+}
+
+// vector returns the latest point within the lookback period of each selected series.
+func (ev *evaluator) vector(vs *VectorSelector, ts int64) Vector {
+	var vector Vector
+	for _, s := range ev.data[vs] {
+		// index of the first point after ts
+		i := sort.Search(len(s.Points), func(i int) bool {
+			return s.Points[i].T > ts
+		})
+		if i > 0 && s.Points[i-1].T > ts-ev.lookback {
+			p := s.Points[i-1]
+			vector = append(vector, Sample{Labels: s.Labels, T: p.T, V: p.V})
+		}
+	}
+	return vector
+}
+
+// window returns the points of each selected series in the range before ts, dropping series without any.
+func (ev *evaluator) window(ms *MatrixSelector, ts int64) []Series {
+	from := ts - int64(ms.Range/time.Second)
+	var windows []Series
+	for _, s := range ev.data[ms.Vector] {
+		lo := sort.Search(len(s.Points), func(i int) bool {
+			return s.Points[i].T > from
+		})
+		hi := sort.Search(len(s.Points), func(i int) bool {
+			return s.Points[i].T > ts
+		})
+		if lo < hi {
+			windows = append(windows, Series{Labels: s.Labels, Points: s.Points[lo:hi]})
+		}
+	}
+	return windows
+}
+
+// binary evaluates a binary operation between scalars and instant vectors.
+func (ev *evaluator) binary(e *BinaryExpr, ts int64) (any, error) {
+	lhs, err := ev.eval(e.LHS, ts)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := ev.eval(e.RHS, ts)
+	if err != nil {
+		return nil, err
+	}
+	comparison := isComparison(e.Op)
+	switch l := lhs.(type) {
+	case float64:
+		switch r := rhs.(type) {
+		case float64:
+			if comparison && !e.ReturnBool {
+				return nil, errors.New("comparisons between scalars must use the bool modifier")
+			}
+			v, _ := applyOp(e.Op, l, r, e.ReturnBool)
+			return v, nil
+		case Vector:
+			return scalarVectorOp(e, r, l, true), nil
+		}
+	case Vector:
+		switch r := rhs.(type) {
+		case float64:
+			return scalarVectorOp(e, l, r, false), nil
+		case Vector:
+			return vectorVectorOp(e, l, r)
+		}
+	}
+	return nil, fmt.Errorf("operator %s is only allowed between scalars and instant vectors", e.Op)
+}
+
+// applyOp applies an operator, returning false if a comparison is false.
+// Comparisons return the left value, or 1 or 0 if returnBool is true.
+func applyOp(op string, l, r float64, returnBool bool) (float64, bool) {
+	var cmp bool
+	switch op {
+	case "+":
+		return l + r, true
+	case "-":
+		return l - r, true
+	case "*":
+		return l * r, true
+	case "/":
+		return l / r, true
+	case "%":
+		return math.Mod(l, r), true
+	case "^":
+		return math.Pow(l, r), true
+	case "==":
+		cmp = l == r
+	case "!=":
+		cmp = l != r
+	case "<":
+		cmp = l < r
+	case "<=":
+		cmp = l <= r
+	case ">":
+		cmp = l > r
+	case ">=":
+		cmp = l >= r
+	}
+	if returnBool {
+		if cmp {
+			return 1, true
+		}
+		return 0, true
+	}
+	return l, cmp
+}
+
+// scalarVectorOp applies an operator between each sample and a scalar. If swap is true,
+// the scalar is on the left. Comparisons without bool keep the vector's value.
+func scalarVectorOp(e *BinaryExpr, v Vector, scalar float64, swap bool) Vector {
+	var result Vector
+	for _, s := range v {
+		l, r := s.V, scalar
+		if swap {
+			l, r = r, l
+		}
+		value, keep := applyOp(e.Op, l, r, e.ReturnBool)
+		if !keep {
+			continue
+		}
+		labels := s.Labels
+		if isComparison(e.Op) && !e.ReturnBool {
+			value = s.V
+		} else {
+			labels = dropName(labels)
+		}
+		result = append(result, Sample{Labels: labels, T: s.T, V: value})
+	}
+	return result
+}
+
+// vectorVectorOp applies an operator between samples with matching labels, one to one.
+func vectorVectorOp(e *BinaryExpr, lhs, rhs Vector) (Vector, error) {
+	signature := func(labels map[string]string) string {
+		if e.On {
+			return labelsKey(labels, e.MatchingLabels, true)
+		}
+		return labelsKey(labels, append([]string{"__name__"}, e.MatchingLabels...), false)
+	}
+	right := make(map[string]Sample, len(rhs))
+	for _, s := range rhs {
+		sig := signature(s.Labels)
+		if _, ok := right[sig]; ok {
+			return nil, fmt.Errorf("found duplicate series for the match group on the right hand side of %s", e.Op)
+		}
+		right[sig] = s
+	}
+	seen := make(map[string]bool, len(lhs))
+	var result Vector
+	for _, l := range lhs {
+		sig := signature(l.Labels)
+		r, ok := right[sig]
+		if !ok {
+			continue
+		}
+		if seen[sig] {
+			return nil, fmt.Errorf("found duplicate series for the match group on the left hand side of %s", e.Op)
+		}
+		seen[sig] = true
+		value, keep := applyOp(e.Op, l.V, r.V, e.ReturnBool)
+		if !keep {
+			continue
+		}
+		labels := make(map[string]string, len(l.Labels))
+		for k, v := range l.Labels {
+			if e.On && !slices.Contains(e.MatchingLabels, k) {
+				continue
+			}
+			if !e.On && slices.Contains(e.MatchingLabels, k) {
+				continue
+			}
+			labels[k] = v
+		}
+		if !isComparison(e.Op) || e.ReturnBool {
+			delete(labels, "__name__")
+		}
+		result = append(result, Sample{Labels: labels, T: l.T, V: value})
+	}
+	return result, nil
+}
+
+// aggregate groups samples by labels and aggregates each group.
+func aggregate(e *AggregateExpr, v Vector) Vector {
+	type group struct {
+		labels map[string]string
+		values []float64
+	}
+	var groups []*group
+	index := make(map[string]*group)
+	for _, s := range v {
+		labels := make(map[string]string)
+		for k, val := range s.Labels {
+			if k == "__name__" {
+				continue
+			}
+			if slices.Contains(e.Grouping, k) != e.Without {
+				labels[k] = val
+			}
+		}
+		key := labelsKey(labels, nil, false)
+		g, ok := index[key]
+		if !ok {
+			g = &group{labels: labels}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.values = append(g.values, s.V)
+	}
+	result := make(Vector, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, Sample{Labels: g.labels, V: aggregateValues(e.Op, g.values)})
+	}
+	return result
+}
+
+// aggregateValues applies an aggregation or _over_time function to values.
+func aggregateValues(op string, values []float64) float64 {
+	switch op {
+	case "sum":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	case "avg":
+		return aggregateValues("sum", values) / float64(len(values))
+	case "min":
+		return slices.MinFunc(values, nanLast)
+	case "max":
+		return slices.MaxFunc(values, nanFirst)
+	case "count":
+		return float64(len(values))
+	case "stdvar", "stddev":
+		mean := aggregateValues("avg", values)
+		var sq float64
+		for _, v := range values {
+			sq += (v - mean) * (v - mean)
+		}
+		variance := sq / float64(len(values))
+		if op == "stddev" {
+			return math.Sqrt(variance)
+		}
+		return variance
+	case "last":
+		return values[len(values)-1]
+	}
+	return math.NaN()
+}
+
+// nanLast orders NaN after numbers, so min ignores NaN unless all values are NaN.
+func nanLast(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return 1
+	case math.IsNaN(b):
+		return -1
+	}
+	return cmp.Compare(a, b)
+}
+
+// nanFirst orders NaN before numbers, so max ignores NaN unless all values are NaN.
+func nanFirst(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return -1
+	case math.IsNaN(b):
+		return 1
+	}
+	return cmp.Compare(a, b)
+}
+
+// dropName returns a copy of labels without the metric name.
+func dropName(labels map[string]string) map[string]string {
+	if _, ok := labels["__name__"]; !ok {
+		return labels
+	}
+	result := maps.Clone(labels)
+	delete(result, "__name__")
+	return result
+}
@@ -0,0 +1,173 @@
+package promql
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticQuerier selects from a fixed set of series.
+type staticQuerier []Series
+
+func (s staticQuerier) Select(_ context.Context, vs *VectorSelector, _, _ int64) ([]Series, error) {
+	var selected []Series
+	for _, series := range s {
+		if vs.Matches(series.Labels) {
+			selected = append(selected, series)
+		}
+	}
+	return selected, nil
+}
+
+// hourly makes a series with a point every hour starting at 0.
+func hourly(labels map[string]string, values ...float64) Series {
+	s := Series{Labels: labels}
+	for i, v := range values {
+		s.Points = append(s.Points, Point{T: int64(i) * 3600, V: v})
+	}
+	return s
+}
+
+func TestEngine_Query(t *testing.T) {
+	data := staticQuerier{
+		hourly(map[string]string{"__name__": "forecast_temperature", "source": "nws", "location": "home"},
+			50, 60, 70, 65),
+		hourly(map[string]string{"__name__": "forecast_temperature", "source": "metno", "location": "home"},
+			52, 58, 72, 61),
+		hourly(map[string]string{"__name__": "forecast_dewpoint", "source": "nws", "location": "home"},
+			40, 45, 50, 55),
+	}
+	nws := map[string]string{"source": "nws", "location": "home"}
+	metno := map[string]string{"source": "metno", "location": "home"}
+	tests := []struct {
+		query string
+		start int64
+		end   int64
+		want  []Series
+	}{
+		{
+			query: `forecast_temperature{source="nws"}`,
+			start: 0, end: 3600,
+			want: []Series{{
+				Labels: data[0].Labels,
+				Points: []Point{{0, 50}, {3600, 60}},
+			}},
+		},
+		{
+			// dewpoint depression
+			query: `forecast_temperature{source="nws"} - forecast_dewpoint`,
+			start: 0, end: 7200,
+			want: []Series{{Labels: nws, Points: []Point{{0, 10}, {3600, 15}, {7200, 20}}}},
+		},
+		{
+			query: `max_over_time(forecast_temperature{source="metno"}[2h])`,
+			start: 3600, end: 10800,
+			want: []Series{{Labels: metno, Points: []Point{{3600, 58}, {7200, 72}, {10800, 72}}}},
+		},
+		{
+			query: `avg by (location) (forecast_temperature)`,
+			start: 0, end: 0,
+			want: []Series{{Labels: map[string]string{"location": "home"}, Points: []Point{{0, 51}}}},
+		},
+		{
+			query: `max(forecast_temperature) without (source)`,
+			start: 7200, end: 7200,
+			want: []Series{{Labels: map[string]string{"location": "home"}, Points: []Point{{7200, 72}}}},
+		},
+		{
+			query: `(forecast_temperature{source="nws"} - 32) * 5 / 9`,
+			start: 0, end: 0,
+			want: []Series{{Labels: nws, Points: []Point{{0, 10}}}},
+		},
+		{
+			// comparisons filter and keep the metric name
+			query: `forecast_temperature > 60`,
+			start: 0, end: 10800,
+			want: []Series{
+				{Labels: data[1].Labels, Points: []Point{{7200, 72}, {10800, 61}}},
+				{Labels: data[0].Labels, Points: []Point{{7200, 70}, {10800, 65}}},
+			},
+		},
+		{
+			query: `forecast_temperature{source="nws"} > bool 60`,
+			start: 0, end: 7200,
+			want: []Series{{Labels: nws, Points: []Point{{0, 0}, {3600, 0}, {7200, 1}}}},
+		},
+		{
+			query: `forecast_temperature{source="nws"} - on(location) forecast_temperature{source="metno"}`,
+			start: 0, end: 0,
+			want: []Series{{Labels: map[string]string{"location": "home"}, Points: []Point{{0, -2}}}},
+		},
+		{
+			query: `count(forecast_temperature) * 2 + 1`,
+			start: 0, end: 0,
+			want: []Series{{Labels: map[string]string{}, Points: []Point{{0, 5}}}},
+		},
+		{
+			query: `1 + 1`,
+			start: 0, end: 3600,
+			want: []Series{{Labels: map[string]string{}, Points: []Point{{0, 2}, {3600, 2}}}},
+		},
+		{
+			query: `round(clamp_max(forecast_dewpoint, 52) / 10)`,
+			start: 10800, end: 10800,
+			want: []Series{{Labels: nws, Points: []Point{{10800, 5}}}},
+		},
+		{
+			// points are only used for less than the 1 hour lookback, as forecasts are hourly
+			query: `forecast_dewpoint`,
+			start: 7200, end: 18000,
+			want: []Series{{Labels: data[2].Labels, Points: []Point{{7200, 50}, {10800, 55}}}},
+		},
+	}
+	engine := Engine{Lookback: time.Hour, MaxPoints: 100}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			require.NoError(t, err)
+			got, err := engine.Query(context.Background(), data, expr, tt.start, tt.end, 3600)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEngine_QueryErrors(t *testing.T) {
+	data := staticQuerier{
+		hourly(map[string]string{"__name__": "a", "source": "nws"}, 1),
+		hourly(map[string]string{"__name__": "a", "source": "metno"}, 2),
+	}
+	engine := Engine{Lookback: time.Hour, MaxPoints: 10}
+	tests := []struct {
+		query string
+		end   int64
+		err   string
+	}{
+		{`a - on() a`, 0, "found duplicate series"},
+		{`1 > 2`, 0, "must use the bool modifier"},
+		{`a`, 36000 * 10, "exceeded maximum resolution"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			require.NoError(t, err)
+			_, err = engine.Query(context.Background(), data, expr, 0, tt.end, 3600)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestAggregateValues(t *testing.T) {
+	values := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	assert.Equal(t, 40.0, aggregateValues("sum", values))
+	assert.Equal(t, 5.0, aggregateValues("avg", values))
+	assert.Equal(t, 2.0, aggregateValues("stddev", values))
+	assert.Equal(t, 4.0, aggregateValues("stdvar", values))
+	assert.Equal(t, 2.0, aggregateValues("min", []float64{math.NaN(), 2, 3}))
+	assert.Equal(t, 3.0, aggregateValues("max", []float64{math.NaN(), 2, 3}))
+}
@@ -0,0 +1,208 @@
+package promql
+
+import (
+	"fmt"
+	"math"
+)
+
+// argType is the type of a function argument.
+type argType int
+
+const (
+	argVector argType = iota
+	argScalar
+	argMatrix
+)
+
+// function is a PromQL function. call receives evaluated arguments: a []Series for ranges,
+// a Vector for instant vectors or a float64 for scalars.
+type function struct {
+	argTypes []argType
+	// minArgs is the number of required arguments. The rest are optional.
+	minArgs int
+	call    func(args []any, ts int64) (any, error)
+}
+
+// functions are the supported PromQL functions.
+var functions = map[string]function{
+	"avg_over_time":    overTime("avg"),
+	"min_over_time":    overTime("min"),
+	"max_over_time":    overTime("max"),
+	"sum_over_time":    overTime("sum"),
+	"count_over_time":  overTime("count"),
+	"last_over_time":   overTime("last"),
+	"stddev_over_time": overTime("stddev"),
+	"stdvar_over_time": overTime("stdvar"),
+	"abs":              mathFunc("abs", math.Abs),
+	"ceil":             mathFunc("ceil", math.Ceil),
+	"floor":            mathFunc("floor", math.Floor),
+	"exp":              mathFunc("exp", math.Exp),
+	"ln":               mathFunc("ln", math.Log),
+	"sqrt":             mathFunc("sqrt", math.Sqrt),
+	"round": {
+		argTypes: []argType{argVector, argScalar},
+		minArgs:  1,
+		call: func(args []any, ts int64) (any, error) {
+			toNearest := 1.0
+			if len(args) > 1 {
+				var err error
+				if toNearest, err = scalarArg(args[1], "round"); err != nil {
+					return nil, err
+				}
+			}
+			return mapVector(args[0], "round", func(v float64) float64 {
+				// this is how prometheus rounds, so that .5 always rounds up
+				return math.Floor(v/toNearest+0.5) * toNearest
+			})
+		},
+	},
+	"clamp": {
+		argTypes: []argType{argVector, argScalar, argScalar},
+		minArgs:  3,
+		call: func(args []any, ts int64) (any, error) {
+			lo, err := scalarArg(args[1], "clamp")
+			if err != nil {
+				return nil, err
+			}
+			hi, err := scalarArg(args[2], "clamp")
+			if err != nil {
+				return nil, err
+			}
+			if lo > hi {
+				return Vector{}, nil
+			}
+			return mapVector(args[0], "clamp", func(v float64) float64 {
+				return math.Max(lo, math.Min(hi, v))
+			})
+		},
+	},
+	"clamp_min": {
+		argTypes: []argType{argVector, argScalar},
+		minArgs:  2,
+		call: func(args []any, ts int64) (any, error) {
+			lo, err := scalarArg(args[1], "clamp_min")
+			if err != nil {
+				return nil, err
+			}
+			return mapVector(args[0], "clamp_min", func(v float64) float64 {
+				return math.Max(lo, v)
+			})
+		},
+	},
+	"clamp_max": {
+		argTypes: []argType{argVector, argScalar},
+		minArgs:  2,
+		call: func(args []any, ts int64) (any, error) {
+			hi, err := scalarArg(args[1], "clamp_max")
+			if err != nil {
+				return nil, err
+			}
+			return mapVector(args[0], "clamp_max", func(v float64) float64 {
+				return math.Min(hi, v)
+			})
+		},
+	},
+	"time": {
+		call: func(args []any, ts int64) (any, error) {
+			return float64(ts), nil
+		},
+	},
+	"timestamp": {
+		argTypes: []argType{argVector},
+		minArgs:  1,
+		call: func(args []any, ts int64) (any, error) {
+			v, ok := args[0].(Vector)
+			if !ok {
+				return nil, fmt.Errorf("timestamp expects an instant vector")
+			}
+			result := make(Vector, len(v))
+			for i, s := range v {
+				result[i] = Sample{Labels: dropName(s.Labels), T: s.T, V: float64(s.T)}
+			}
+			return result, nil
+		},
+	},
+	"vector": {
+		argTypes: []argType{argScalar},
+		minArgs:  1,
+		call: func(args []any, ts int64) (any, error) {
+			v, err := scalarArg(args[0], "vector")
+			if err != nil {
+				return nil, err
+			}
+			return Vector{{Labels: map[string]string{}, T: ts, V: v}}, nil
+		},
+	},
+	"scalar": {
+		argTypes: []argType{argVector},
+		minArgs:  1,
+		call: func(args []any, ts int64) (any, error) {
+			v, ok := args[0].(Vector)
+			if !ok {
+				return nil, fmt.Errorf("scalar expects an instant vector")
+			}
+			if len(v) != 1 {
+				return math.NaN(), nil
+			}
+			return v[0].V, nil
+		},
+	},
+}
+
+// overTime returns a function that aggregates the points of each series in a range.
+// The metric name is dropped, except by last_over_time.
+func overTime(op string) function {
+	return function{
+		argTypes: []argType{argMatrix},
+		minArgs:  1,
+		call: func(args []any, ts int64) (any, error) {
+			windows := args[0].([]Series)
+			result := make(Vector, 0, len(windows))
+			for _, w := range windows {
+				values := make([]float64, len(w.Points))
+				for i, p := range w.Points {
+					values[i] = p.V
+				}
+				labels := w.Labels
+				if op != "last" {
+					labels = dropName(labels)
+				}
+				result = append(result, Sample{Labels: labels, T: ts, V: aggregateValues(op, values)})
+			}
+			return result, nil
+		},
+	}
+}
+
+// mathFunc returns a function that applies f to each sample, dropping the metric name.
+func mathFunc(name string, f func(float64) float64) function {
+	return function{
+		argTypes: []argType{argVector},
+		minArgs:  1,
+		call: func(args []any, ts int64) (any, error) {
+			return mapVector(args[0], name, f)
+		},
+	}
+}
+
+// mapVector applies f to each sample of a vector argument, dropping the metric name.
+func mapVector(arg any, name string, f func(float64) float64) (Vector, error) {
+	v, ok := arg.(Vector)
+	if !ok {
+		return nil, fmt.Errorf("%s expects an instant vector", name)
+	}
+	result := make(Vector, len(v))
+	for i, s := range v {
+		result[i] = Sample{Labels: dropName(s.Labels), T: s.T, V: f(s.V)}
+	}
+	return result, nil
+}
+
+// scalarArg returns a scalar argument, or an error if the argument is something else.
+func scalarArg(arg any, name string) (float64, error) {
+	v, ok := arg.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s expects a scalar argument", name)
+	}
+	return v, nil
+}
@@ -0,0 +1,492 @@
+package promql
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIdent
+	tokNumber
+	tokDuration
+	tokString
+	tokOp
+)
+
+// token is a lexed part of a query.
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+// operators in the order they are lexed, so that longer operators are matched first.
+var operators = []string{"==", "!=", "=~", "!~", "<=", ">=", "(", ")", "{", "}", "[", "]", ",",
+	"=", "<", ">", "+", "-", "*", "/", "%", "^"}
+
+// lex splits a query into tokens.
+func lex(query string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(query) {
+		c := rune(query[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'' || c == '`':
+			end := i + 1
+			for end < len(query) && rune(query[end]) != c {
+				if query[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			if end >= len(query) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			s, err := unquote(query[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", i, err)
+			}
+			tokens = append(tokens, token{tokString, s, i})
+			i = end + 1
+		case c == '.' || unicode.IsDigit(c):
+			end := i
+			for end < len(query) {
+				ch := query[end]
+				if isAlnum(ch) || ch == '.' {
+					end++
+				} else if (ch == '+' || ch == '-') && (query[end-1] == 'e' || query[end-1] == 'E') &&
+					!strings.ContainsAny(query[i:end], "hdmswy") {
+					// exponent sign, e.g. 1e-3
+					end++
+				} else {
+					break
+				}
+			}
+			text := query[i:end]
+			if _, err := strconv.ParseFloat(text, 64); err == nil {
+				tokens = append(tokens, token{tokNumber, text, i})
+			} else if _, err := parseDuration(text); err == nil {
+				tokens = append(tokens, token{tokDuration, text, i})
+			} else {
+				return nil, fmt.Errorf("invalid number or duration %q at position %d", text, i)
+			}
+			i = end
+		case c == '_' || c == ':' || unicode.IsLetter(c):
+			end := i
+			for end < len(query) && (isAlnum(query[end]) || query[end] == ':') {
+				end++
+			}
+			tokens = append(tokens, token{tokIdent, query[i:end], i})
+			i = end
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(query[i:], op) {
+					tokens = append(tokens, token{tokOp, op, i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(query)}), nil
+}
+
+// isAlnum returns true for characters allowed in identifiers after the first.
+func isAlnum(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// unquote unquotes a double, single or backtick quoted string.
+func unquote(s string) (string, error) {
+	if s[0] == '\'' {
+		// convert to a double quoted string for strconv
+		inner := strings.ReplaceAll(s[1:len(s)-1], `\'`, `'`)
+		s = `"` + strings.ReplaceAll(inner, `"`, `\"`) + `"`
+	}
+	return strconv.Unquote(s)
+}
+
+var durationRE = regexp.MustCompile(`^((\d+)y)?((\d+)w)?((\d+)d)?((\d+)h)?((\d+)m)?((\d+)s)?((\d+)ms)?$`)
+
+// parseDuration parses a prometheus duration such as 1h30m or 7d.
+func parseDuration(s string) (time.Duration, error) {
+	matches := durationRE.FindStringSubmatch(s)
+	if s == "" || matches == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	units := []time.Duration{365 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour, time.Hour,
+		time.Minute, time.Second, time.Millisecond}
+	var d time.Duration
+	for i, unit := range units {
+		if n := matches[2*i+2]; n != "" {
+			v, _ := strconv.Atoi(n)
+			d += time.Duration(v) * unit
+		}
+	}
+	return d, nil
+}
+
+// aggregations are the supported aggregation operators.
+var aggregations = []string{"sum", "avg", "min", "max", "count", "stddev", "stdvar"}
+
+// precedence of binary operators; higher binds tighter.
+var precedence = map[string]int{
+	"==": 1, "!=": 1, "<": 1, "<=": 1, ">": 1, ">=": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3, "%": 3,
+	"^": 4,
+}
+
+// isComparison returns true for comparison operators.
+func isComparison(op string) bool {
+	return precedence[op] == 1
+}
+
+// parser is a recursive descent parser for PromQL expressions.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses a PromQL expression.
+func Parse(query string) (Expr, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, p.errorf(t, "unexpected %q", t.val)
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("parse error at position %d: %s", t.pos, fmt.Sprintf(format, args...))
+}
+
+// expect consumes an operator token, returning an error if the next token is something else.
+func (p *parser) expect(op string) error {
+	if t := p.next(); t.typ != tokOp || t.val != op {
+		return p.errorf(t, "expected %q but found %q", op, t.val)
+	}
+	return nil
+}
+
+// parseExpr parses binary expressions with operators of at least minPrec precedence.
+func (p *parser) parseExpr(minPrec int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.val]
+		if t.typ != tokOp || !ok || prec < minPrec {
+			return lhs, nil
+		}
+		p.next()
+		b := &BinaryExpr{Op: t.val, LHS: lhs}
+		if err := p.parseBinaryModifiers(b); err != nil {
+			return nil, err
+		}
+		// ^ is right associative, the rest are left associative
+		nextPrec := prec + 1
+		if t.val == "^" {
+			nextPrec = prec
+		}
+		b.RHS, err = p.parseExpr(nextPrec)
+		if err != nil {
+			return nil, err
+		}
+		lhs = b
+	}
+}
+
+// parseBinaryModifiers parses bool, on(...) and ignoring(...) after a binary operator.
+func (p *parser) parseBinaryModifiers(b *BinaryExpr) error {
+	if t := p.peek(); t.typ == tokIdent && t.val == "bool" {
+		if !isComparison(b.Op) {
+			return p.errorf(t, "bool modifier can only be used on comparison operators")
+		}
+		p.next()
+		b.ReturnBool = true
+	}
+	if t := p.peek(); t.typ == tokIdent && (t.val == "on" || t.val == "ignoring") {
+		p.next()
+		b.On = t.val == "on"
+		labels, err := p.parseLabelList()
+		if err != nil {
+			return err
+		}
+		b.MatchingLabels = labels
+		if t := p.peek(); t.typ == tokIdent && (t.val == "group_left" || t.val == "group_right") {
+			return p.errorf(t, "%s is not supported", t.val)
+		}
+	}
+	return nil
+}
+
+// parseUnary parses an expression with an optional leading sign.
+func (p *parser) parseUnary() (Expr, error) {
+	if t := p.peek(); t.typ == tokOp && (t.val == "-" || t.val == "+") {
+		p.next()
+		// unary operators bind less tightly than ^, e.g. -2^2 is -4
+		expr, err := p.parseExpr(precedence["^"])
+		if err != nil {
+			return nil, err
+		}
+		if t.val == "+" {
+			return expr, nil
+		}
+		if n, ok := expr.(*NumberLiteral); ok {
+			return &NumberLiteral{Val: -n.Val}, nil
+		}
+		return &UnaryExpr{Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a number, parenthesized expression, selector, function call or aggregation,
+// followed by an optional range.
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.next()
+	var expr Expr
+	var err error
+	switch {
+	case t.typ == tokNumber:
+		v, _ := strconv.ParseFloat(t.val, 64)
+		expr = &NumberLiteral{Val: v}
+	case t.typ == tokIdent && strings.EqualFold(t.val, "inf") && p.peek().val != "(" && p.peek().val != "{":
+		expr = &NumberLiteral{Val: math.Inf(1)}
+	case t.typ == tokIdent && strings.EqualFold(t.val, "nan") && p.peek().val != "(" && p.peek().val != "{":
+		expr = &NumberLiteral{Val: math.NaN()}
+	case t.typ == tokOp && t.val == "(":
+		expr, err = p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	case t.typ == tokOp && t.val == "{":
+		p.pos--
+		expr, err = p.parseSelector("")
+	case t.typ == tokIdent && slices.Contains(aggregations, t.val) && (p.peek().val == "(" ||
+		p.peek().val == "by" || p.peek().val == "without"):
+		expr, err = p.parseAggregation(t.val)
+	case t.typ == tokIdent && p.peek().typ == tokOp && p.peek().val == "(":
+		expr, err = p.parseCall(t)
+	case t.typ == tokIdent:
+		expr, err = p.parseSelector(t.val)
+	default:
+		return nil, p.errorf(t, "unexpected %q", t.val)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ == tokOp && t.val == "[" {
+		vs, ok := expr.(*VectorSelector)
+		if !ok {
+			return nil, p.errorf(t, "ranges are only allowed on vector selectors")
+		}
+		p.next()
+		d := p.next()
+		if d.typ != tokDuration {
+			return nil, p.errorf(d, "expected duration but found %q", d.val)
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		r, _ := parseDuration(d.val)
+		expr = &MatrixSelector{Vector: vs, Range: r}
+	}
+	return expr, nil
+}
+
+// parseSelector parses the label matchers of a vector selector, if any.
+func (p *parser) parseSelector(name string) (Expr, error) {
+	vs := &VectorSelector{Name: name}
+	if name != "" {
+		m, _ := NewMatcher("__name__", MatchEqual, name)
+		vs.Matchers = append(vs.Matchers, m)
+	}
+	if t := p.peek(); t.typ != tokOp || t.val != "{" {
+		return vs, nil
+	}
+	p.next()
+	for {
+		t := p.next()
+		if t.typ == tokOp && t.val == "}" {
+			break
+		}
+		if t.typ != tokIdent {
+			return nil, p.errorf(t, "expected label name but found %q", t.val)
+		}
+		op := p.next()
+		if op.typ != tokOp || !slices.Contains([]string{"=", "!=", "=~", "!~"}, op.val) {
+			return nil, p.errorf(op, "expected label matcher but found %q", op.val)
+		}
+		value := p.next()
+		if value.typ != tokString {
+			return nil, p.errorf(value, "expected quoted label value but found %q", value.val)
+		}
+		m, err := NewMatcher(t.val, MatchType(op.val), value.val)
+		if err != nil {
+			return nil, p.errorf(value, "%s", err)
+		}
+		if m.Name == "__name__" && m.Type == MatchEqual && vs.Name == "" {
+			vs.Name = m.Value
+		}
+		vs.Matchers = append(vs.Matchers, m)
+		if sep := p.peek(); sep.typ == tokOp && sep.val == "," {
+			p.next()
+		}
+	}
+	if vs.Name == "" && !slices.ContainsFunc(vs.Matchers, func(m *Matcher) bool {
+		return !m.Matches("")
+	}) {
+		return nil, fmt.Errorf("vector selector must contain at least one non-empty matcher")
+	}
+	return vs, nil
+}
+
+// parseLabelList parses a parenthesized list of label names.
+func (p *parser) parseLabelList() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	labels := []string{}
+	for {
+		t := p.next()
+		if t.typ == tokOp && t.val == ")" {
+			return labels, nil
+		}
+		if t.typ != tokIdent {
+			return nil, p.errorf(t, "expected label name but found %q", t.val)
+		}
+		labels = append(labels, t.val)
+		if sep := p.peek(); sep.typ == tokOp && sep.val == "," {
+			p.next()
+		}
+	}
+}
+
+// parseArgs parses a parenthesized, comma separated list of expressions.
+func (p *parser) parseArgs() ([]Expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []Expr
+	if t := p.peek(); t.typ == tokOp && t.val == ")" {
+		p.next()
+		return args, nil
+	}
+	for {
+		arg, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		t := p.next()
+		if t.typ == tokOp && t.val == ")" {
+			return args, nil
+		}
+		if t.typ != tokOp || t.val != "," {
+			return nil, p.errorf(t, "expected \",\" or \")\" but found %q", t.val)
+		}
+	}
+}
+
+// parseAggregation parses an aggregation, with the grouping before or after the expression.
+func (p *parser) parseAggregation(op string) (Expr, error) {
+	agg := &AggregateExpr{Op: op}
+	parseGrouping := func() error {
+		if t := p.peek(); t.typ == tokIdent && (t.val == "by" || t.val == "without") {
+			p.next()
+			agg.Without = t.val == "without"
+			labels, err := p.parseLabelList()
+			if err != nil {
+				return err
+			}
+			agg.Grouping = labels
+		}
+		return nil
+	}
+	if err := parseGrouping(); err != nil {
+		return nil, err
+	}
+	start := p.peek()
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 1 {
+		return nil, p.errorf(start, "%s takes 1 argument, got %d", op, len(args))
+	}
+	agg.Expr = args[0]
+	if agg.Grouping == nil {
+		if err := parseGrouping(); err != nil {
+			return nil, err
+		}
+	}
+	return agg, nil
+}
+
+// parseCall parses a function call, checking the function exists and the argument types.
+func (p *parser) parseCall(name token) (Expr, error) {
+	f, ok := functions[name.val]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %s", name.val)
+	}
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < f.minArgs || len(args) > len(f.argTypes) {
+		return nil, p.errorf(name, "wrong number of arguments for %s: %d", name.val, len(args))
+	}
+	for i, arg := range args {
+		_, isMatrix := arg.(*MatrixSelector)
+		if (f.argTypes[i] == argMatrix) != isMatrix {
+			if isMatrix {
+				return nil, p.errorf(name, "argument %d of %s must not be a range", i+1, name.val)
+			}
+			return nil, p.errorf(name, "argument %d of %s must be a range selector, e.g. metric{...}[1h]",
+				i+1, name.val)
+		}
+	}
+	return &Call{Func: name.val, Args: args}, nil
+}
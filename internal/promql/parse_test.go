@@ -0,0 +1,91 @@
+package promql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`forecast_temperature{location="Denver, CO",source="nws"}`,
+			`forecast_temperature{location="Denver, CO",source="nws"}`},
+		{`forecast_temperature{source=~'nws|metno', location!="x"}`,
+			`forecast_temperature{source=~"nws|metno",location!="x"}`},
+		{`{__name__="forecast_temperature"}`, `forecast_temperature{}`},
+		{`max_over_time(forecast_temperature{source="nws"}[1d12h])`,
+			`max_over_time(forecast_temperature{source="nws"}[1d12h])`},
+		{`forecast_temperature{} - forecast_dewpoint{}`, `forecast_temperature{} - forecast_dewpoint{}`},
+		{`1 + 2 * 3`, `1 + (2 * 3)`},
+		{`(1 + 2) * 3`, `(1 + 2) * 3`},
+		{`-2 ^ 2`, `-(2 ^ 2)`},
+		{`a > bool on(location) b`, `a{} > bool on(location) b{}`},
+		{`a / ignoring(source) b`, `a{} / ignoring(source) b{}`},
+		{`avg by (location) (a)`, `avg by (location) (a{})`},
+		{`sum(a) without (source)`, `sum without (source) (a{})`},
+		{`round(a, 0.5)`, `round(a{}, 0.5)`},
+		{`a * 1e-3`, `a{} * 0.001`},
+		{`time()`, `time()`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestParse_Structure(t *testing.T) {
+	expr, err := Parse(`1 + 2 * 3 - 4`)
+	require.NoError(t, err)
+	// (1 + (2 * 3)) - 4
+	sub := expr.(*BinaryExpr)
+	assert.Equal(t, "-", sub.Op)
+	add := sub.LHS.(*BinaryExpr)
+	assert.Equal(t, "+", add.Op)
+	assert.Equal(t, "*", add.RHS.(*BinaryExpr).Op)
+
+	expr, err = Parse(`2 ^ 3 ^ 2`)
+	require.NoError(t, err)
+	// right associative: 2 ^ (3 ^ 2)
+	assert.IsType(t, &BinaryExpr{}, expr.(*BinaryExpr).RHS)
+
+	expr, err = Parse(`max_over_time(forecast_temperature{source="nws"}[24h])`)
+	require.NoError(t, err)
+	ms := expr.(*Call).Args[0].(*MatrixSelector)
+	assert.Equal(t, 24*time.Hour, ms.Range)
+	assert.Equal(t, "nws", ms.Vector.Matcher("source").Value)
+	assert.Len(t, Selectors(expr), 1)
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{`forecast_temperature{source="nws"`, `parse error at position 33: expected label name but found ""`},
+		{`max_over_time(forecast_temperature{})`, "argument 1 of max_over_time must be a range selector"},
+		{`abs(a[1h])`, "argument 1 of abs must not be a range"},
+		{`nope(a)`, "unknown function nope"},
+		{`a{source=~"("}`, "invalid regular expression"},
+		{`{source=""}`, "at least one non-empty matcher"},
+		{`a + on(source) group_left b`, "group_left is not supported"},
+		{`a + bool b`, "bool modifier can only be used on comparison operators"},
+		{`(a + b`, `expected ")"`},
+		{`a[5x]`, `invalid number or duration "5x"`},
+		{`a @ 5`, `unexpected character '@'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// Package selfmetrics implements counters, gauges and histograms with labels, written in the
+// prometheus text format, for reporting on ForecastMetrics itself.
+package selfmetrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets in seconds, suitable for http requests.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Registry holds metrics and writes them. The zero value is ready to use.
+type Registry struct {
+	lock    sync.Mutex
+	metrics []*metric
+}
+
+// metric is a named metric and its series, one for each combination of label values.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*series
+}
+
+// series is the value of a metric for one combination of label values.
+type series struct {
+	labelValues []string
+	// value is the count of a counter or the value of a gauge, and the sum of a histogram.
+	value float64
+	// counts are the observations of a histogram in each bucket, and then in +Inf.
+	counts []uint64
+}
+
+// Counter is a value that only goes up, such as a count of requests.
+type Counter struct {
+	m *metric
+}
+
+// Gauge is a value that can go up and down, such as the number of requests in progress.
+type Gauge struct {
+	m *metric
+}
+
+// Histogram counts observations, such as request durations, in buckets.
+type Histogram struct {
+	m *metric
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labels, nil)}
+}
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labels, nil)}
+}
+
+// NewHistogram registers a histogram with the given upper bounds of its buckets, in increasing order,
+// and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r.register(name, help, "histogram", labels, buckets)}
+}
+
+// register adds a metric to the registry. It panics if the name is already registered.
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *metric {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, m := range r.metrics {
+		if m.name == name {
+			panic(fmt.Sprintf("metric %s registered twice", name))
+		}
+	}
+	m := &metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// Inc adds 1 to the counter for the label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter for the label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.m.update(labelValues, func(s *series) {
+		s.value += v
+	})
+}
+
+// Set sets the gauge for the label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.update(labelValues, func(s *series) {
+		s.value = v
+	})
+}
+
+// Observe adds an observation to the histogram for the label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.update(labelValues, func(s *series) {
+		s.value += v
+		i, _ := slices.BinarySearch(h.m.buckets, v)
+		s.counts[i]++
+	})
+}
+
+// update calls f with the series for the label values, creating it if needed.
+// It panics if the number of label values doesn't match the metric's labels.
+func (m *metric) update(labelValues []string, f func(s *series)) {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets)+1)
+		}
+		m.series[key] = s
+	}
+	f(s)
+}
+
+// WriteText writes every metric with at least one series in the prometheus text format,
+// in the order they were registered, with series sorted by label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.lock.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.lock.Unlock()
+	var b strings.Builder
+	for _, m := range metrics {
+		m.write(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// write writes the metric's series to b.
+func (m *metric) write(b *strings.Builder) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.series) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		s := m.series[k]
+		labels := formatLabels(m.labels, s.labelValues)
+		if m.kind != "histogram" {
+			fmt.Fprintf(b, "%s%s %s\n", m.name, labels, formatFloat(s.value))
+			continue
+		}
+		var count uint64
+		for i, c := range s.counts {
+			count += c
+			le := math.Inf(1)
+			if i < len(m.buckets) {
+				le = m.buckets[i]
+			}
+			bucketLabels := formatLabels(append(slices.Clone(m.labels), "le"),
+				append(slices.Clone(s.labelValues), formatFloat(le)))
+			fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, bucketLabels, count)
+		}
+		fmt.Fprintf(b, "%s_sum%s %s\n", m.name, labels, formatFloat(s.value))
+		fmt.Fprintf(b, "%s_count%s %d\n", m.name, labels, count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats label names and values as {name="value",...}, or nothing if there are no labels.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat formats a value the way prometheus expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
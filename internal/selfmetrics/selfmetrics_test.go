@@ -0,0 +1,56 @@
+package selfmetrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	var r Registry
+	fetches := r.NewCounter("fetches_total", "Forecasts fetched.", "source", "result")
+	inFlight := r.NewGauge("in_flight", "Fetches in progress.")
+	duration := r.NewHistogram("fetch_seconds", "Time to fetch.", []float64{1, 5}, "source")
+	r.NewCounter("unused_total", "Never incremented.")
+
+	fetches.Inc("nws", "error")
+	fetches.Inc("metno", "success")
+	fetches.Add(2, "nws", "error")
+	inFlight.Set(3)
+	duration.Observe(0.5, "nws")
+	duration.Observe(1, "nws")
+	duration.Observe(7, "nws")
+
+	var b strings.Builder
+	require.NoError(t, r.WriteText(&b))
+	expected := `# HELP fetches_total Forecasts fetched.
+# TYPE fetches_total counter
+fetches_total{source="metno",result="success"} 1
+fetches_total{source="nws",result="error"} 3
+# HELP in_flight Fetches in progress.
+# TYPE in_flight gauge
+in_flight 3
+# HELP fetch_seconds Time to fetch.
+# TYPE fetch_seconds histogram
+fetch_seconds_bucket{source="nws",le="1"} 2
+fetch_seconds_bucket{source="nws",le="5"} 2
+fetch_seconds_bucket{source="nws",le="+Inf"} 3
+fetch_seconds_sum{source="nws"} 8.5
+fetch_seconds_count{source="nws"} 3
+`
+	assert.Equal(t, expected, b.String())
+}
+
+func TestFormatLabels(t *testing.T) {
+	assert.Equal(t, `{location="Home \"north\"\\1\n"}`,
+		formatLabels([]string{"location"}, []string{"Home \"north\"\\1\n"}))
+}
+
+func TestRegistry_Panics(t *testing.T) {
+	var r Registry
+	c := r.NewCounter("fetches_total", "Forecasts fetched.", "source")
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { r.NewGauge("fetches_total", "Again.") })
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// defaultShutdownTimeout is how long to wait for a clean shutdown if shutdown_timeout isn't set.
+const defaultShutdownTimeout = 25 * time.Second
+
+// Lifecycle shuts down the parts of ForecastMetrics in order when the process is asked to stop,
+// so that requests in progress are answered and forecasts being fetched are written.
+type Lifecycle struct {
+	steps []shutdownStep
+}
+
+// shutdownStep is a named function that stops part of ForecastMetrics.
+type shutdownStep struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// OnShutdown adds a step to run on shutdown, after the steps already added.
+func (l *Lifecycle) OnShutdown(name string, stop func(ctx context.Context) error) {
+	l.steps = append(l.steps, shutdownStep{name: name, stop: stop})
+}
+
+// Shutdown runs the shutdown steps in order. Every step runs even if an earlier one fails,
+// but once ctx is done, each step should give up right away.
+func (l *Lifecycle) Shutdown(ctx context.Context) {
+	for _, step := range l.steps {
+		start := time.Now()
+		if err := step.stop(ctx); err != nil {
+			slog.Error("Error stopping", "step", step.name, "error", err)
+			continue
+		}
+		slog.Info("Stopped", "step", step.name, "duration", time.Since(start).Round(time.Millisecond).String())
+	}
+}
+
+// stopper is a part of ForecastMetrics that stops gracefully, giving up when ctx is done.
+type stopper interface {
+	Shutdown(ctx context.Context) error
+}
+
+// shutdownParts are the parts of ForecastMetrics stopped on shutdown. Parts that aren't running are nil.
+type shutdownParts struct {
+	server     stopper
+	dispatcher stopper
+	pool       stopper
+	outputs    io.Closer
+	// influxProxy and retention hold influx clients, which are closed once nothing queries them anymore.
+	influxProxy io.Closer
+	retention   io.Closer
+}
+
+// addTo adds the shutdown steps to l in order: queries stop being accepted and are answered, ad-hoc and
+// scheduled forecasts are fetched and written, and then the outputs and the other database clients are closed.
+func (p shutdownParts) addTo(l *Lifecycle) {
+	if p.server != nil {
+		l.OnShutdown("http server", p.server.Shutdown)
+	}
+	if p.dispatcher != nil {
+		l.OnShutdown("dispatcher", p.dispatcher.Shutdown)
+	}
+	if p.pool != nil {
+		l.OnShutdown("scheduled forecasts", p.pool.Shutdown)
+	}
+	closers := []struct {
+		name   string
+		closer io.Closer
+	}{
+		{"outputs", p.outputs},
+		{"influx proxy", p.influxProxy},
+		{"retention", p.retention},
+	}
+	for _, c := range closers {
+		if c.closer != nil {
+			l.OnShutdown(c.name, func(context.Context) error {
+				return c.closer.Close()
+			})
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder records the parts of ForecastMetrics that were stopped, in order.
+type recorder struct {
+	stopped []string
+}
+
+// part returns a part that records name when it's stopped or closed, and returns err.
+func (r *recorder) part(name string, err error) recordedPart {
+	return recordedPart{r, name, err}
+}
+
+type recordedPart struct {
+	r    *recorder
+	name string
+	err  error
+}
+
+func (p recordedPart) Shutdown(context.Context) error {
+	p.r.stopped = append(p.r.stopped, p.name)
+	return p.err
+}
+
+func (p recordedPart) Close() error {
+	return p.Shutdown(context.Background())
+}
+
+func TestShutdownParts_Order(t *testing.T) {
+	tests := []struct {
+		name     string
+		parts    func(r *recorder) shutdownParts
+		expected []string
+	}{
+		{
+			name: "everything",
+			parts: func(r *recorder) shutdownParts {
+				return shutdownParts{
+					server:      r.part("server", nil),
+					dispatcher:  r.part("dispatcher", nil),
+					pool:        r.part("pool", nil),
+					outputs:     r.part("outputs", nil),
+					influxProxy: r.part("proxy", nil),
+					retention:   r.part("retention", nil),
+				}
+			},
+			expected: []string{"server", "dispatcher", "pool", "outputs", "proxy", "retention"},
+		},
+		{
+			name: "without the server",
+			parts: func(r *recorder) shutdownParts {
+				return shutdownParts{
+					pool:      r.part("pool", nil),
+					outputs:   r.part("outputs", nil),
+					retention: r.part("retention", nil),
+				}
+			},
+			expected: []string{"pool", "outputs", "retention"},
+		},
+		{
+			name: "continues after errors",
+			parts: func(r *recorder) shutdownParts {
+				return shutdownParts{
+					server:     r.part("server", context.DeadlineExceeded),
+					dispatcher: r.part("dispatcher", context.DeadlineExceeded),
+					pool:       r.part("pool", nil),
+					outputs:    r.part("outputs", errors.New("flush failed")),
+				}
+			},
+			expected: []string{"server", "dispatcher", "pool", "outputs"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r recorder
+			var l Lifecycle
+			tt.parts(&r).addTo(&l)
+			l.Shutdown(context.Background())
+			assert.Equal(t, tt.expected, r.stopped)
+		})
+	}
+}
@@ -1,39 +1,40 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"regexp"
+	"context"
+	"math"
 	"strconv"
 	"strings"
 
 	cache "github.com/Code-Hex/go-generics-cache"
-	"github.com/valyala/fastjson"
-)
 
-var latLonRe = regexp.MustCompile(`(\d+\.\d+),\s*(\d+\.\d+)`)
+	"github.com/tedpearson/ForecastMetrics/v3/geocode"
+	"github.com/tedpearson/ForecastMetrics/v3/internal/coordinates"
+)
 
 type LocationResult struct {
 	Location *Location
 	Error    error
 }
 
-// LocationService parses strings into Location, using the Azure Maps Get Geocoding API.
+// LocationService parses strings into Location, using a Geocoder to look up place names.
 type LocationService struct {
-	AzureSharedKey string
-	cache          *cache.Cache[string, LocationResult]
+	Geocoder geocode.Geocoder
+	cache    *cache.Cache[string, LocationResult]
 }
 
-// ParseLocation gets the cached location or delegates to parseLocation
-func (l LocationService) ParseLocation(s string) (*Location, error) {
+// ParseLocation gets the cached location or delegates to parseLocation. Looking up a place name gives up
+// when ctx is done, which isn't cached.
+func (l LocationService) ParseLocation(ctx context.Context, s string) (*Location, error) {
 	if item, ok := l.cache.Get(s); ok {
+		geocodeCacheHits.Inc()
 		return item.Location, item.Error
 	}
-	loc, err := l.parseLocation(s)
-	l.cache.Set(s, LocationResult{loc, err})
+	geocodeCacheMisses.Inc()
+	loc, err := l.parseLocation(ctx, s)
+	if ctx.Err() == nil {
+		l.cache.Set(s, LocationResult{loc, err})
+	}
 	return loc, err
 }
 
@@ -43,7 +44,8 @@ func (l LocationService) ParseLocation(s string) (*Location, error) {
 // lat,lon|name
 // city, state
 // city, state|name
-func (l LocationService) parseLocation(s string) (*Location, error) {
+// lat,lon may be in any format supported by coordinates.Parse, e.g. 38.8895N 77.0352W, DMS, or a plus code.
+func (l LocationService) parseLocation(ctx context.Context, s string) (*Location, error) {
 	parts := strings.Split(s, "|")
 	loc := strings.ReplaceAll(parts[0], "\n", "")
 	loc = strings.ReplaceAll(loc, "\r", "")
@@ -52,16 +54,19 @@ func (l LocationService) parseLocation(s string) (*Location, error) {
 		name = strings.ReplaceAll(parts[1], "\n", "")
 		name = strings.ReplaceAll(name, "\r", "")
 	}
-	m := latLonRe.FindStringSubmatch(loc)
-	if m != nil {
+	lat, lon, ok, err := coordinates.Parse(loc)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
 		return &Location{
 			Name:      name,
-			Latitude:  m[1],
-			Longitude: m[2],
+			Latitude:  strconv.FormatFloat(lat, 'f', -1, 64),
+			Longitude: strconv.FormatFloat(lon, 'f', -1, 64),
 		}, nil
 	}
 	location := &Location{Name: name}
-	err := l.lookup(loc, location)
+	err = l.lookup(ctx, loc, location)
 	if err != nil {
 		return nil, err
 	}
@@ -69,58 +74,42 @@ func (l LocationService) parseLocation(s string) (*Location, error) {
 }
 
 // lookup fills out the location argument with information looked up from
-// the Azure Maps Get Geocoding API. The Name field on Location will only be populated
+// the Geocoder. The Name field on Location will only be populated
 // if it is an empty string.
-func (l LocationService) lookup(s string, location *Location) error {
-	q := url.Values{}
-	q.Add("api-version", "2023-06-01")
-	q.Add("query", s)
-	q.Add("subscription-key", l.AzureSharedKey)
-	resp, err := http.Get("https://atlas.microsoft.com/geocode?" + q.Encode())
-	if err != nil {
-		fmt.Printf("Failed to look up %s\n", s)
-		return err
-	}
-	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, resp.Body)
-	if err != nil {
-		fmt.Printf("Failed to copy from response\n")
-		return err
-	}
-	val, err := fastjson.ParseBytes(buf.Bytes())
+func (l LocationService) lookup(ctx context.Context, s string, location *Location) error {
+	result, err := l.Geocoder.Geocode(ctx, s)
 	if err != nil {
-		fmt.Printf("Failed to parse json %s\n", buf.String())
 		return err
 	}
-	errorCode := val.GetStringBytes("error", "code")
-	errorMsg := val.GetStringBytes("error", "message")
-	if errorMsg != nil {
-		return fmt.Errorf("failed to look up location '%s', error: %s, %s", s, string(errorCode), string(errorMsg))
+	if location.Name == "" {
+		location.Name = result.Name
 	}
-	record := val.Get("features", "0")
-	coords := record.GetArray("geometry", "coordinates")
-	if record == nil || coords == nil {
-		return fmt.Errorf("failed to look up location '%s'", s)
+	location.Latitude = strconv.FormatFloat(result.Latitude, 'f', -1, 64)
+	location.Longitude = strconv.FormatFloat(result.Longitude, 'f', -1, 64)
+	return nil
+}
+
+// coordinateTolerance is how far apart coordinates may be, in degrees, and still be the same place. About 1cm.
+const coordinateTolerance = 1e-7
+
+// sameCoordinates returns whether two locations are at the same coordinates. They are compared as numbers,
+// since the locations file may be edited by hand, e.g. to 38.90 while queries are parsed to 38.9.
+func sameCoordinates(a, b Location) bool {
+	return sameCoordinate(a.Latitude, b.Latitude) && sameCoordinate(a.Longitude, b.Longitude)
+}
+
+// sameCoordinate returns whether two latitudes or longitudes are within coordinateTolerance.
+func sameCoordinate(a, b string) bool {
+	if a == b {
+		return true
 	}
-	latF, err := coords[1].Float64()
+	x, err := strconv.ParseFloat(strings.TrimSpace(a), 64)
 	if err != nil {
-		fmt.Printf("Failed to get coordinates from json %s\n", buf.String())
-		return err
+		return false
 	}
-	lonF, err := coords[0].Float64()
+	y, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
 	if err != nil {
-		fmt.Printf("Failed to get coordinates from json %s\n", buf.String())
-		return err
+		return false
 	}
-	if location.Name == "" {
-		name := record.GetStringBytes("properties", "address", "formattedAddress")
-		if name == nil {
-			fmt.Printf("Failed to get name from json %s\n", buf.String())
-			return err
-		}
-		location.Name = string(name)
-	}
-	location.Latitude = strconv.FormatFloat(latF, 'f', -1, 64)
-	location.Longitude = strconv.FormatFloat(lonF, 'f', -1, 64)
-	return nil
+	return math.Abs(x-y) <= coordinateTolerance
 }
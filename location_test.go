@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSameCoordinates(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     Location
+		expected bool
+	}{
+		{"identical", Location{Latitude: "38.9", Longitude: "-77.0352"}, Location{Latitude: "38.9", Longitude: "-77.0352"}, true},
+		{"trailing zeros", Location{Latitude: "38.90", Longitude: "-77.03520"}, Location{Latitude: "38.9", Longitude: "-77.0352"}, true},
+		{"spaces", Location{Latitude: " 38.9", Longitude: "-77.0352 "}, Location{Latitude: "38.9", Longitude: "-77.0352"}, true},
+		{"within tolerance", Location{Latitude: "38.88950001", Longitude: "-77.0352"}, Location{Latitude: "38.8895", Longitude: "-77.0352"}, true},
+		{"different latitude", Location{Latitude: "38.91", Longitude: "-77.0352"}, Location{Latitude: "38.9", Longitude: "-77.0352"}, false},
+		{"different longitude", Location{Latitude: "38.9", Longitude: "-77.0353"}, Location{Latitude: "38.9", Longitude: "-77.0352"}, false},
+		{"invalid", Location{Latitude: "north", Longitude: "-77.0352"}, Location{Latitude: "38.9", Longitude: "-77.0352"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, sameCoordinates(tt.a, tt.b))
+			assert.Equal(t, tt.expected, sameCoordinates(tt.b, tt.a))
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/coordinates"
+)
+
+// LocationRequest is the body of a request to add or change a scheduled location.
+type LocationRequest struct {
+	// Name is the name of the location in the database. Required when adding, unless Location has a name.
+	Name string `json:"name"`
+	// Location is looked up like the location tag of a query, e.g. a place name or coordinates.
+	Location string `json:"location"`
+	// Latitude and Longitude may be given instead of Location.
+	Latitude  string `json:"latitude"`
+	Longitude string `json:"longitude"`
+	// Sources, Interval, Tags and Timezone are the location's options. When changing a location, options that
+	// aren't given are unchanged, and an empty list, object or string clears them.
+	Sources  []string          `json:"sources"`
+	Interval *string           `json:"interval"`
+	Tags     map[string]string `json:"tags"`
+	Timezone *string           `json:"timezone"`
+}
+
+// LocationsApi is a REST api to list, add, change and remove scheduled locations.
+type LocationsApi struct {
+	ConfigService   *ConfigService
+	LocationService LocationService
+	// Scheduler gets the first forecast for new or moved locations.
+	Scheduler Scheduler
+	// AuthToken is the "user:password" required for basic auth. The api is disabled if it's blank.
+	AuthToken string
+}
+
+// Register adds the api's routes to mux, unless AuthToken is blank.
+func (a LocationsApi) Register(mux *http.ServeMux) {
+	if a.AuthToken == "" {
+		slog.Warn("Not serving /api/locations, since locations_api.token isn't set")
+		return
+	}
+	mux.HandleFunc("GET /api/locations", a.auth(a.list))
+	mux.HandleFunc("POST /api/locations", a.auth(a.add))
+	mux.HandleFunc("GET /api/locations/{name}", a.auth(a.get))
+	mux.HandleFunc("PUT /api/locations/{name}", a.auth(a.update))
+	mux.HandleFunc("DELETE /api/locations/{name}", a.auth(a.remove))
+}
+
+// auth wraps a handler with basic authentication.
+func (a LocationsApi) auth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !Auth(req.Header.Get("Authorization"), a.AuthToken) {
+			resp.Header().Set("WWW-Authenticate", `Basic realm="ForecastMetrics", charset="UTF-8"`)
+			resp.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler(resp, req)
+	}
+}
+
+// list returns all scheduled locations.
+func (a LocationsApi) list(resp http.ResponseWriter, _ *http.Request) {
+	writeJson(resp, http.StatusOK, a.ConfigService.GetLocations())
+}
+
+// get returns a single scheduled location.
+func (a LocationsApi) get(resp http.ResponseWriter, req *http.Request) {
+	location, ok := a.ConfigService.GetLocation(req.PathValue("name"))
+	if !ok {
+		writeApiError(resp, ErrLocationNotFound)
+		return
+	}
+	writeJson(resp, http.StatusOK, location)
+}
+
+// add schedules a new location and gets its first forecast in the background.
+func (a LocationsApi) add(resp http.ResponseWriter, req *http.Request) {
+	var lr LocationRequest
+	if err := json.NewDecoder(req.Body).Decode(&lr); err != nil {
+		writeApiError(resp, fmt.Errorf("%w: %w", errBadRequest, err))
+		return
+	}
+	location, err := a.resolve(req.Context(), lr, Location{})
+	if err != nil {
+		writeApiError(resp, err)
+		return
+	}
+	if err := a.ConfigService.AddLocation(*location); err != nil {
+		writeApiError(resp, err)
+		return
+	}
+	slog.InfoContext(req.Context(), "Added location to regularly updated locations via api", "location", location.Name)
+	go a.Scheduler.UpdateForecast(*location)
+	writeJson(resp, http.StatusCreated, location)
+}
+
+// update renames or moves a scheduled location. Fields that aren't given are unchanged.
+func (a LocationsApi) update(resp http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	existing, ok := a.ConfigService.GetLocation(name)
+	if !ok {
+		writeApiError(resp, ErrLocationNotFound)
+		return
+	}
+	var lr LocationRequest
+	if err := json.NewDecoder(req.Body).Decode(&lr); err != nil {
+		writeApiError(resp, fmt.Errorf("%w: %w", errBadRequest, err))
+		return
+	}
+	location, err := a.resolve(req.Context(), lr, existing)
+	if err != nil {
+		writeApiError(resp, err)
+		return
+	}
+	if err := a.ConfigService.UpdateLocation(name, *location); err != nil {
+		writeApiError(resp, err)
+		return
+	}
+	slog.InfoContext(req.Context(), "Updated location via api", "location", name, "new_name", location.Name,
+		"latitude", location.Latitude, "longitude", location.Longitude)
+	if !sameCoordinates(*location, existing) {
+		go a.Scheduler.UpdateForecast(*location)
+	}
+	writeJson(resp, http.StatusOK, location)
+}
+
+// remove stops exporting forecasts for a location. Data already in the database is kept.
+func (a LocationsApi) remove(resp http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	if err := a.ConfigService.RemoveLocation(name); err != nil {
+		writeApiError(resp, err)
+		return
+	}
+	slog.InfoContext(req.Context(), "Removed location from regularly updated locations via api", "location", name)
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// errBadRequest wraps errors in the request.
+var errBadRequest = errors.New("bad request")
+
+// resolve validates a request and returns the location it describes. Fields that are blank in the
+// request are taken from existing.
+func (a LocationsApi) resolve(ctx context.Context, lr LocationRequest, existing Location) (*Location, error) {
+	location := existing
+	switch {
+	case lr.Location != "":
+		parsed, err := a.LocationService.ParseLocation(ctx, lr.Location)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errBadRequest, err)
+		}
+		location.Latitude, location.Longitude = parsed.Latitude, parsed.Longitude
+		if location.Name == "" {
+			location.Name = parsed.Name
+		}
+	case lr.Latitude != "" || lr.Longitude != "":
+		lat, lon, ok, err := coordinates.Parse(lr.Latitude + "," + lr.Longitude)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errBadRequest, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid coordinates %s,%s", errBadRequest, lr.Latitude, lr.Longitude)
+		}
+		location.Latitude = strconv.FormatFloat(lat, 'f', -1, 64)
+		location.Longitude = strconv.FormatFloat(lon, 'f', -1, 64)
+	case existing.Latitude == "":
+		return nil, fmt.Errorf("%w: location or latitude and longitude required", errBadRequest)
+	}
+	if lr.Name != "" {
+		location.Name = lr.Name
+	}
+	if lr.Sources != nil {
+		location.Sources = lr.Sources
+		if len(lr.Sources) == 0 {
+			location.Sources = nil
+		}
+	}
+	if lr.Interval != nil {
+		location.Interval = *lr.Interval
+	}
+	if lr.Tags != nil {
+		location.Tags = lr.Tags
+		if len(lr.Tags) == 0 {
+			location.Tags = nil
+		}
+	}
+	if lr.Timezone != nil {
+		location.Timezone = *lr.Timezone
+	}
+	location.Name = strings.TrimSpace(location.Name)
+	if location.Name == "" {
+		return nil, fmt.Errorf("%w: name required", errBadRequest)
+	}
+	return &location, nil
+}
+
+// writeApiError writes an error as json, with a status code for the kind of error.
+func writeApiError(resp http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errBadRequest), errors.Is(err, ErrInvalidLocation):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrLocationNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrLocationExists):
+		status = http.StatusConflict
+	}
+	writeJson(resp, status, map[string]string{"error": err.Error()})
+}
+
+// writeJson writes a value as json with the status code.
+func writeJson(resp http.ResponseWriter, status int, v any) {
+	respJson, err := json.Marshal(v)
+	if err != nil {
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Add("content-type", "application/json")
+	resp.WriteHeader(status)
+	_, err = resp.Write(respJson)
+	if err != nil {
+		slog.Warn("Error writing response to client", "error", err)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cache "github.com/Code-Hex/go-generics-cache"
+	"github.com/Code-Hex/go-generics-cache/policy/lru"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tedpearson/ForecastMetrics/v3/geocode"
+	"github.com/tedpearson/ForecastMetrics/v3/source"
+)
+
+const (
+	apiToken     = "admin:secret"
+	apiLocations = `- name: Home
+  latitude: "38.9"
+  longitude: "-77.03"
+  sources: [nws]
+  interval: 3h
+- name: Work
+  latitude: "39.1"
+  longitude: "-77.2"
+`
+)
+
+// discardOutput is an output.Output that drops every point.
+type discardOutput struct{}
+
+func (discardOutput) WritePoint(context.Context, ...*write.Point) error {
+	return nil
+}
+
+// geocoderFunc adapts a function to a geocode.Geocoder.
+type geocoderFunc func(query string) (*geocode.Result, error)
+
+func (f geocoderFunc) Geocode(_ context.Context, query string) (*geocode.Result, error) {
+	return f(query)
+}
+
+// newTestLocationService creates a LocationService looking up place names with geocoder.
+func newTestLocationService(geocoder geocode.Geocoder) LocationService {
+	return LocationService{
+		Geocoder: geocoder,
+		cache:    cache.New(cache.AsLRU[string, LocationResult](lru.WithCapacity(10))),
+	}
+}
+
+// newTestConfigService creates a ConfigService with locations saved to a file in a temporary directory.
+func newTestConfigService(t *testing.T, locations string) *ConfigService {
+	name := filepath.Join(t.TempDir(), "locations.yaml")
+	require.NoError(t, os.WriteFile(name, []byte(locations), 0644))
+	c := &ConfigService{locationsFile: name, lock: &sync.Mutex{}}
+	require.NoError(t, c.reloadLocations(true))
+	return c
+}
+
+// testApi is a LocationsApi served by a test server, recording the coordinates of the forecasts it fetches.
+type testApi struct {
+	*httptest.Server
+	configService *ConfigService
+	fetched       chan string
+}
+
+func newTestApi(t *testing.T) testApi {
+	configService := newTestConfigService(t, apiLocations)
+	fetched := make(chan string, 10)
+	forecasters := NewForecasters(map[string]source.Forecaster{
+		"nws": forecasterFunc(func(_ context.Context, lat, lon string) (*source.Forecast, error) {
+			fetched <- lat + "," + lon
+			return &source.Forecast{}, nil
+		}),
+	})
+	api := LocationsApi{
+		ConfigService: configService,
+		LocationService: newTestLocationService(geocoderFunc(func(query string) (*geocode.Result, error) {
+			if query != "Denver" {
+				return nil, geocode.ErrNotFound
+			}
+			return &geocode.Result{Name: "Denver, CO, US", Latitude: 39.7392, Longitude: -104.9847}, nil
+		})),
+		Scheduler: Scheduler{
+			ConfigService: configService,
+			MetricUpdater: MetricUpdater{writer: discardOutput{}},
+			Forecasters:   forecasters,
+			Pool:          NewFetchPool(SchedulerConfig{}),
+		},
+		AuthToken: apiToken,
+	}
+	mux := http.NewServeMux()
+	api.Register(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return testApi{Server: server, configService: configService, fetched: fetched}
+}
+
+// do makes a request to the api with the api token, returning the status and the body.
+func (a testApi) do(t *testing.T, method, path, body string) (int, string) {
+	req, err := http.NewRequest(method, a.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(apiToken)))
+	resp, err := a.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var b strings.Builder
+	_, err = io.Copy(&b, resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, b.String()
+}
+
+func TestLocationsApi_Auth(t *testing.T) {
+	a := newTestApi(t)
+	tests := []struct {
+		name     string
+		auth     string
+		expected int
+	}{
+		{"no auth", "", http.StatusUnauthorized},
+		{"wrong password", "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:wrong")), http.StatusUnauthorized},
+		{"not basic", "Bearer " + apiToken, http.StatusUnauthorized},
+		{"token", "Basic " + base64.StdEncoding.EncodeToString([]byte(apiToken)), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", a.URL+"/api/locations", nil)
+			require.NoError(t, err)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := a.Client().Do(req)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, tt.expected, resp.StatusCode)
+		})
+	}
+}
+
+func TestLocationsApi_RegisterWithoutToken(t *testing.T) {
+	mux := http.NewServeMux()
+	LocationsApi{}.Register(mux)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest("GET", "/api/locations", nil))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestLocationsApi(t *testing.T) {
+	home := Location{Name: "Home", Latitude: "38.9", Longitude: "-77.03", Sources: []string{"nws"}, Interval: "3h"}
+	work := Location{Name: "Work", Latitude: "39.1", Longitude: "-77.2"}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		// expected is the location returned, if any
+		expected *Location
+		// locations are the scheduled locations afterward
+		locations []Location
+		// fetched are the coordinates of the first forecast fetched, if any
+		fetched string
+	}{
+		{
+			name:      "get",
+			method:    "GET",
+			path:      "/api/locations/Home",
+			status:    http.StatusOK,
+			expected:  &home,
+			locations: []Location{home, work},
+		},
+		{
+			name:      "get missing",
+			method:    "GET",
+			path:      "/api/locations/Cabin",
+			status:    http.StatusNotFound,
+			locations: []Location{home, work},
+		},
+		{
+			name:      "add coordinates",
+			method:    "POST",
+			path:      "/api/locations",
+			body:      `{"name":"Cabin","latitude":"40.5","longitude":"-78.25","interval":"6h"}`,
+			status:    http.StatusCreated,
+			expected:  &Location{Name: "Cabin", Latitude: "40.5", Longitude: "-78.25", Interval: "6h"},
+			locations: []Location{home, work, {Name: "Cabin", Latitude: "40.5", Longitude: "-78.25", Interval: "6h"}},
+			fetched:   "40.5,-78.25",
+		},
+		{
+			name:      "add place name",
+			method:    "POST",
+			path:      "/api/locations",
+			body:      `{"location":"Denver"}`,
+			status:    http.StatusCreated,
+			expected:  &Location{Name: "Denver, CO, US", Latitude: "39.7392", Longitude: "-104.9847"},
+			locations: []Location{home, work, {Name: "Denver, CO, US", Latitude: "39.7392", Longitude: "-104.9847"}},
+			fetched:   "39.7392,-104.9847",
+		},
+		{
+			name:      "add existing",
+			method:    "POST",
+			path:      "/api/locations",
+			body:      `{"name":"Work","latitude":"40","longitude":"-78"}`,
+			status:    http.StatusConflict,
+			locations: []Location{home, work},
+		},
+		{
+			name:      "add invalid json",
+			method:    "POST",
+			path:      "/api/locations",
+			body:      `{"name":`,
+			status:    http.StatusBadRequest,
+			locations: []Location{home, work},
+		},
+		{
+			name:      "add without coordinates",
+			method:    "POST",
+			path:      "/api/locations",
+			body:      `{"name":"Cabin"}`,
+			status:    http.StatusBadRequest,
+			locations: []Location{home, work},
+		},
+		{
+			name:      "add unknown place",
+			method:    "POST",
+			path:      "/api/locations",
+			body:      `{"name":"Cabin","location":"Nowhere"}`,
+			status:    http.StatusBadRequest,
+			locations: []Location{home, work},
+		},
+		{
+			name:      "add unknown source",
+			method:    "POST",
+			path:      "/api/locations",
+			body:      `{"name":"Cabin","latitude":"40","longitude":"-78","sources":["weatherbug"]}`,
+			status:    http.StatusBadRequest,
+			locations: []Location{home, work},
+		},
+		{
+			name:      "update keeps fields that aren't given",
+			method:    "PUT",
+			path:      "/api/locations/Home",
+			body:      `{"tags":{"region":"east"}}`,
+			status:    http.StatusOK,
+			expected:  &Location{Name: "Home", Latitude: "38.9", Longitude: "-77.03", Sources: []string{"nws"}, Interval: "3h", Tags: map[string]string{"region": "east"}},
+			locations: []Location{{Name: "Home", Latitude: "38.9", Longitude: "-77.03", Sources: []string{"nws"}, Interval: "3h", Tags: map[string]string{"region": "east"}}, work},
+		},
+		{
+			name:      "update clears empty fields",
+			method:    "PUT",
+			path:      "/api/locations/Home",
+			body:      `{"sources":[],"interval":""}`,
+			status:    http.StatusOK,
+			expected:  &Location{Name: "Home", Latitude: "38.9", Longitude: "-77.03"},
+			locations: []Location{{Name: "Home", Latitude: "38.9", Longitude: "-77.03"}, work},
+		},
+		{
+			name:      "update moves and renames",
+			method:    "PUT",
+			path:      "/api/locations/Home",
+			body:      `{"name":"New Home","latitude":"38.95","longitude":"-77.1"}`,
+			status:    http.StatusOK,
+			expected:  &Location{Name: "New Home", Latitude: "38.95", Longitude: "-77.1", Sources: []string{"nws"}, Interval: "3h"},
+			locations: []Location{{Name: "New Home", Latitude: "38.95", Longitude: "-77.1", Sources: []string{"nws"}, Interval: "3h"}, work},
+			fetched:   "38.95,-77.1",
+		},
+		{
+			name:      "update to an existing name",
+			method:    "PUT",
+			path:      "/api/locations/Home",
+			body:      `{"name":"Work"}`,
+			status:    http.StatusConflict,
+			locations: []Location{home, work},
+		},
+		{
+			name:      "update missing",
+			method:    "PUT",
+			path:      "/api/locations/Cabin",
+			body:      `{"interval":"6h"}`,
+			status:    http.StatusNotFound,
+			locations: []Location{home, work},
+		},
+		{
+			name:      "update invalid interval",
+			method:    "PUT",
+			path:      "/api/locations/Home",
+			body:      `{"interval":"90m"}`,
+			status:    http.StatusBadRequest,
+			locations: []Location{home, work},
+		},
+		{
+			name:      "remove",
+			method:    "DELETE",
+			path:      "/api/locations/Home",
+			status:    http.StatusNoContent,
+			locations: []Location{work},
+		},
+		{
+			name:      "remove missing",
+			method:    "DELETE",
+			path:      "/api/locations/Cabin",
+			status:    http.StatusNotFound,
+			locations: []Location{home, work},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi(t)
+			status, body := a.do(t, tt.method, tt.path, tt.body)
+			assert.Equal(t, tt.status, status, body)
+			if tt.expected != nil {
+				var location Location
+				require.NoError(t, json.Unmarshal([]byte(body), &location))
+				assert.Equal(t, *tt.expected, location)
+			}
+			if tt.status >= http.StatusBadRequest {
+				assert.Contains(t, body, `"error"`)
+			}
+			assert.Equal(t, tt.locations, a.configService.GetLocations())
+			// the file is saved too
+			saved, _, err := readLocations(a.configService.locationsFile)
+			require.NoError(t, err)
+			assert.Equal(t, tt.locations, saved)
+			if tt.fetched != "" {
+				select {
+				case fetched := <-a.fetched:
+					assert.Equal(t, tt.fetched, fetched)
+				case <-time.After(time.Second):
+					t.Fatal("the location's first forecast wasn't fetched")
+				}
+			}
+		})
+	}
+}
+
+func TestLocationsApi_List(t *testing.T) {
+	a := newTestApi(t)
+	status, body := a.do(t, "GET", "/api/locations", "")
+	assert.Equal(t, http.StatusOK, status)
+	var locations []Location
+	require.NoError(t, json.Unmarshal([]byte(body), &locations))
+	assert.Equal(t, a.configService.GetLocations(), locations)
+	assert.Len(t, locations, 2)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// locationsBackups is the number of previous versions of the locations file to keep, as locations.yaml.1 and so on.
+const locationsBackups = 3
+
+// locationsYaml is the parsed locations file, kept so that comments and ordering are preserved when it's saved.
+type locationsYaml struct {
+	// doc is the yaml document containing the list of locations.
+	doc *yaml.Node
+	// nodes are the yaml nodes of each location, in the same order as the locations. New locations have nil nodes.
+	nodes []*yaml.Node
+	// skipped are the yaml nodes of invalid locations, which are kept at the end of the file so they can be fixed.
+	skipped []*yaml.Node
+}
+
+// parseLocations parses the contents of a locations file.
+func parseLocations(data []byte) ([]Location, locationsYaml, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, locationsYaml{}, err
+	}
+	if len(doc.Content) == 0 {
+		// empty file
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.SequenceNode, Tag: "!!seq"}}}
+	}
+	seq := doc.Content[0]
+	if seq.Kind != yaml.SequenceNode {
+		return nil, locationsYaml{}, fmt.Errorf("line %d: expected a list of locations", seq.Line)
+	}
+	var locations []Location
+	if err := seq.Decode(&locations); err != nil {
+		return nil, locationsYaml{}, err
+	}
+	return locations, locationsYaml{doc: &doc, nodes: seq.Content}, nil
+}
+
+// encode returns the locations file for locations, which must be in the same order as nodes, followed by
+// the skipped locations. The nodes of existing locations are updated in place, keeping their comments.
+func (y locationsYaml) encode(locations []Location, nodes []*yaml.Node) ([]byte, error) {
+	content := make([]*yaml.Node, len(locations))
+	for i, location := range locations {
+		var fresh yaml.Node
+		if err := fresh.Encode(location); err != nil {
+			return nil, err
+		}
+		if nodes[i] == nil || nodes[i].Kind != yaml.MappingNode {
+			content[i] = &fresh
+			continue
+		}
+		updateMapping(nodes[i], &fresh)
+		content[i] = nodes[i]
+	}
+	y.doc.Content[0].Content = append(content, y.skipped...)
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(y.doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// updateMapping replaces the keys and values in the mapping node with those in the mapping node from,
+// keeping the comments in node, and the style of values that are still the same kind, such as [flow] lists.
+// Keys that aren't in from are removed.
+func updateMapping(node, from *yaml.Node) {
+	content := make([]*yaml.Node, 0, len(from.Content))
+	for i := 0; i+1 < len(from.Content); i += 2 {
+		key, value := from.Content[i], from.Content[i+1]
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if node.Content[j].Value == key.Value {
+				key = node.Content[j]
+				existing := node.Content[j+1]
+				value.HeadComment, value.LineComment, value.FootComment =
+					existing.HeadComment, existing.LineComment, existing.FootComment
+				if value.Kind == existing.Kind && value.Kind != yaml.ScalarNode {
+					value.Style = existing.Style
+				}
+				break
+			}
+		}
+		content = append(content, key, value)
+	}
+	node.Content = content
+}
+
+// writeFileAtomic replaces a file with data, so that a crash or full disk leaves either the old or
+// the new contents in place. The previous contents are kept in up to backups numbered backup files.
+func writeFileAtomic(name string, data []byte, backups int) error {
+	perm := fs.FileMode(0644)
+	if info, err := os.Stat(name); err == nil {
+		perm = info.Mode().Perm()
+	}
+	dir := filepath.Dir(name)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// no-op once renamed
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := rotateBackups(name, backups, perm); err != nil {
+		return fmt.Errorf("backing up %s: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	// make the rename durable
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// rotateBackups copies the current contents of a file to name.1, shifting older backups up to name.<backups>.
+func rotateBackups(name string, backups int, perm fs.FileMode) error {
+	if backups <= 0 {
+		return nil
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := backups; i > 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", name, i-1), fmt.Sprintf("%s.%d", name, i))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.WriteFile(name+".1", data, perm)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const commentedLocations = `# scheduled locations
+
+- name: Home # where I live
+  # somewhere in DC
+  latitude: "38.9"
+  longitude: "-77.0"
+  sources: [nws]
+# the office
+- name: Work
+  latitude: "39.0"
+  longitude: "-77.1"
+`
+
+func TestLocationsYaml_Encode(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(locations []Location, nodes []*yaml.Node) ([]Location, []*yaml.Node)
+		expected string
+	}{
+		{
+			name: "add",
+			change: func(locations []Location, nodes []*yaml.Node) ([]Location, []*yaml.Node) {
+				return append(locations, Location{Name: "Cabin", Latitude: "40", Longitude: "-78"}), append(nodes, nil)
+			},
+			expected: commentedLocations + `- name: Cabin
+  latitude: "40"
+  longitude: "-78"
+`,
+		},
+		{
+			name: "update",
+			change: func(locations []Location, nodes []*yaml.Node) ([]Location, []*yaml.Node) {
+				locations[0].Latitude = "38.91"
+				locations[0].Sources = nil
+				locations[0].Interval = "3h"
+				return locations, nodes
+			},
+			expected: `# scheduled locations
+
+- name: Home # where I live
+  # somewhere in DC
+  latitude: "38.91"
+  longitude: "-77.0"
+  interval: 3h
+# the office
+- name: Work
+  latitude: "39.0"
+  longitude: "-77.1"
+`,
+		},
+		{
+			name: "remove",
+			change: func(locations []Location, nodes []*yaml.Node) ([]Location, []*yaml.Node) {
+				return slices.Delete(locations, 0, 1), slices.Delete(nodes, 0, 1)
+			},
+			expected: `# scheduled locations
+
+# the office
+- name: Work
+  latitude: "39.0"
+  longitude: "-77.1"
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locations, ly, err := parseLocations([]byte(commentedLocations))
+			require.NoError(t, err)
+			locations, nodes := tt.change(slices.Clone(locations), slices.Clone(ly.nodes))
+			data, err := ly.encode(locations, nodes)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(data))
+			parsed, _, err := parseLocations(data)
+			require.NoError(t, err)
+			assert.Equal(t, locations, parsed)
+		})
+	}
+}
+
+func TestWriteFileAtomic_Backups(t *testing.T) {
+	tests := []struct {
+		name     string
+		writes   []string
+		backups  int
+		expected map[string]string
+	}{
+		{
+			name:     "new file",
+			writes:   []string{"a"},
+			backups:  3,
+			expected: map[string]string{"locations.yaml": "a"},
+		},
+		{
+			name:    "rotates",
+			writes:  []string{"a", "b", "c"},
+			backups: 3,
+			expected: map[string]string{
+				"locations.yaml":   "c",
+				"locations.yaml.1": "b",
+				"locations.yaml.2": "a",
+			},
+		},
+		{
+			name:    "capped",
+			writes:  []string{"a", "b", "c", "d", "e", "f"},
+			backups: 3,
+			expected: map[string]string{
+				"locations.yaml":   "f",
+				"locations.yaml.1": "e",
+				"locations.yaml.2": "d",
+				"locations.yaml.3": "c",
+			},
+		},
+		{
+			name:     "no backups",
+			writes:   []string{"a", "b"},
+			backups:  0,
+			expected: map[string]string{"locations.yaml": "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			name := filepath.Join(dir, "locations.yaml")
+			for _, data := range tt.writes {
+				require.NoError(t, writeFileAtomic(name, []byte(data), tt.backups))
+			}
+			assert.Equal(t, tt.expected, readDir(t, dir))
+		})
+	}
+}
+
+func TestWriteFileAtomic_Fails(t *testing.T) {
+	tests := []struct {
+		name    string
+		backups int
+		setup   func(t *testing.T, name string)
+	}{
+		{
+			name:    "backup can't be rotated",
+			backups: 3,
+			setup: func(t *testing.T, name string) {
+				require.NoError(t, os.WriteFile(name+".2", []byte("older"), 0644))
+				// renaming name.2 over a directory fails
+				require.NoError(t, os.Mkdir(name+".3", 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(name+".3", "keep"), nil, 0644))
+			},
+		},
+		{
+			name:    "backup can't be written",
+			backups: 1,
+			setup: func(t *testing.T, name string) {
+				require.NoError(t, os.Mkdir(name+".1", 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(name+".1", "keep"), nil, 0644))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			name := filepath.Join(dir, "locations.yaml")
+			require.NoError(t, os.WriteFile(name, []byte("original"), 0600))
+			tt.setup(t, name)
+			before := readDir(t, dir)
+
+			assert.Error(t, writeFileAtomic(name, []byte("new"), tt.backups))
+			data, err := os.ReadFile(name)
+			require.NoError(t, err)
+			assert.Equal(t, "original", string(data))
+			// the temporary file is removed
+			assert.Equal(t, before, readDir(t, dir))
+		})
+	}
+}
+
+func TestWriteFileAtomic_KeepsMode(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "locations.yaml")
+	require.NoError(t, os.WriteFile(name, []byte("a"), 0600))
+	require.NoError(t, writeFileAtomic(name, []byte("b"), 1))
+	for _, f := range []string{name, name + ".1"} {
+		info, err := os.Stat(f)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), f)
+	}
+}
+
+// readDir returns the contents of the regular files in dir by name.
+func readDir(t *testing.T, dir string) map[string]string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		require.NoError(t, err)
+		files[entry.Name()] = string(data)
+	}
+	return files
+}
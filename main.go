@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"os"
-	"runtime"
+	"os/signal"
+	"path/filepath"
 	"slices"
+	"syscall"
+	"time"
 
 	cache "github.com/Code-Hex/go-generics-cache"
 	"github.com/Code-Hex/go-generics-cache/policy/lru"
@@ -13,10 +20,18 @@ import (
 	"github.com/gregjones/httpcache/diskcache"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 
+	"github.com/tedpearson/ForecastMetrics/v3/geocode"
 	myhttp "github.com/tedpearson/ForecastMetrics/v3/http"
+	"github.com/tedpearson/ForecastMetrics/v3/internal/logging"
+	"github.com/tedpearson/ForecastMetrics/v3/output"
+	"github.com/tedpearson/ForecastMetrics/v3/proxy"
+	"github.com/tedpearson/ForecastMetrics/v3/retention"
 	"github.com/tedpearson/ForecastMetrics/v3/source"
 )
 
+// reloadInterval is how often to check whether the config and locations files have changed.
+const reloadInterval = 10 * time.Second
+
 var (
 	version   = "development"
 	goVersion = "unknown"
@@ -29,38 +44,72 @@ func main() {
 	locationsFile := flag.String("locations", "locations.yaml", "Locations file")
 	versionFlag := flag.Bool("v", false, "Show version and exit")
 	flag.Parse()
-	fmt.Printf("ForecastMetrics version %s built on %s with %s\n", version, buildDate, goVersion)
 	if *versionFlag {
+		fmt.Printf("ForecastMetrics version %s built on %s with %s\n", version, buildDate, goVersion)
 		os.Exit(0)
 	}
+	// stop on ctrl-c, or when systemd or kubernetes stop the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	configService := NewConfigService(*configFile, *locationsFile)
-	config := configService.Config
+	config := configService.GetConfig()
+	err := logging.Setup(os.Stdout, config.Log.Level, config.Log.Format, config.Secrets()...)
+	if err != nil {
+		panic(err)
+	}
+	slog.Info("Starting ForecastMetrics", "version", version, "build_date", buildDate, "go_version", goVersion)
+	geocoder, err := MakeGeocoder(config)
+	if err != nil {
+		panic(err)
+	}
 	locationService := LocationService{
-		AzureSharedKey: config.AzureSharedKey,
-		cache:          cache.New(cache.AsLRU[string, LocationResult](lru.WithCapacity(200))),
+		Geocoder: geocoder,
+		cache:    cache.New(cache.AsLRU[string, LocationResult](lru.WithCapacity(200))),
+	}
+	forecasters := NewForecasters(MakeForecasters(config))
+	writer, err := MakeOutput(config)
+	if err != nil {
+		panic(err)
 	}
-	forecasters := MakeForecasters(config.Sources.Enabled, config.HttpCacheDir, config.Sources.VisualCrossing.Key)
-	c := influxdb2.NewClient(config.InfluxDB.Host, config.InfluxDB.AuthToken)
-	writeApi := c.WriteAPIBlocking(config.InfluxDB.Org, config.InfluxDB.Bucket)
 	metricUpdater := MetricUpdater{
-		writeApi:           writeApi,
+		writer:             writer,
 		overwrite:          config.OverwriteData,
 		weatherMeasurement: config.ForecastMeasurementName,
 		astroMeasurement:   config.AstronomyMeasurementName,
 		precipProbability:  config.PrecipProbability,
 	}
+	ret, err := MakeRetention(config)
+	if err != nil {
+		panic(err)
+	}
+	budget := NewBudget(config.Sources.Budgets)
 	scheduler := Scheduler{
 		ConfigService: configService,
 		MetricUpdater: metricUpdater,
 		Forecasters:   forecasters,
+		Retention:     ret,
+		Budget:        budget,
+		Pool:          NewFetchPool(config.Scheduler),
+		Jitter:        config.Scheduler.Jitter,
+	}
+	scheduler.Start(ctx)
+	reloader := Reloader{
+		ConfigService: configService,
+		Forecasters:   forecasters,
+		Budget:        budget,
+		Interval:      reloadInterval,
+	}
+	reloader.Start(ctx)
+	// the parts stopped on shutdown, left nil if they aren't running
+	var parts shutdownParts
+	parts.pool = scheduler.Pool
+	parts.outputs = writer
+	if ret != nil {
+		parts.retention = ret
 	}
-	scheduler.Start()
-	if config.ServerConfig.Port == 0 {
-		// no port specified, keep other goroutines running
-		runtime.Goexit()
-	} else {
+	if config.ServerConfig.Port != 0 {
 		// only start server if port is specified
-		dispatcher := NewDispatcher(forecasters, configService, scheduler, config.AdHocCacheEntries)
+		dispatcher := NewDispatcher(forecasters, configService, scheduler, budget, config.AdHocCacheEntries)
 		promConverter := PromConverter{
 			ForecastMeasurementName:  config.ForecastMeasurementName,
 			AstronomyMeasurementName: config.AstronomyMeasurementName,
@@ -76,33 +125,261 @@ func main() {
 				config.AstronomyMeasurementName,
 				"accumulated_precip",
 			},
+			ConfigService: configService,
+			Forecasters:   forecasters,
+			Budget:        budget,
+			Outputs:       writer,
+			LocationsApi: &LocationsApi{
+				ConfigService:   configService,
+				LocationService: locationService,
+				Scheduler:       scheduler,
+				AuthToken:       config.LocationsApi.Token,
+			},
+		}
+		err = AddProxy(&server, config)
+		if err != nil {
+			panic(err)
+		}
+		if c, ok := server.InfluxProxy.(io.Closer); ok {
+			parts.influxProxy = c
 		}
-		server.Start(config.ServerConfig)
+		parts.server = server.Start(config.ServerConfig)
+		parts.dispatcher = dispatcher
 	}
+	var lifecycle Lifecycle
+	parts.addTo(&lifecycle)
+
+	<-ctx.Done()
+	// a second signal stops immediately
+	stop()
+	timeout := config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	slog.Info("Shutting down", "timeout", timeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	lifecycle.Shutdown(shutdownCtx)
 }
 
+// SourceNames are the names of every supported source, which may be listed in sources.enabled.
+var SourceNames = []string{"nws", "visualcrossing", "openmeteo", "metno"}
+
 // MakeForecasters creates the forecasters with an exponential backoff retrying http client.
 // Only enabled forecasters are returned.
-func MakeForecasters(enabled []string, cacheDir string, vcKey string) map[string]source.Forecaster {
-	// create retryer
-	client := httpcache.NewTransport(diskcache.New(cacheDir)).Client()
-	retryer := myhttp.Retryer{
-		Client: client,
+func MakeForecasters(config Config) map[string]source.Forecaster {
+	// create retryers, counting the retries of each source
+	client := httpcache.NewTransport(diskcache.New(config.HttpCacheDir)).Client()
+	retryer := func(src string) myhttp.Retryer {
+		return myhttp.Retryer{
+			Client: client,
+			OnRetry: func(error, time.Duration) {
+				httpRetries.Inc(src)
+			},
+		}
 	}
+	// api.met.no blocks generic user agents, so allow identifying the deployment
+	metRetryer := retryer("metno")
+	metRetryer.UserAgent = config.Sources.METNorway.UserAgent
 	forecasters := map[string]source.Forecaster{
 		"nws": &source.NWS{
-			Retryer: retryer,
+			Retryer: retryer("nws"),
 		},
 		"visualcrossing": &source.VisualCrossing{
-			Retryer: retryer,
-			Key:     vcKey,
+			Retryer: retryer("visualcrossing"),
+			Key:     config.Sources.VisualCrossing.Key,
+		},
+		"openmeteo": &source.OpenMeteo{
+			Retryer: retryer("openmeteo"),
+		},
+		"metno": &source.METNorway{
+			Retryer: metRetryer,
 		},
 	}
 	// only return enabled forecasters
 	for name := range forecasters {
-		if !slices.Contains(enabled, name) {
+		if !slices.Contains(config.Sources.Enabled, name) {
 			delete(forecasters, name)
 		}
 	}
 	return forecasters
 }
+
+// MakeOutput creates the outputs that forecasts are written to, writing to all of them with a Fanout.
+// If no outputs are configured, the remote_write config is used if it has a url, otherwise influxdb.
+// If a spool dir is configured, failed writes to each output are spooled and replayed.
+func MakeOutput(config Config) (*output.Fanout, error) {
+	outputs := config.Outputs
+	if len(outputs) == 0 {
+		if config.RemoteWrite.Url != "" {
+			outputs = []OutputConfig{{Type: "remote_write", RemoteWriteConfig: config.RemoteWrite}}
+		} else {
+			outputs = []OutputConfig{{Type: "influxdb", InfluxConfig: config.InfluxDB}}
+		}
+	}
+	named := make([]output.Named, 0, len(outputs))
+	for _, oc := range outputs {
+		var o output.Output
+		switch oc.Type {
+		case "influxdb":
+			o = output.NewInflux(oc.Host, oc.AuthToken, oc.Org, oc.Bucket)
+		case "remote_write":
+			o = output.RemoteWrite{
+				Url:         oc.Url,
+				Client:      &http.Client{Timeout: time.Minute},
+				Username:    oc.Username,
+				Password:    oc.Password,
+				BearerToken: oc.BearerToken,
+				Headers:     oc.Headers,
+			}
+		case "file":
+			o = &output.File{Path: oc.Path}
+		default:
+			return nil, fmt.Errorf("unknown output type: %s", oc.Type)
+		}
+		name := oc.Name
+		if name == "" {
+			name = oc.Type
+		}
+		if config.Spool.Dir != "" {
+			spool, err := output.NewSpool(name, o, filepath.Join(config.Spool.Dir, name),
+				config.Spool.MaxBytes, config.Spool.MaxAge)
+			if err != nil {
+				return nil, err
+			}
+			spool.OnBacklog = func(batches int, bytes int64) {
+				spoolBacklogBatches.Set(float64(batches), name)
+				spoolBacklogBytes.Set(float64(bytes), name)
+			}
+			interval := config.Spool.ReplayInterval
+			if interval <= 0 {
+				interval = 5 * time.Minute
+			}
+			spool.Start(interval)
+			o = spool
+		}
+		named = append(named, output.Named{Name: name, Output: o})
+	}
+	return output.NewFanout(named...), nil
+}
+
+// MakeRetention creates the cleanup of old forecasts from each retention target.
+// If no targets are configured, the influxdb config is used. It returns nil if retention is disabled,
+// or if overwrite_data is set, since then there are no old forecasts to clean up.
+func MakeRetention(config Config) (*retention.Retention, error) {
+	rc := config.Retention
+	if (rc.MaxAge <= 0 && rc.DownsampleAge <= 0) || config.OverwriteData {
+		return nil, nil
+	}
+	if rc.DownsampleHour < 0 || rc.DownsampleHour > 23 {
+		return nil, fmt.Errorf("retention downsample_hour must be 0 to 23: %d", rc.DownsampleHour)
+	}
+	targets := rc.Targets
+	if len(targets) == 0 {
+		targets = []OutputConfig{{Type: "influxdb", InfluxConfig: config.InfluxDB}}
+	}
+	cleaners := make(map[string]retention.Cleaner, len(targets))
+	for _, tc := range targets {
+		var cleaner retention.Cleaner
+		switch tc.Type {
+		case "influxdb":
+			c := influxdb2.NewClient(tc.Host, tc.AuthToken)
+			cleaner = retention.InfluxDB{
+				QueryApi:    c.QueryAPI(tc.Org),
+				DeleteApi:   c.DeleteAPI(),
+				Org:         tc.Org,
+				Bucket:      tc.Bucket,
+				Measurement: config.ForecastMeasurementName,
+				Client:      c,
+			}
+		case "victoriametrics":
+			cleaner = retention.VictoriaMetrics{
+				Url:          tc.Url,
+				Client:       &http.Client{Timeout: time.Minute},
+				Username:     tc.Username,
+				Password:     tc.Password,
+				MetricPrefix: config.ForecastMeasurementName,
+			}
+		default:
+			return nil, fmt.Errorf("unknown retention target type: %s", tc.Type)
+		}
+		name := tc.Name
+		if name == "" {
+			name = tc.Type
+		}
+		cleaners[name] = cleaner
+	}
+	return &retention.Retention{
+		Policy: retention.Policy{
+			MaxAge:         rc.MaxAge,
+			DownsampleAge:  rc.DownsampleAge,
+			DownsampleHour: rc.DownsampleHour,
+			TimeFormat:     ForecastTimeFormat,
+		},
+		Cleaners: cleaners,
+		DryRun:   rc.DryRun,
+	}, nil
+}
+
+// AddProxy configures the server to answer queries for scheduled locations from the database, if enabled.
+func AddProxy(server *Server, config Config) error {
+	pc := config.Proxy
+	switch pc.Type {
+	case "":
+	case "prometheus":
+		server.PrometheusProxy = &proxy.Prometheus{
+			Url:         pc.Url,
+			Client:      &http.Client{Timeout: time.Minute},
+			Username:    pc.Username,
+			Password:    pc.Password,
+			BearerToken: pc.BearerToken,
+		}
+	case "influxdb":
+		c := influxdb2.NewClient(config.InfluxDB.Host, config.InfluxDB.AuthToken)
+		server.InfluxProxy = proxy.Influx{
+			QueryApi:     c.QueryAPI(config.InfluxDB.Org),
+			Bucket:       config.InfluxDB.Bucket,
+			Measurements: []string{config.ForecastMeasurementName, config.AstronomyMeasurementName},
+			Client:       c,
+		}
+	default:
+		return fmt.Errorf("unknown proxy type: %s", pc.Type)
+	}
+	return nil
+}
+
+// MakeGeocoder creates the chain of geocoders used to look up ad-hoc locations, in the configured order.
+// If none are configured, only Azure Maps is used.
+func MakeGeocoder(config Config) (geocode.Geocoder, error) {
+	if len(config.Geocoders) == 0 {
+		return geocode.Chain{geocode.Azure{SharedKey: config.AzureSharedKey}}, nil
+	}
+	chain := make(geocode.Chain, 0, len(config.Geocoders))
+	for _, gc := range config.Geocoders {
+		switch gc.Type {
+		case "azure":
+			key := gc.Key
+			if key == "" {
+				key = config.AzureSharedKey
+			}
+			chain = append(chain, geocode.Azure{SharedKey: key})
+		case "nominatim":
+			userAgent := gc.UserAgent
+			if userAgent == "" {
+				userAgent = myhttp.DefaultUserAgent
+			}
+			chain = append(chain, &geocode.Nominatim{BaseUrl: gc.Url, UserAgent: userAgent})
+		case "census":
+			chain = append(chain, geocode.Census{BaseUrl: gc.Url})
+		case "offline":
+			offline, err := geocode.NewOffline(gc.Files...)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, offline)
+		default:
+			return nil, fmt.Errorf("unknown geocoder type: %s", gc.Type)
+		}
+	}
+	return chain, nil
+}
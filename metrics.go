@@ -2,14 +2,15 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
+	"maps"
 	"reflect"
 	"time"
 
 	"github.com/iancoleman/strcase"
-	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
 
+	"github.com/tedpearson/ForecastMetrics/v3/output"
 	"github.com/tedpearson/ForecastMetrics/v3/source"
 )
 
@@ -21,40 +22,46 @@ type WriteOptions struct {
 	MeasurementName string
 	Location        string
 	ForecastTime    *string
+	// Tags are extra tags to add to every point.
+	Tags map[string]string
 }
 
 // MetricUpdater provides the ability to write forecasts to the database.
 type MetricUpdater struct {
-	writeApi           api.WriteAPIBlocking
+	writer             output.Output
 	overwrite          bool
 	weatherMeasurement string
 	astroMeasurement   string
 	precipProbability  float64
 }
 
-// WriteMetrics writes a forecast to the database.
-func (m MetricUpdater) WriteMetrics(forecast source.Forecast, location string, src string) {
+// WriteMetrics writes a forecast for a location to the database, with the location's tags.
+// The forecast_time tag is always in the local time zone, so that retention treats every location alike.
+// Writes give up when ctx is done.
+func (m MetricUpdater) WriteMetrics(ctx context.Context, forecast source.Forecast, loc Location, src string) {
+	location := loc.Name
 	forecastOptions := WriteOptions{
 		ForecastSource:  src,
 		MeasurementName: m.weatherMeasurement,
 		Location:        location,
+		Tags:            loc.Tags,
 	}
 	if !m.overwrite {
 		forecastTime := time.Now().Truncate(time.Hour).Format(ForecastTimeFormat)
 		forecastOptions.ForecastTime = &forecastTime
 	}
 
-	ft := "nil"
+	log := slog.With("location", location, "source", src)
+	weatherLog := log.With("measurement", m.weatherMeasurement)
 	if forecastOptions.ForecastTime != nil {
-		ft = *forecastOptions.ForecastTime
+		weatherLog = weatherLog.With("forecast_time", *forecastOptions.ForecastTime)
 	}
 	records := forecast.WeatherRecords
-	fmt.Printf(`Writing %d points {loc:"%s", src:"%s", measurement:"%s", forecast_time:"%s"}`+"\n",
-		len(records), location, src, m.weatherMeasurement, ft)
+	weatherLog.Info("Writing points", "count", len(records))
 
 	points := toPoints(records, forecastOptions)
-	if err := m.writeApi.WritePoint(context.Background(), points...); err != nil {
-		fmt.Printf("Error writing weather forecast point: %+v\n", err)
+	if err := m.write(ctx, m.weatherMeasurement, points); err != nil {
+		weatherLog.Error("Error writing weather forecast points", "error", err)
 	}
 
 	// write next hour to past forecast measurement
@@ -67,29 +74,50 @@ func (m MetricUpdater) WriteMetrics(forecast source.Forecast, location string, s
 				f := "0"
 				nextHourOptions.ForecastTime = &f
 				points = toPoints(nextHourRecord, nextHourOptions)
-				if err := m.writeApi.WritePoint(context.Background(), points...); err != nil {
-					fmt.Printf("Error writing weather forecast point: %+v\n", err)
+				if err := m.write(ctx, m.weatherMeasurement, points); err != nil {
+					log.Error("Error writing past weather points", "measurement", m.weatherMeasurement,
+						"forecast_time", f, "error", err)
 				}
 				break
 			}
 		}
 	}
 
+	if len(forecast.Hazards) > 0 {
+		// write hazards to the forecast measurement, tagged with the hazard codes
+		weatherLog.Info("Writing hazard points", "count", len(forecast.Hazards))
+		points := toHazardPoints(forecast.Hazards, forecastOptions)
+		if err := m.write(ctx, m.weatherMeasurement, points); err != nil {
+			weatherLog.Error("Error writing hazard forecast points", "error", err)
+		}
+	}
+
 	if len(forecast.AstroEvents) > 0 {
 		// write astronomy
 		astronomyOptions := forecastOptions
 		astronomyOptions.MeasurementName = m.astroMeasurement
 		astronomyOptions.ForecastTime = nil
-		fmt.Printf(`Writing %d points {loc:"%s", src:"%s", measurement:"%s"}`+"\n",
-			len(forecast.AstroEvents), location, src, m.astroMeasurement)
+		astroLog := log.With("measurement", m.astroMeasurement)
+		astroLog.Info("Writing points", "count", len(forecast.AstroEvents))
 		points := toPoints(forecast.AstroEvents, astronomyOptions)
-		if err := m.writeApi.WritePoint(context.Background(), points...); err != nil {
-			fmt.Printf("Error writing astronomy forecast point: %+v\n", err)
+		if err := m.write(ctx, m.astroMeasurement, points); err != nil {
+			astroLog.Error("Error writing astronomy forecast points", "error", err)
 			return
 		}
 	}
 }
 
+// write writes points to the outputs, counting the points written or failed for the measurement.
+func (m MetricUpdater) write(ctx context.Context, measurement string, points []*write.Point) error {
+	err := m.writer.WritePoint(ctx, points...)
+	if err != nil {
+		pointWriteFailures.Add(float64(len(points)), measurement)
+	} else {
+		pointsWritten.Add(float64(len(points)), measurement)
+	}
+	return err
+}
+
 // toPoints converts a slice of source.InfluxPointer to influx client points.
 func toPoints[IP source.InfluxPointer](ip []IP, options WriteOptions) []*write.Point {
 	points := make([]*write.Point, 0, len(ip))
@@ -105,12 +133,43 @@ func toPoints[IP source.InfluxPointer](ip []IP, options WriteOptions) []*write.P
 	return points
 }
 
+// toHazardPoints converts hazards to influx client points with a "hazard" field of 1,
+// tagged with the phenomenon and significance.
+func toHazardPoints(hazards []source.Hazard, options WriteOptions) []*write.Point {
+	points := make([]*write.Point, 0, len(hazards))
+	for _, hazard := range hazards {
+		// only send future datapoints.
+		ft := options.ForecastTime
+		if ft != nil && *ft != "0" && hazard.Time.Before(time.Now().Add(time.Hour+1)) {
+			continue
+		}
+		tags := maps.Clone(options.Tags)
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags["source"] = options.ForecastSource
+		tags["location"] = options.Location
+		tags["phenomenon"] = hazard.Phenomenon
+		tags["significance"] = hazard.Significance
+		if ft != nil {
+			tags["forecast_time"] = *ft
+		}
+		fields := map[string]interface{}{
+			"hazard": 1,
+		}
+		points = append(points, write.NewPoint(options.MeasurementName, tags, fields, hazard.Time))
+	}
+	return points
+}
+
 // toPoint converts a struct to an influx client point.
 func toPoint(t time.Time, i interface{}, options WriteOptions) *write.Point {
-	tags := map[string]string{
-		"source":   options.ForecastSource,
-		"location": options.Location,
+	tags := maps.Clone(options.Tags)
+	if tags == nil {
+		tags = make(map[string]string)
 	}
+	tags["source"] = options.ForecastSource
+	tags["location"] = options.Location
 	if options.ForecastTime != nil {
 		tags["forecast_time"] = *options.ForecastTime
 	}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tedpearson/ForecastMetrics/v3/source"
+)
+
+// lineProtocol returns the points in line protocol, with second precision.
+func lineProtocol(points []*write.Point) []string {
+	lines := make([]string, len(points))
+	for i, p := range points {
+		lines[i] = write.PointToLineProtocol(p, time.Second)
+	}
+	return lines
+}
+
+func TestToPoint(t *testing.T) {
+	temp := 20.5
+	record := source.WeatherRecord{Time: time.Unix(1700000000, 0), Temperature: &temp}
+	forecastTime := "2023-11-14:17"
+	tests := []struct {
+		name     string
+		options  WriteOptions
+		expected string
+	}{
+		{
+			name:     "no tags",
+			options:  WriteOptions{ForecastSource: "nws", MeasurementName: "forecast", Location: "Home"},
+			expected: "forecast,location=Home,source=nws temperature=20.5 1700000000\n",
+		},
+		{
+			name: "location tags",
+			options: WriteOptions{ForecastSource: "nws", MeasurementName: "forecast", Location: "Home",
+				ForecastTime: &forecastTime, Tags: map[string]string{"region": "east", "zone": "a"}},
+			expected: "forecast,forecast_time=2023-11-14:17,location=Home,region=east,source=nws,zone=a temperature=20.5 1700000000\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := map[string]string{}
+			for k, v := range tt.options.Tags {
+				tags[k] = v
+			}
+			p := toPoint(record.Time, record, tt.options)
+			assert.Equal(t, tt.expected, write.PointToLineProtocol(p, time.Second))
+			// the location's tags aren't changed
+			if tt.options.Tags != nil {
+				assert.Equal(t, tags, tt.options.Tags)
+			}
+		})
+	}
+}
+
+func TestToHazardPoints(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	hazards := []source.Hazard{
+		{Time: now, Phenomenon: "WS", Significance: "A"},
+		{Time: now.Add(2 * time.Hour), Phenomenon: "WS", Significance: "W"},
+	}
+	future, past := now.Format(ForecastTimeFormat), "0"
+	tests := []struct {
+		name     string
+		options  WriteOptions
+		expected []string
+	}{
+		{
+			name:    "overwrite",
+			options: WriteOptions{ForecastSource: "nws", MeasurementName: "forecast", Location: "Home"},
+			expected: []string{
+				"forecast,location=Home,phenomenon=WS,significance=A,source=nws hazard=1i %d\n",
+				"forecast,location=Home,phenomenon=WS,significance=W,source=nws hazard=1i %d\n",
+			},
+		},
+		{
+			name: "future only, with tags",
+			options: WriteOptions{ForecastSource: "nws", MeasurementName: "forecast", Location: "Home",
+				ForecastTime: &future, Tags: map[string]string{"region": "east"}},
+			expected: []string{
+				"",
+				"forecast,forecast_time=" + future + ",location=Home,phenomenon=WS,region=east,significance=W,source=nws hazard=1i %d\n",
+			},
+		},
+		{
+			name: "past data",
+			options: WriteOptions{ForecastSource: "nws", MeasurementName: "forecast", Location: "Home",
+				ForecastTime: &past, Tags: map[string]string{"region": "east"}},
+			expected: []string{
+				"forecast,forecast_time=0,location=Home,phenomenon=WS,region=east,significance=A,source=nws hazard=1i %d\n",
+				"forecast,forecast_time=0,location=Home,phenomenon=WS,region=east,significance=W,source=nws hazard=1i %d\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var expected []string
+			for i, line := range tt.expected {
+				if line != "" {
+					expected = append(expected, fmt.Sprintf(line, hazards[i].Time.Unix()))
+				}
+			}
+			assert.Equal(t, expected, lineProtocol(toHazardPoints(hazards, tt.options)))
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Named is an Output with a name, used in errors and Status.
+type Named struct {
+	Name string
+	Output
+}
+
+// Status is the write history of a single Output.
+type Status struct {
+	Successes   int
+	Failures    int
+	LastSuccess time.Time
+	LastFailure time.Time
+	LastError   error
+}
+
+// Fanout writes every point to multiple outputs concurrently. A failure in one output
+// doesn't prevent writing to the others, and the success and failure of each is tracked.
+type Fanout struct {
+	outputs []Named
+	lock    sync.Mutex
+	status  map[string]Status
+}
+
+// NewFanout creates a Fanout writing to all the outputs.
+func NewFanout(outputs ...Named) *Fanout {
+	return &Fanout{
+		outputs: outputs,
+		status:  make(map[string]Status),
+	}
+}
+
+// WritePoint implements Output by writing to every output. If any fail, the errors
+// are returned together, each prefixed with the output's name.
+func (f *Fanout) WritePoint(ctx context.Context, point ...*write.Point) error {
+	errs := make([]error, len(f.outputs))
+	var wg sync.WaitGroup
+	for i, o := range f.outputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := o.WritePoint(ctx, point...)
+			f.record(o.Name, err)
+			if err != nil {
+				errs[i] = fmt.Errorf("output %s: %w", o.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// record updates the status of an output after a write.
+func (f *Fanout) record(name string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	s := f.status[name]
+	if err != nil {
+		s.Failures++
+		s.LastFailure = time.Now()
+		s.LastError = err
+	} else {
+		s.Successes++
+		s.LastSuccess = time.Now()
+	}
+	f.status[name] = s
+}
+
+// Close closes every output that implements io.Closer, returning their errors together.
+func (f *Fanout) Close() error {
+	var errs []error
+	for _, o := range f.outputs {
+		if err := closeOutput(o.Output); err != nil {
+			errs = append(errs, fmt.Errorf("output %s: %w", o.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status returns a copy of the status of every output that has been written to, by name.
+func (f *Fanout) Status() map[string]Status {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	status := make(map[string]Status, len(f.status))
+	for k, v := range f.status {
+		status[k] = v
+	}
+	return status
+}
@@ -0,0 +1,49 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// outputFunc adapts a function to an Output.
+type outputFunc func(ctx context.Context, point ...*write.Point) error
+
+func (f outputFunc) WritePoint(ctx context.Context, point ...*write.Point) error {
+	return f(ctx, point...)
+}
+
+func TestFanout_WritePoint(t *testing.T) {
+	file := &File{Path: filepath.Join(t.TempDir(), "points.lp")}
+	failing := outputFunc(func(context.Context, ...*write.Point) error {
+		return errors.New("connection refused")
+	})
+	f := NewFanout(Named{"file", file}, Named{"down", failing})
+	p := write.NewPoint("forecast", map[string]string{"source": "nws"},
+		map[string]interface{}{"temperature": 70.5}, time.Unix(1717236000, 0))
+
+	err := f.WritePoint(context.Background(), p)
+	assert.EqualError(t, err, "output down: connection refused")
+	err = f.WritePoint(context.Background(), p)
+	assert.Error(t, err)
+
+	// the working output still gets every point
+	contents, err := os.ReadFile(file.Path)
+	require.NoError(t, err)
+	line := "forecast,source=nws temperature=70.5 1717236000\n"
+	assert.Equal(t, line+line, string(contents))
+
+	status := f.Status()
+	assert.Equal(t, 2, status["file"].Successes)
+	assert.Equal(t, 0, status["file"].Failures)
+	assert.Equal(t, 0, status["down"].Successes)
+	assert.Equal(t, 2, status["down"].Failures)
+	assert.EqualError(t, status["down"].LastError, "connection refused")
+}
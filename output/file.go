@@ -0,0 +1,36 @@
+package output
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// File appends points to a file in influx line protocol, e.g. for archiving or importing elsewhere.
+type File struct {
+	Path string
+	lock sync.Mutex
+}
+
+// WritePoint implements Output by appending the points to the file.
+func (f *File) WritePoint(_ context.Context, point ...*write.Point) error {
+	var sb strings.Builder
+	for _, p := range point {
+		write.PointToLineProtocolBuffer(p, &sb, time.Second)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(sb.String()); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,65 @@
+// Package output provides the databases that forecast points can be written to.
+package output
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/cenkalti/backoff/v3"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Output writes points to a database. The influx api.WriteAPIBlocking is an Output.
+// Outputs that hold resources may also implement io.Closer.
+type Output interface {
+	WritePoint(ctx context.Context, point ...*write.Point) error
+}
+
+// Influx writes points to InfluxDB or VictoriaMetrics with the influx client, which is closed by Close.
+type Influx struct {
+	api.WriteAPIBlocking
+	Client influxdb2.Client
+}
+
+// NewInflux creates an Influx writing to the bucket.
+func NewInflux(host, authToken, org, bucket string) Influx {
+	client := influxdb2.NewClient(host, authToken)
+	return Influx{
+		WriteAPIBlocking: client.WriteAPIBlocking(org, bucket),
+		Client:           client,
+	}
+}
+
+// Close closes the influx client.
+func (i Influx) Close() error {
+	i.Client.Close()
+	return nil
+}
+
+// Permanent returns whether a write error won't be fixed by retrying, such as invalid points or failed auth.
+// Network errors, server errors and rate limiting are worth retrying.
+func Permanent(err error) bool {
+	var pe *backoff.PermanentError
+	if errors.As(err, &pe) {
+		return true
+	}
+	var he *ihttp.Error
+	if errors.As(err, &he) {
+		return he.StatusCode >= 400 && he.StatusCode < 500 && he.StatusCode != http.StatusTooManyRequests &&
+			he.StatusCode != http.StatusRequestTimeout
+	}
+	return false
+}
+
+// closeOutput closes o if it implements io.Closer.
+func closeOutput(o Output) error {
+	if c, ok := o.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package output
+
+// https://prometheus.io/docs/specs/remote_write_spec/
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/klauspost/compress/snappy"
+)
+
+// RemoteWrite writes points to a Prometheus remote_write endpoint, such as Prometheus, Mimir,
+// Thanos Receive, Cortex or VictoriaMetrics. Each field of a point becomes a series named
+// <measurement>_<field>, labelled with the point's tags, the same way VictoriaMetrics
+// converts influx line protocol.
+type RemoteWrite struct {
+	Url    string
+	Client *http.Client
+	// Username and Password are used for basic auth, if set.
+	Username string
+	Password string
+	// BearerToken is used for bearer auth, if set.
+	BearerToken string
+	// Headers are added to each request, e.g. X-Scope-OrgID for multi-tenant Mimir or Cortex.
+	Headers map[string]string
+}
+
+// WritePoint implements Output by sending the points in a single remote write request.
+func (r RemoteWrite) WritePoint(ctx context.Context, point ...*write.Point) error {
+	if len(point) == 0 {
+		return nil
+	}
+	body := snappy.Encode(nil, marshalWriteRequest(toTimeSeries(point)))
+	off := backoff.NewExponentialBackOff()
+	off.MaxElapsedTime = 30 * time.Second
+	return backoff.Retry(func() error {
+		return r.send(ctx, body)
+	}, backoff.WithContext(off, ctx))
+}
+
+// outsideTimeRange matches the errors of receivers that reject samples outside the time range they accept,
+// such as Prometheus' "out of bounds" and Mimir's "too far in the future".
+var outsideTimeRange = regexp.MustCompile(`(?i)out of bounds|too far in(to)? the future|too-far-in-future`)
+
+// send makes a single remote write request. 5xx and 429 responses are retried.
+// Other failures return an *ihttp.Error, so that Permanent can tell them apart once Retry unwraps them.
+func (r RemoteWrite) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", r.Url, bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "ForecastMetrics")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	if r.Username != "" || r.Password != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	} else if r.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.BearerToken)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	httpErr := &ihttp.Error{
+		StatusCode: resp.StatusCode,
+		Err:        fmt.Errorf("remote write to %s failed: %s: %s", r.Url, resp.Status, strings.TrimSpace(string(msg))),
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return httpErr
+	}
+	if resp.StatusCode == http.StatusBadRequest && outsideTimeRange.Match(msg) {
+		httpErr.Err = fmt.Errorf("%w (the receiver must accept samples in the future for forecasts, "+
+			"see remote_write in the README)", httpErr.Err)
+	}
+	return backoff.Permanent(httpErr)
+}
+
+// label is a prometheus label.
+type label struct {
+	name  string
+	value string
+}
+
+// sample is a prometheus sample.
+type sample struct {
+	value     float64
+	timestamp int64
+}
+
+// timeSeries is a prometheus time series.
+type timeSeries struct {
+	labels  []label
+	samples []sample
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// metricName converts a measurement and field into a valid prometheus metric name.
+func metricName(measurement, field string) string {
+	name := invalidNameChars.ReplaceAllString(measurement+"_"+field, "_")
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// toFloat converts an influx field value to a sample value.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// toTimeSeries groups the fields of points into time series with sorted labels and samples.
+func toTimeSeries(points []*write.Point) []timeSeries {
+	index := make(map[string]int)
+	var series []timeSeries
+	for _, p := range points {
+		for _, field := range p.FieldList() {
+			value, ok := toFloat(field.Value)
+			if !ok {
+				continue
+			}
+			labels := []label{{"__name__", metricName(p.Name(), field.Key)}}
+			for _, tag := range p.TagList() {
+				labels = append(labels, label{invalidNameChars.ReplaceAllString(tag.Key, "_"), tag.Value})
+			}
+			slices.SortFunc(labels, func(a, b label) int {
+				return strings.Compare(a.name, b.name)
+			})
+			var key strings.Builder
+			for _, l := range labels {
+				key.WriteString(l.name + "\xff" + l.value + "\xff")
+			}
+			i, ok := index[key.String()]
+			if !ok {
+				i = len(series)
+				index[key.String()] = i
+				series = append(series, timeSeries{labels: labels})
+			}
+			series[i].samples = append(series[i].samples, sample{value, p.Time().UnixMilli()})
+		}
+	}
+	for _, ts := range series {
+		slices.SortFunc(ts.samples, func(a, b sample) int {
+			return cmp.Compare(a.timestamp, b.timestamp)
+		})
+	}
+	return series
+}
+
+// marshalWriteRequest encodes a prometheus.WriteRequest protobuf message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func marshalWriteRequest(series []timeSeries) []byte {
+	var buf []byte
+	for _, ts := range series {
+		var tsBuf []byte
+		for _, l := range ts.labels {
+			var lBuf []byte
+			lBuf = appendBytesField(lBuf, 1, []byte(l.name))
+			lBuf = appendBytesField(lBuf, 2, []byte(l.value))
+			tsBuf = appendBytesField(tsBuf, 1, lBuf)
+		}
+		for _, s := range ts.samples {
+			var sBuf []byte
+			sBuf = binary.AppendUvarint(sBuf, 1<<3|1)
+			sBuf = binary.LittleEndian.AppendUint64(sBuf, math.Float64bits(s.value))
+			sBuf = binary.AppendUvarint(sBuf, 2<<3|0)
+			sBuf = binary.AppendUvarint(sBuf, uint64(s.timestamp))
+			tsBuf = appendBytesField(tsBuf, 2, sBuf)
+		}
+		buf = appendBytesField(buf, 1, tsBuf)
+	}
+	return buf
+}
+
+// appendBytesField appends a length-delimited protobuf field.
+func appendBytesField(buf []byte, field uint64, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, field<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
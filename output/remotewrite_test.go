@@ -0,0 +1,212 @@
+package output
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readField reads a single protobuf field, returning the field number, the varint or fixed64 value,
+// the length-delimited bytes, and the remaining buffer.
+func readField(t *testing.T, buf []byte) (uint64, uint64, []byte, []byte) {
+	tag, n := binary.Uvarint(buf)
+	require.Greater(t, n, 0)
+	buf = buf[n:]
+	switch tag & 7 {
+	case 0:
+		v, n := binary.Uvarint(buf)
+		require.Greater(t, n, 0)
+		return tag >> 3, v, nil, buf[n:]
+	case 1:
+		return tag >> 3, binary.LittleEndian.Uint64(buf), nil, buf[8:]
+	case 2:
+		l, n := binary.Uvarint(buf)
+		require.Greater(t, n, 0)
+		buf = buf[n:]
+		return tag >> 3, 0, buf[:l], buf[l:]
+	}
+	t.Fatalf("unexpected wire type %d", tag&7)
+	return 0, 0, nil, nil
+}
+
+// unmarshalWriteRequest decodes a WriteRequest protobuf message.
+func unmarshalWriteRequest(t *testing.T, buf []byte) []timeSeries {
+	var series []timeSeries
+	for len(buf) > 0 {
+		var tsBuf []byte
+		_, _, tsBuf, buf = readField(t, buf)
+		var ts timeSeries
+		for len(tsBuf) > 0 {
+			field, _, msg, rest := readField(t, tsBuf)
+			tsBuf = rest
+			if field == 1 {
+				var l label
+				for len(msg) > 0 {
+					f, _, b, r := readField(t, msg)
+					msg = r
+					if f == 1 {
+						l.name = string(b)
+					} else {
+						l.value = string(b)
+					}
+				}
+				ts.labels = append(ts.labels, l)
+			} else {
+				var s sample
+				for len(msg) > 0 {
+					f, v, _, r := readField(t, msg)
+					msg = r
+					if f == 1 {
+						s.value = math.Float64frombits(v)
+					} else {
+						s.timestamp = int64(v)
+					}
+				}
+				ts.samples = append(ts.samples, s)
+			}
+		}
+		series = append(series, ts)
+	}
+	return series
+}
+
+func TestRemoteWrite_WritePoint(t *testing.T) {
+	var received []timeSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		assert.Equal(t, "tenant", r.Header.Get("X-Scope-OrgID"))
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "pass", pass)
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		received = unmarshalWriteRequest(t, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	rw := RemoteWrite{
+		Url:      server.URL,
+		Client:   server.Client(),
+		Username: "user",
+		Password: "pass",
+		Headers:  map[string]string{"X-Scope-OrgID": "tenant"},
+	}
+	t1 := time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC)
+	t0 := t1.Add(-time.Hour)
+	tags := map[string]string{"source": "nws", "location": "home", "forecast_time": "2024-06-01:09"}
+	err := rw.WritePoint(context.Background(),
+		write.NewPoint("forecast", tags, map[string]interface{}{"temperature": 70.5, "sun_up": int64(1)}, t1),
+		write.NewPoint("forecast", tags, map[string]interface{}{"temperature": 68.0}, t0),
+	)
+	require.NoError(t, err)
+
+	labels := func(name string) []label {
+		return []label{
+			{"__name__", name},
+			{"forecast_time", "2024-06-01:09"},
+			{"location", "home"},
+			{"source", "nws"},
+		}
+	}
+	assert.Equal(t, []timeSeries{
+		{
+			labels: labels("forecast_sun_up"),
+			samples: []sample{
+				{1, t1.UnixMilli()},
+			},
+		},
+		{
+			labels: labels("forecast_temperature"),
+			samples: []sample{
+				{68, t0.UnixMilli()},
+				{70.5, t1.UnixMilli()},
+			},
+		},
+	}, received)
+}
+
+func TestRemoteWrite_WritePointError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		err       string
+		permanent bool
+	}{
+		{
+			name:      "bad request",
+			status:    http.StatusBadRequest,
+			body:      "out of order sample",
+			err:       "400 Bad Request: out of order sample",
+			permanent: true,
+		},
+		{
+			name:   "out of bounds",
+			status: http.StatusBadRequest,
+			body:   "out of bounds",
+			err: "400 Bad Request: out of bounds (the receiver must accept samples in the future for forecasts, " +
+				"see remote_write in the README)",
+			permanent: true,
+		},
+		{
+			name:   "too far in the future",
+			status: http.StatusBadRequest,
+			body:   "received a sample whose timestamp is too far in the future (err-mimir-too-far-in-future)",
+			err: "(err-mimir-too-far-in-future) (the receiver must accept samples in the future for forecasts, " +
+				"see remote_write in the README)",
+			permanent: true,
+		},
+		{
+			name:      "unauthorized",
+			status:    http.StatusUnauthorized,
+			err:       "401 Unauthorized",
+			permanent: true,
+		},
+		{
+			name:   "unavailable",
+			status: http.StatusServiceUnavailable,
+			err:    "503 Service Unavailable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			rw := RemoteWrite{Url: server.URL, Client: server.Client()}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			err := rw.WritePoint(ctx,
+				write.NewPoint("forecast", nil, map[string]interface{}{"temperature": 70.5}, time.Now()))
+			assert.ErrorContains(t, err, tt.err)
+			assert.Equal(t, tt.permanent, Permanent(err))
+			if tt.permanent {
+				// 4xx errors are not retried
+				assert.Equal(t, 1, requests)
+			} else {
+				assert.Greater(t, requests, 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Spool wraps an Output, persisting batches that fail to write in segment files on disk,
+// and replaying them in order once the Output recovers. While there is a backlog, new batches
+// are added to the end of it, so points are always written in order.
+type Spool struct {
+	Name   string
+	Output Output
+	// Dir is where segment files are stored. Each Spool must have its own directory.
+	Dir string
+	// MaxBytes limits the size of the backlog. The oldest segments are dropped to stay under it.
+	// Zero means no limit.
+	MaxBytes int64
+	// MaxAge drops segments older than this instead of replaying them. Zero means no limit.
+	MaxAge time.Duration
+	// OnBacklog is called, if not nil, with the number of spooled batches and their size in bytes
+	// when the spool starts and after each write and replay.
+	OnBacklog func(batches int, bytes int64)
+	lock      sync.Mutex
+	// stop stops the replay goroutine, and done is closed when it has stopped.
+	stop chan struct{}
+	done chan struct{}
+}
+
+// spooledPoint is the serialized form of a write.Point.
+type spooledPoint struct {
+	Name   string
+	Tags   map[string]string
+	Fields map[string]interface{}
+	Time   time.Time
+}
+
+// segmentExt is the file extension of segment files.
+const segmentExt = ".spool"
+
+// NewSpool creates a Spool, creating its directory if needed.
+func NewSpool(name string, o Output, dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating spool directory %s: %w", dir, err)
+	}
+	return &Spool{
+		Name:     name,
+		Output:   o,
+		Dir:      dir,
+		MaxBytes: maxBytes,
+		MaxAge:   maxAge,
+	}, nil
+}
+
+// Start starts a goroutine which replays the backlog every interval, until Close is called.
+func (s *Spool) Start(interval time.Duration) {
+	s.lock.Lock()
+	s.reportBacklog()
+	s.lock.Unlock()
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run(interval)
+}
+
+// Close stops replaying the backlog, waiting for a replay in progress to finish, and closes the Output
+// if it implements io.Closer. The backlog stays on disk to be replayed by the next Spool using Dir.
+func (s *Spool) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	// wait for a write in progress
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return closeOutput(s.Output)
+}
+
+// run replays the backlog every interval.
+func (s *Spool) run(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+		if err := s.Replay(context.Background()); err != nil {
+			segments, size := s.Backlog()
+			slog.Error("Failed to replay spool", "output", s.Name, "batches", segments, "bytes", size, "error", err)
+		}
+	}
+}
+
+// WritePoint implements Output by replaying any backlog and then writing the points.
+// If either fails, the points are spooled and the error is returned, unless the points themselves
+// failed with a Permanent error, in which case retrying them would never succeed, so they are dropped.
+func (s *Spool) WritePoint(ctx context.Context, point ...*write.Point) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.reportBacklog()
+	err := s.replay(ctx)
+	if err == nil {
+		err = s.Output.WritePoint(ctx, point...)
+		if Permanent(err) {
+			slog.ErrorContext(ctx, "Dropping points that can't be written", "output", s.Name, "count", len(point),
+				"error", err)
+			return err
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	if spoolErr := s.spool(point); spoolErr != nil {
+		return fmt.Errorf("failed to spool %d points: %w (write error: %w)", len(point), spoolErr, err)
+	}
+	segments, size := s.backlog()
+	slog.WarnContext(ctx, "Spooled points", "output", s.Name, "count", len(point), "batches", segments, "bytes", size)
+	return fmt.Errorf("spooled %d points: %w", len(point), err)
+}
+
+// Replay writes the backlog to the Output in order, stopping at the first failure.
+func (s *Spool) Replay(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.reportBacklog()
+	return s.replay(ctx)
+}
+
+// Backlog returns the number of spooled batches and their total size in bytes.
+func (s *Spool) Backlog() (int, int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.backlog()
+}
+
+// segments returns the segment files in order, oldest first.
+// It should only be called while holding the lock.
+func (s *Spool) segments() ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	entries = slices.DeleteFunc(entries, func(e os.DirEntry) bool {
+		return e.IsDir() || !strings.HasSuffix(e.Name(), segmentExt)
+	})
+	// names are zero padded timestamps, so they sort in order. os.ReadDir already sorts by name.
+	return entries, nil
+}
+
+// backlog returns the number of spooled batches and their total size in bytes.
+// It should only be called while holding the lock.
+func (s *Spool) backlog() (int, int64) {
+	entries, err := s.segments()
+	if err != nil {
+		return 0, 0
+	}
+	var size int64
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	return len(entries), size
+}
+
+// reportBacklog calls OnBacklog with the backlog, if set.
+// It should only be called while holding the lock.
+func (s *Spool) reportBacklog() {
+	if s.OnBacklog != nil {
+		s.OnBacklog(s.backlog())
+	}
+}
+
+// replay writes each segment in order, deleting it once written. Segments that fail with a Permanent error
+// are dropped, so that they don't block the rest of the backlog.
+// It should only be called while holding the lock.
+func (s *Spool) replay(ctx context.Context) error {
+	entries, err := s.segments()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		path := filepath.Join(s.Dir, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if s.MaxAge > 0 && time.Since(info.ModTime()) > s.MaxAge {
+			slog.WarnContext(ctx, "Dropping spooled batch older than max age", "output", s.Name, "batch", e.Name(),
+				"max_age", s.MaxAge.String())
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		points, err := readSegment(path)
+		if err != nil {
+			// a corrupt segment would block the backlog forever, so drop it
+			slog.WarnContext(ctx, "Dropping unreadable spooled batch", "output", s.Name, "batch", e.Name(), "error", err)
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		err = s.Output.WritePoint(ctx, points...)
+		if Permanent(err) {
+			slog.ErrorContext(ctx, "Dropping spooled batch that can't be written", "output", s.Name,
+				"batch", e.Name(), "count", len(points), "error", err)
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Replayed spooled points", "output", s.Name, "count", len(points))
+	}
+	return nil
+}
+
+// spool writes points to a new segment file, then drops the oldest segments if over MaxBytes.
+// It should only be called while holding the lock.
+func (s *Spool) spool(points []*write.Point) error {
+	spooled := make([]spooledPoint, len(points))
+	for i, p := range points {
+		sp := spooledPoint{
+			Name:   p.Name(),
+			Tags:   make(map[string]string),
+			Fields: make(map[string]interface{}),
+			Time:   p.Time(),
+		}
+		for _, t := range p.TagList() {
+			sp.Tags[t.Key] = t.Value
+		}
+		for _, f := range p.FieldList() {
+			sp.Fields[f.Key] = f.Value
+		}
+		spooled[i] = sp
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(spooled); err != nil {
+		return err
+	}
+	// write to a temp file and rename, so a crash never leaves a partial segment
+	name := fmt.Sprintf("%020d%s", time.Now().UnixNano(), segmentExt)
+	tmp := filepath.Join(s.Dir, name+".tmp")
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.Dir, name)); err != nil {
+		return err
+	}
+	if s.MaxBytes <= 0 {
+		return nil
+	}
+	entries, err := s.segments()
+	if err != nil {
+		return err
+	}
+	_, size := s.backlog()
+	for _, e := range entries[:len(entries)-1] {
+		if size <= s.MaxBytes {
+			break
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		slog.Warn("Dropping spooled batch, backlog over max bytes", "output", s.Name, "batch", e.Name(),
+			"max_bytes", s.MaxBytes)
+		if err := os.Remove(filepath.Join(s.Dir, e.Name())); err != nil {
+			return err
+		}
+		size -= info.Size()
+	}
+	return nil
+}
+
+// readSegment reads the points from a segment file.
+func readSegment(path string) ([]*write.Point, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var spooled []spooledPoint
+	if err := gob.NewDecoder(f).Decode(&spooled); err != nil {
+		return nil, err
+	}
+	points := make([]*write.Point, len(spooled))
+	for i, sp := range spooled {
+		points[i] = write.NewPoint(sp.Name, sp.Tags, sp.Fields, sp.Time)
+	}
+	return points, nil
+}
@@ -0,0 +1,173 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyOutput records written points, failing while down is true, and rejecting points with a
+// temperature in reject.
+type flakyOutput struct {
+	down    bool
+	reject  []float64
+	written []*write.Point
+}
+
+func (f *flakyOutput) WritePoint(_ context.Context, point ...*write.Point) error {
+	if f.down {
+		return errors.New("database is down")
+	}
+	for _, p := range point {
+		if slices.Contains(f.reject, p.FieldList()[1].Value.(float64)) {
+			return backoff.Permanent(errors.New("400 Bad Request: invalid point"))
+		}
+	}
+	f.written = append(f.written, point...)
+	return nil
+}
+
+// testPoint creates a point with a single field.
+func testPoint(i int) *write.Point {
+	return write.NewPoint("forecast", map[string]string{"source": "nws"},
+		map[string]interface{}{"temperature": float64(i), "sun_up": int64(i)}, time.Unix(int64(i)*3600, 0))
+}
+
+func TestSpool_WritePoint(t *testing.T) {
+	o := &flakyOutput{down: true}
+	s, err := NewSpool("test", o, filepath.Join(t.TempDir(), "test"), 0, 0)
+	require.NoError(t, err)
+
+	// failed writes are spooled
+	assert.Error(t, s.WritePoint(context.Background(), testPoint(1), testPoint(2)))
+	assert.Error(t, s.WritePoint(context.Background(), testPoint(3)))
+	segments, size := s.Backlog()
+	assert.Equal(t, 2, segments)
+	assert.Greater(t, size, int64(0))
+	assert.Empty(t, o.written)
+
+	// the backlog is written in order before new points
+	o.down = false
+	require.NoError(t, s.WritePoint(context.Background(), testPoint(4)))
+	require.Len(t, o.written, 4)
+	for i, p := range o.written {
+		assert.Equal(t, write.PointToLineProtocol(testPoint(i+1), time.Second), write.PointToLineProtocol(p, time.Second))
+	}
+	segments, _ = s.Backlog()
+	assert.Equal(t, 0, segments)
+}
+
+func TestSpool_Replay(t *testing.T) {
+	o := &flakyOutput{down: true}
+	s, err := NewSpool("test", o, t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	assert.Error(t, s.WritePoint(context.Background(), testPoint(1)))
+	assert.Error(t, s.Replay(context.Background()))
+
+	o.down = false
+	require.NoError(t, s.Replay(context.Background()))
+	assert.Len(t, o.written, 1)
+}
+
+func TestSpool_Limits(t *testing.T) {
+	o := &flakyOutput{down: true}
+	dir := t.TempDir()
+	s, err := NewSpool("test", o, dir, 0, 0)
+	require.NoError(t, err)
+	assert.Error(t, s.WritePoint(context.Background(), testPoint(1)))
+	_, size := s.Backlog()
+
+	// only room for two batches
+	s.MaxBytes = 2*size + size/2
+	assert.Error(t, s.WritePoint(context.Background(), testPoint(2)))
+	assert.Error(t, s.WritePoint(context.Background(), testPoint(3)))
+	segments, _ := s.Backlog()
+	assert.Equal(t, 2, segments)
+
+	// the oldest is too old to replay
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, entries[0].Name()), old, old))
+	s.MaxAge = time.Hour
+
+	o.down = false
+	require.NoError(t, s.Replay(context.Background()))
+	require.Len(t, o.written, 1)
+	assert.Equal(t, 3.0, o.written[0].FieldList()[1].Value)
+}
+
+func TestSpool_PermanentErrors(t *testing.T) {
+	o := &flakyOutput{down: true}
+	s, err := NewSpool("test", o, t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	var batches []int
+	s.OnBacklog = func(b int, _ int64) {
+		batches = append(batches, b)
+	}
+	assert.Error(t, s.WritePoint(context.Background(), testPoint(1)))
+	assert.Error(t, s.WritePoint(context.Background(), testPoint(2)))
+	assert.Error(t, s.WritePoint(context.Background(), testPoint(3)))
+
+	// a batch that can never be written doesn't block the rest of the backlog
+	o.down = false
+	o.reject = []float64{2}
+	require.NoError(t, s.Replay(context.Background()))
+	require.Len(t, o.written, 2)
+	assert.Equal(t, 3.0, o.written[1].FieldList()[1].Value)
+
+	// and new points that can't be written aren't spooled
+	o.reject = []float64{4}
+	assert.Error(t, s.WritePoint(context.Background(), testPoint(4)))
+	assert.Equal(t, []int{1, 2, 3, 0, 0}, batches)
+}
+
+func TestPermanent(t *testing.T) {
+	var tests = []struct {
+		err       error
+		permanent bool
+	}{
+		{errors.New("connection refused"), false},
+		{backoff.Permanent(errors.New("400 Bad Request")), true},
+		{&ihttp.Error{StatusCode: 401}, true},
+		{&ihttp.Error{StatusCode: 429}, false},
+		{&ihttp.Error{StatusCode: 503}, false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			assert.Equal(t, tt.permanent, Permanent(tt.err))
+		})
+	}
+}
+
+// closingOutput records whether it was closed.
+type closingOutput struct {
+	flakyOutput
+	closed bool
+}
+
+func (c *closingOutput) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestSpool_Close(t *testing.T) {
+	o := &closingOutput{}
+	s, err := NewSpool("test", o, filepath.Join(t.TempDir(), "test"), 0, 0)
+	require.NoError(t, err)
+	s.Start(time.Millisecond)
+	f := NewFanout(Named{"test", s}, Named{"plain", &flakyOutput{}})
+	require.NoError(t, f.Close())
+	assert.True(t, o.closed)
+}
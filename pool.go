@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cancelGrace is how long Shutdown waits for fetches to return after cancelling them.
+const cancelGrace = 5 * time.Second
+
+// FetchPool fetches scheduled forecasts concurrently, limiting the number of fetches running at once
+// overall and for each source, and making sure the same fetch doesn't run twice at once.
+type FetchPool struct {
+	workers       chan struct{}
+	sourceWorkers map[string]int
+	timeout       time.Duration
+	lock          sync.Mutex
+	// sources are the semaphores limiting the fetches from each source.
+	sources map[string]chan struct{}
+	// inFlight are the fetches that are waiting or running.
+	inFlight map[CacheKey]bool
+	wg       sync.WaitGroup
+	// ctx is the parent of the fetches' contexts, cancelled by cancel when shutdown times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// quit is closed on shutdown, so that fetches that haven't started yet don't start.
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+// NewFetchPool creates a FetchPool with the limits in config.
+func NewFetchPool(config SchedulerConfig) *FetchPool {
+	workers := config.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &FetchPool{
+		ctx:           ctx,
+		cancel:        cancel,
+		quit:          make(chan struct{}),
+		workers:       make(chan struct{}, workers),
+		sourceWorkers: config.SourceWorkers,
+		timeout:       timeout,
+		sources:       make(map[string]chan struct{}),
+		inFlight:      make(map[CacheKey]bool),
+	}
+}
+
+// Go runs fetch in the background after delay, once there is a free worker for its source.
+// fetch gets a context that is cancelled after the timeout. It returns false without running fetch if
+// the previous fetch for key hasn't finished yet. done is called when fetch returns, or when the pool
+// shuts down before fetch starts, if not nil.
+func (p *FetchPool) Go(key CacheKey, delay time.Duration, fetch func(ctx context.Context), done func()) bool {
+	p.lock.Lock()
+	if p.inFlight[key] {
+		p.lock.Unlock()
+		return false
+	}
+	p.inFlight[key] = true
+	source := p.sourceSemaphore(key.Source)
+	p.lock.Unlock()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() {
+			p.lock.Lock()
+			delete(p.inFlight, key)
+			p.lock.Unlock()
+			if done != nil {
+				done()
+			}
+		}()
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-p.quit:
+				return
+			}
+		}
+		select {
+		case source <- struct{}{}:
+		case <-p.quit:
+			return
+		}
+		defer func() { <-source }()
+		select {
+		case p.workers <- struct{}{}:
+		case <-p.quit:
+			return
+		}
+		defer func() { <-p.workers }()
+		// the semaphores may have been free at the same time as quit was closed
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+		ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
+		defer cancel()
+		fetch(ctx)
+	}()
+	return true
+}
+
+// Wait waits for all fetches to finish.
+func (p *FetchPool) Wait() {
+	p.wg.Wait()
+}
+
+// Shutdown stops fetches that are waiting to start, and waits for running fetches to finish.
+// If ctx is done first, the fetches are cancelled, and it waits up to cancelGrace for them to return
+// before returning ctx.Err(), so that nothing is still writing when the outputs are closed.
+func (p *FetchPool) Shutdown(ctx context.Context) error {
+	p.quitOnce.Do(func() {
+		close(p.quit)
+	})
+	done := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	p.cancel()
+	select {
+	case <-done:
+	case <-time.After(cancelGrace):
+	}
+	return ctx.Err()
+}
+
+// sourceSemaphore returns the semaphore limiting fetches from src, creating it the first time.
+// It should only be called while holding the lock.
+func (p *FetchPool) sourceSemaphore(src string) chan struct{} {
+	sem, ok := p.sources[src]
+	if !ok {
+		limit := p.sourceWorkers[src]
+		if limit <= 0 || limit > cap(p.workers) {
+			limit = cap(p.workers)
+		}
+		sem = make(chan struct{}, limit)
+		p.sources[src] = sem
+	}
+	return sem
+}
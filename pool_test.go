@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchPool_SourceLimit(t *testing.T) {
+	p := NewFetchPool(SchedulerConfig{Workers: 4, SourceWorkers: map[string]int{"nws": 1}})
+	var running, most atomic.Int32
+	fetch := func(context.Context) {
+		n := running.Add(1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		require.True(t, p.Go(CacheKey{Name: name, Source: "nws"}, 0, fetch, nil))
+	}
+	p.Wait()
+	assert.Equal(t, int32(1), most.Load())
+}
+
+func TestFetchPool_SkipsRunningFetch(t *testing.T) {
+	p := NewFetchPool(SchedulerConfig{})
+	key := CacheKey{Name: "home", Source: "nws"}
+	release := make(chan struct{})
+	require.True(t, p.Go(key, 0, func(context.Context) { <-release }, nil))
+	// the same location and source is skipped until the first fetch is done
+	assert.False(t, p.Go(key, 0, func(context.Context) {}, nil))
+	// other sources aren't
+	other := key
+	other.Source = "metno"
+	assert.True(t, p.Go(other, 0, func(context.Context) {}, nil))
+	close(release)
+	p.Wait()
+	assert.True(t, p.Go(key, 0, func(context.Context) {}, nil))
+	p.Wait()
+}
+
+func TestFetchPool_Shutdown(t *testing.T) {
+	p := NewFetchPool(SchedulerConfig{Workers: 1})
+	var started, done atomic.Int32
+	running, cancelled := make(chan struct{}), make(chan struct{})
+	// a running fetch is cancelled when shutdown times out
+	require.True(t, p.Go(CacheKey{Name: "running"}, 0, func(ctx context.Context) {
+		started.Add(1)
+		close(running)
+		<-ctx.Done()
+		close(cancelled)
+	}, func() { done.Add(1) }))
+	<-running
+	// fetches waiting for jitter or a worker never start
+	require.True(t, p.Go(CacheKey{Name: "queued"}, 0, func(context.Context) {
+		started.Add(1)
+	}, func() { done.Add(1) }))
+	require.True(t, p.Go(CacheKey{Name: "jitter"}, time.Hour, func(context.Context) {
+		started.Add(1)
+	}, func() { done.Add(1) }))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := p.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	<-cancelled
+	p.Wait()
+	assert.Equal(t, int32(1), started.Load())
+	assert.Equal(t, int32(3), done.Load())
+}
+
+func TestFetchPool_ShutdownWaitsForCancelledFetch(t *testing.T) {
+	p := NewFetchPool(SchedulerConfig{})
+	running := make(chan struct{})
+	var wrote atomic.Bool
+	// a slow fetch that still writes what it has after being cancelled
+	require.True(t, p.Go(CacheKey{Name: "slow"}, 0, func(ctx context.Context) {
+		close(running)
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		wrote.Store(true)
+	}, nil))
+	<-running
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := p.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// the outputs may be closed now
+	assert.True(t, wrote.Load())
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
 
 	"github.com/iancoleman/strcase"
 
+	"github.com/tedpearson/ForecastMetrics/v3/internal/promql"
 	"github.com/tedpearson/ForecastMetrics/v3/source"
 )
 
@@ -26,15 +28,30 @@ type Metric struct {
 	Metric    float64
 }
 
+// PromResult is a single series in a matrix result, or a single sample in a vector result if Value is set.
 type PromResult struct {
 	Metric map[string]string `json:"metric"`
 	Values [][]any           `json:"values"`
+	Value  []any             `json:"-"`
+}
+
+// MarshalJSON implements json.Marshaler, writing "value" for vector results and "values" for matrix results.
+func (r PromResult) MarshalJSON() ([]byte, error) {
+	if r.Value != nil {
+		return json.Marshal(struct {
+			Metric map[string]string `json:"metric"`
+			Value  []any             `json:"value"`
+		}{r.Metric, r.Value})
+	}
+	type matrixResult PromResult
+	return json.Marshal(matrixResult(r))
 }
 
 type PromResponse struct {
-	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
-	Data   struct {
+	Status   string   `json:"status"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Data     struct {
 		ResultType string       `json:"resultType"`
 		Result     []PromResult `json:"result"`
 	} `json:"data,omitempty"`
@@ -46,10 +63,17 @@ type PromConverter struct {
 	PrecipProbability        float64
 }
 
-// ConvertToTimeSeries converts a source.Forecast to a PromResponse which can be
+// Series is a single time series from a forecast, with any labels that identify it
+// in addition to the metric name, source and location.
+type Series struct {
+	Labels map[string]string
+	Points []Metric
+}
+
+// ConvertToTimeSeries converts forecasts from one or more sources to a PromResponse which can be
 // marshalled to json. It gets the closest corresponding real point in the last hour
 // before the timestamp, otherwise that timestamp is dropped.
-func (pc PromConverter) ConvertToTimeSeries(forecast source.Forecast, params Params) PromResponse {
+func (pc PromConverter) ConvertToTimeSeries(forecasts []SourceForecast, params Params) PromResponse {
 	pr := PromResponse{
 		Status: "success",
 		Data: struct {
@@ -59,12 +83,93 @@ func (pc PromConverter) ConvertToTimeSeries(forecast source.Forecast, params Par
 			ResultType: "matrix",
 		},
 	}
-	points := pc.GetMetric(forecast, params.Metric)
-	// get timestamps
+	timestamps := GetTimestamps(params.Start, params.End, params.Step)
+	pr.Data.Result = []PromResult{}
+	for _, sf := range forecasts {
+		for _, series := range pc.GetSeries(*sf.Forecast, params.Metric, params.Filters) {
+			metric := map[string]string{
+				"__name__": params.Metric,
+				"source":   sf.Source,
+				"location": params.Location.Name,
+			}
+			for k, v := range series.Labels {
+				metric[k] = v
+			}
+			pr.Data.Result = append(pr.Data.Result, PromResult{
+				Metric: metric,
+				Values: resample(series.Points, timestamps, params.Step),
+			})
+		}
+	}
+	return pr
+}
+
+// InstantLookback is how far before an instant query's time a forecast point may be to be used,
+// since forecasts are mostly hourly.
+const InstantLookback int64 = 3600
+
+// ConvertToVector converts forecasts to a PromResponse with a vector result, with the value of each
+// series at params.Start. It uses the closest point in the hour before then, otherwise the series is dropped.
+func (pc PromConverter) ConvertToVector(forecasts []SourceForecast, params Params) PromResponse {
+	pr := PromResponse{
+		Status: "success",
+		Data: struct {
+			ResultType string       `json:"resultType"`
+			Result     []PromResult `json:"result"`
+		}{
+			ResultType: "vector",
+		},
+	}
+	pr.Data.Result = []PromResult{}
+	matrix := pc.ConvertToTimeSeries(forecasts, Params{
+		Start:       params.Start,
+		End:         params.Start,
+		Step:        InstantLookback,
+		ParsedQuery: params.ParsedQuery,
+	})
+	for _, result := range matrix.Data.Result {
+		if len(result.Values) == 0 {
+			continue
+		}
+		pr.Data.Result = append(pr.Data.Result, PromResult{
+			Metric: result.Metric,
+			Value:  result.Values[0],
+		})
+	}
+	return pr
+}
+
+// SeriesToResponse converts evaluated series to a PromResponse with a matrix result, or a vector result
+// with the last point of each series if instant is true.
+func SeriesToResponse(series []promql.Series, instant bool) PromResponse {
+	pr := PromResponse{Status: "success"}
+	pr.Data.ResultType = "matrix"
+	if instant {
+		pr.Data.ResultType = "vector"
+	}
+	pr.Data.Result = []PromResult{}
+	for _, s := range series {
+		values := make([][]any, len(s.Points))
+		for i, p := range s.Points {
+			values[i] = []any{p.T, fmt.Sprintf("%f", p.V)}
+		}
+		result := PromResult{Metric: s.Labels, Values: values}
+		if instant {
+			if len(values) == 0 {
+				continue
+			}
+			result = PromResult{Metric: s.Labels, Value: values[len(values)-1]}
+		}
+		pr.Data.Result = append(pr.Data.Result, result)
+	}
+	return pr
+}
+
+// resample finds a value for each timestamp.
+func resample(points []Metric, timestamps []int64, step int64) [][]any {
 	// for each timestamp, find equal point or if any point came before it by no more than 1 hour
 	// if not, discard timestamp
 	i := 0
-	timestamps := GetTimestamps(params.Start, params.End, params.Step)
 	values := make([][]any, 0, len(timestamps))
 	for _, ts := range timestamps {
 		for j, p := range points[i:] {
@@ -72,7 +177,7 @@ func (pc PromConverter) ConvertToTimeSeries(forecast source.Forecast, params Par
 				i = j
 				break
 			}
-			if p.Timestamp == ts || (p.Timestamp < ts && p.Timestamp+params.Step > ts) {
+			if p.Timestamp == ts || (p.Timestamp < ts && p.Timestamp+step > ts) {
 				i = j
 				v := []any{ts, fmt.Sprintf("%f", p.Metric)}
 				values = append(values, v)
@@ -80,15 +185,50 @@ func (pc PromConverter) ConvertToTimeSeries(forecast source.Forecast, params Par
 			}
 		}
 	}
-	pr.Data.Result = []PromResult{{
-		Metric: map[string]string{
-			"__name__": params.Metric,
-			"source":   params.Source,
-			"location": params.Location.Name,
-		},
-		Values: values,
-	}}
-	return pr
+	return values
+}
+
+// GetSeries gets all series for a metric from the forecast. Most metrics have a single series,
+// but hazards have a series for each phenomenon and significance.
+// Series with labels that don't match the filters are dropped.
+func (pc PromConverter) GetSeries(forecast source.Forecast, metric string, filters []*promql.Matcher) []Series {
+	if metric != pc.ForecastMeasurementName+"_hazard" {
+		return []Series{{Points: pc.GetMetric(forecast, metric)}}
+	}
+	var series []Series
+	index := make(map[[2]string]int)
+	for _, hazard := range forecast.Hazards {
+		labels := map[string]string{
+			"phenomenon":   hazard.Phenomenon,
+			"significance": hazard.Significance,
+		}
+		if !matchesFilters(labels, filters) {
+			continue
+		}
+		key := [2]string{hazard.Phenomenon, hazard.Significance}
+		i, ok := index[key]
+		if !ok {
+			i = len(series)
+			index[key] = i
+			series = append(series, Series{Labels: labels})
+		}
+		series[i].Points = append(series[i].Points, Metric{
+			Timestamp: hazard.Time.Unix(),
+			Metric:    1,
+		})
+	}
+	return series
+}
+
+// matchesFilters returns false if any label doesn't match its filters. Filters on labels the series
+// doesn't have are ignored.
+func matchesFilters(labels map[string]string, filters []*promql.Matcher) bool {
+	for _, m := range filters {
+		if lv, ok := labels[m.Name]; ok && !m.Matches(lv) {
+			return false
+		}
+	}
+	return true
 }
 
 // GetMetric fetches a single field from each forecast point in the format
@@ -128,8 +268,8 @@ func (pc PromConverter) GetMetric(forecast source.Forecast, metric string) []Met
 		}
 		return points
 	} else if parts[0] == pc.ForecastMeasurementName {
-		points := make([]Metric, len(forecast.WeatherRecords))
-		for i, record := range forecast.WeatherRecords {
+		points := make([]Metric, 0, len(forecast.WeatherRecords))
+		for _, record := range forecast.WeatherRecords {
 			field := reflect.ValueOf(record).FieldByName(name)
 			if !field.IsValid() {
 				return nil
@@ -137,10 +277,10 @@ func (pc PromConverter) GetMetric(forecast source.Forecast, metric string) []Met
 			if field.IsNil() {
 				continue
 			}
-			points[i] = Metric{
+			points = append(points, Metric{
 				Timestamp: record.Time.Unix(),
 				Metric:    ValueToFloat(field.Elem()),
-			}
+			})
 		}
 		return points
 	}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tedpearson/ForecastMetrics/v3/source"
+)
+
+func TestPromResult_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   PromResult
+		expected string
+	}{
+		{
+			name: "vector",
+			result: PromResult{
+				Metric: map[string]string{"__name__": "forecast_temperature"},
+				Value:  []any{int64(1700000000), "20.500000"},
+			},
+			expected: `{"metric":{"__name__":"forecast_temperature"},"value":[1700000000,"20.500000"]}`,
+		},
+		{
+			name: "matrix",
+			result: PromResult{
+				Metric: map[string]string{"__name__": "forecast_temperature"},
+				Values: [][]any{{int64(1700000000), "20.500000"}, {int64(1700003600), "21.000000"}},
+			},
+			expected: `{"metric":{"__name__":"forecast_temperature"},"values":[[1700000000,"20.500000"],[1700003600,"21.000000"]]}`,
+		},
+		{
+			name: "empty matrix",
+			result: PromResult{
+				Metric: map[string]string{"__name__": "forecast_temperature"},
+				Values: [][]any{},
+			},
+			expected: `{"metric":{"__name__":"forecast_temperature"},"values":[]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.result)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.expected, string(b))
+		})
+	}
+}
+
+func TestPromConverter_ConvertToVector(t *testing.T) {
+	const ts = 1700000000
+	pc := PromConverter{ForecastMeasurementName: "forecast", AstronomyMeasurementName: "astronomy"}
+	// forecast returns a forecast with a temperature for each offset from ts, equal to the offset
+	forecast := func(offsets ...int64) *source.Forecast {
+		f := &source.Forecast{}
+		for _, offset := range offsets {
+			temp := float64(offset)
+			f.WeatherRecords = append(f.WeatherRecords, source.WeatherRecord{
+				Time:        time.Unix(ts+offset, 0),
+				Temperature: &temp,
+			})
+		}
+		return f
+	}
+	tests := []struct {
+		name      string
+		forecasts []SourceForecast
+		expected  string
+	}{
+		{
+			name:      "point at time",
+			forecasts: []SourceForecast{{Source: "nws", Forecast: forecast(-3600, 0, 3600)}},
+			expected: `[{"metric":{"__name__":"forecast_temperature","location":"Home","source":"nws"},
+				"value":[1700000000,"0.000000"]}]`,
+		},
+		{
+			name:      "latest point within lookback",
+			forecasts: []SourceForecast{{Source: "nws", Forecast: forecast(-7200, -1800, 1800)}},
+			expected: `[{"metric":{"__name__":"forecast_temperature","location":"Home","source":"nws"},
+				"value":[1700000000,"-1800.000000"]}]`,
+		},
+		{
+			name:      "oldest point within lookback",
+			forecasts: []SourceForecast{{Source: "nws", Forecast: forecast(-InstantLookback + 1)}},
+			expected: `[{"metric":{"__name__":"forecast_temperature","location":"Home","source":"nws"},
+				"value":[1700000000,"-3599.000000"]}]`,
+		},
+		{
+			name:      "point at lookback",
+			forecasts: []SourceForecast{{Source: "nws", Forecast: forecast(-InstantLookback)}},
+			expected:  `[]`,
+		},
+		{
+			name:      "only later points",
+			forecasts: []SourceForecast{{Source: "nws", Forecast: forecast(1, 3600)}},
+			expected:  `[]`,
+		},
+		{
+			name: "several sources",
+			forecasts: []SourceForecast{
+				{Source: "nws", Forecast: forecast(0)},
+				{Source: "metno", Forecast: forecast(-3600)},
+				{Source: "openmeteo", Forecast: forecast(-60)},
+			},
+			expected: `[
+				{"metric":{"__name__":"forecast_temperature","location":"Home","source":"nws"},"value":[1700000000,"0.000000"]},
+				{"metric":{"__name__":"forecast_temperature","location":"Home","source":"openmeteo"},"value":[1700000000,"-60.000000"]}
+			]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := pc.ConvertToVector(tt.forecasts, Params{
+				Start: ts,
+				End:   ts,
+				ParsedQuery: ParsedQuery{
+					Metric:   "forecast_temperature",
+					Location: Location{Name: "Home"},
+				},
+			})
+			b, err := json.Marshal(pr)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"status":"success","data":{"resultType":"vector","result":`+tt.expected+`}}`, string(b))
+		})
+	}
+}
+
+func TestParseTimeParam(t *testing.T) {
+	tests := []struct {
+		param    string
+		expected int64
+		err      bool
+	}{
+		{param: "1700000000", expected: 1700000000},
+		{param: "1700000000.781", expected: 1700000000},
+		{param: "2023-11-14T22:13:20Z", expected: 1700000000},
+		{param: "2023-11-14T17:13:20.5-05:00", expected: 1700000000},
+		{param: "2023-11-14 22:13:20", err: true},
+		{param: "now", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			actual, err := parseTimeParam(tt.param)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestServer_ParseInstantParams(t *testing.T) {
+	const query = `forecast_temperature{location="38.9,-77.03|Home",source="nws"}`
+	s := newTestServer(t)
+	tests := []struct {
+		name string
+		time string
+		// expected is the query time, or now if zero
+		expected int64
+		err      string
+	}{
+		{name: "unix", time: "1700000000.5", expected: 1700000000},
+		{name: "rfc3339", time: "2023-11-14T22:13:20Z", expected: 1700000000},
+		{name: "now"},
+		{name: "invalid", time: "yesterday", err: "invalid time yesterday: must be a unix timestamp or RFC 3339"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"query": {query}}
+			if tt.time != "" {
+				form.Set("time", tt.time)
+			}
+			params, err := s.ParseInstantParams(context.Background(), form)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.expected == 0 {
+				assert.InDelta(t, time.Now().Unix(), params.Start, 1)
+			} else {
+				assert.Equal(t, tt.expected, params.Start)
+			}
+			assert.Equal(t, params.Start, params.End)
+			assert.Equal(t, InstantLookback, params.Step)
+			assert.Equal(t, "forecast_temperature", params.Metric)
+			assert.Equal(t, []string{"nws"}, params.Sources)
+		})
+	}
+}
+
+func TestPromConverter_GetSeriesHazards(t *testing.T) {
+	const ts = 1700000000
+	hazard := func(hour int64, phenomenon, significance string) source.Hazard {
+		return source.Hazard{Time: time.Unix(ts+hour*3600, 0), Phenomenon: phenomenon, Significance: significance}
+	}
+	forecast := source.Forecast{Hazards: []source.Hazard{
+		hazard(0, "WS", "W"),
+		hazard(0, "WC", "Y"),
+		hazard(1, "WS", "W"),
+		hazard(1, "WS", "A"),
+	}}
+	// series returns a hazard series with a point for each hour
+	series := func(phenomenon, significance string, hours ...int64) Series {
+		s := Series{Labels: map[string]string{"phenomenon": phenomenon, "significance": significance}}
+		for _, hour := range hours {
+			s.Points = append(s.Points, Metric{Timestamp: ts + hour*3600, Metric: 1})
+		}
+		return s
+	}
+	tests := []struct {
+		name     string
+		matchers string
+		expected []Series
+	}{
+		{
+			name:     "every hazard",
+			expected: []Series{series("WS", "W", 0, 1), series("WC", "Y", 0), series("WS", "A", 1)},
+		},
+		{
+			name:     "phenomenon",
+			matchers: `,phenomenon="WS"`,
+			expected: []Series{series("WS", "W", 0, 1), series("WS", "A", 1)},
+		},
+		{
+			name:     "not significance",
+			matchers: `,significance!="W"`,
+			expected: []Series{series("WC", "Y", 0), series("WS", "A", 1)},
+		},
+		{
+			name:     "every filter applies",
+			matchers: `,phenomenon=~"W.",significance="W"`,
+			expected: []Series{series("WS", "W", 0, 1)},
+		},
+		{
+			name:     "not regex",
+			matchers: `,phenomenon!~"WS|WW"`,
+			expected: []Series{series("WC", "Y", 0)},
+		},
+		{
+			name:     "other tags are ignored",
+			matchers: `,region="east"`,
+			expected: []Series{series("WS", "W", 0, 1), series("WC", "Y", 0), series("WS", "A", 1)},
+		},
+		{
+			name:     "no match",
+			matchers: `,phenomenon="TO"`,
+		},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pq, err := s.ParseQuery(context.Background(),
+				`forecast_hazard{location="38.9,-77.03|Home",source="nws"`+tt.matchers+`}`)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, s.PromConverter.GetSeries(forecast, pq.Metric, pq.Filters))
+		})
+	}
+}
+
+func TestPromConverter_GetSeriesIgnoresFilters(t *testing.T) {
+	temp := 20.0
+	forecast := source.Forecast{
+		WeatherRecords: []source.WeatherRecord{{Time: time.Unix(1700000000, 0), Temperature: &temp}},
+		Hazards:        []source.Hazard{{Time: time.Unix(1700000000, 0), Phenomenon: "WS", Significance: "W"}},
+	}
+	s := newTestServer(t)
+	pq, err := s.ParseQuery(context.Background(),
+		`forecast_temperature{location="38.9,-77.03|Home",source="nws",phenomenon="TO"}`)
+	require.NoError(t, err)
+	assert.Equal(t, []Series{{Points: []Metric{{Timestamp: 1700000000, Metric: 20}}}},
+		s.PromConverter.GetSeries(forecast, pq.Metric, pq.Filters))
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/promql"
+)
+
+// Influx implements promql.Querier by translating selectors to flux queries, so that queries can be
+// evaluated over forecasts in InfluxDB.
+type Influx struct {
+	QueryApi api.QueryAPI
+	Bucket   string
+	// Measurements are the names of the measurements that metric names start with.
+	Measurements []string
+	// Client is the client QueryApi belongs to, closed by Close if not nil.
+	Client influxdb2.Client
+}
+
+// Close closes the influx client.
+func (i Influx) Close() error {
+	if i.Client != nil {
+		i.Client.Close()
+	}
+	return nil
+}
+
+// Select implements promql.Querier by querying the measurement and field of the metric name, filtered
+// by the selector's other labels. Each series is a distinct set of tags.
+func (i Influx) Select(ctx context.Context, vs *promql.VectorSelector, start, end int64) ([]promql.Series, error) {
+	query, err := FluxQuery(i.Bucket, i.Measurements, vs, start, end)
+	if err != nil {
+		return nil, err
+	}
+	result, err := i.QueryApi.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = result.Close()
+	}()
+	var series []promql.Series
+	index := make(map[string]int)
+	for result.Next() {
+		record := result.Record()
+		v, ok := toFloat(record.Value())
+		if !ok {
+			continue
+		}
+		labels := map[string]string{"__name__": vs.Name}
+		for k, val := range record.Values() {
+			if s, ok := val.(string); ok && !strings.HasPrefix(k, "_") && k != "result" && k != "table" {
+				labels[k] = s
+			}
+		}
+		key := seriesKey(labels)
+		idx, ok := index[key]
+		if !ok {
+			idx = len(series)
+			index[key] = idx
+			series = append(series, promql.Series{Labels: labels})
+		}
+		series[idx].Points = append(series[idx].Points, promql.Point{T: record.Time().Unix(), V: v})
+	}
+	return series, result.Err()
+}
+
+// FluxQuery translates a vector selector to a flux query for points from start to end in unix seconds.
+// Metric names are one of measurements and a field joined by an underscore, e.g. forecast_temperature.
+func FluxQuery(bucket string, measurements []string, vs *promql.VectorSelector, start, end int64) (string, error) {
+	measurement, field, ok := SplitMetricName(vs.Name, measurements)
+	if !ok {
+		return "", fmt.Errorf("invalid metric name: %s", vs.Name)
+	}
+	conditions := []string{
+		fmt.Sprintf(`r._measurement == %s`, fluxString(measurement)),
+		fmt.Sprintf(`r._field == %s`, fluxString(field)),
+	}
+	for _, m := range vs.Matchers {
+		if m.Name == "__name__" {
+			continue
+		}
+		column := fmt.Sprintf("r[%s]", fluxString(m.Name))
+		var condition string
+		switch m.Type {
+		case promql.MatchEqual:
+			condition = fmt.Sprintf("%s == %s", column, fluxString(m.Value))
+		case promql.MatchNotEqual:
+			condition = fmt.Sprintf("%s != %s", column, fluxString(m.Value))
+		case promql.MatchRegexp, promql.MatchNotRegexp:
+			condition = fmt.Sprintf("%s %s %s", column, m.Type, fluxRegexp(m.Value))
+		}
+		// prometheus treats missing labels as empty
+		if m.Matches("") {
+			condition = fmt.Sprintf("(not exists %s or %s)", column, condition)
+		}
+		conditions = append(conditions, condition)
+	}
+	// the stop time is exclusive
+	return fmt.Sprintf(`from(bucket: %s)
+  |> range(start: %s, stop: %s)
+  |> filter(fn: (r) => %s)`, fluxString(bucket), fluxTime(start), fluxTime(end+1),
+		strings.Join(conditions, " and ")), nil
+}
+
+// SplitMetricName splits a metric name into the measurement it starts with and the field, e.g.
+// weather_forecast_temperature into weather_forecast and temperature. Measurements may contain underscores,
+// so the longest matching measurement is used.
+func SplitMetricName(name string, measurements []string) (measurement, field string, ok bool) {
+	for _, m := range measurements {
+		f, found := strings.CutPrefix(name, m+"_")
+		if found && f != "" && len(m) > len(measurement) {
+			measurement, field, ok = m, f, true
+		}
+	}
+	return measurement, field, ok
+}
+
+var fluxStringReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", `\${`)
+
+// fluxString quotes a flux string literal.
+func fluxString(s string) string {
+	return `"` + fluxStringReplacer.Replace(s) + `"`
+}
+
+// fluxRegexp returns a flux regular expression literal that matches the whole string, as in prometheus.
+func fluxRegexp(re string) string {
+	return "/^(?:" + strings.ReplaceAll(re, "/", `\/`) + ")$/"
+}
+
+// fluxTime formats unix seconds as a flux time literal.
+func fluxTime(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.RFC3339)
+}
+
+// toFloat converts a flux value to a float64.
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// seriesKey returns a string identifying a set of labels.
+func seriesKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(labels[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
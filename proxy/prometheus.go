@@ -0,0 +1,61 @@
+// Package proxy answers queries for scheduled locations from the database the forecasts are written to,
+// so that historical forecasts and past data show up alongside the live forecast.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Prometheus forwards queries to a prometheus compatible api, such as VictoriaMetrics.
+type Prometheus struct {
+	// Url is the base url, e.g. http://localhost:8428
+	Url    string
+	Client *http.Client
+	// Username and Password are used for basic auth, if set.
+	Username string
+	Password string
+	// BearerToken is sent in the Authorization header, if set.
+	BearerToken string
+}
+
+// Forward sends a query to the api path, e.g. /api/v1/query_range, with the other parameters of the original
+// request. It returns the status, content type and body of the response, which should be returned as is.
+func (p Prometheus) Forward(ctx context.Context, path string, query string, form url.Values) (int, string, []byte, error) {
+	params := url.Values{}
+	for k, v := range form {
+		params[k] = v
+	}
+	params.Set("query", query)
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(p.Url, "/")+path,
+		strings.NewReader(params.Encode()))
+	if err != nil {
+		return 0, "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.BearerToken)
+	} else if p.Username != "" || p.Password != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("proxy request to %s failed: %w", p.Url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	return resp.StatusCode, resp.Header.Get("Content-Type"), body, nil
+}
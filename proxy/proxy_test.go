@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/promql"
+)
+
+func TestPrometheus_Forward(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/query_range", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, `forecast_temperature{location="Home"}`, r.Form.Get("query"))
+		assert.Equal(t, "60", r.Form.Get("step"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"status":"error"}`))
+	}))
+	defer server.Close()
+	p := Prometheus{Url: server.URL + "/", BearerToken: "token"}
+	form := url.Values{"query": {"original"}, "step": {"60"}}
+
+	status, contentType, body, err := p.Forward(context.Background(), "/api/v1/query_range",
+		`forecast_temperature{location="Home"}`, form)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, `{"status":"error"}`, string(body))
+}
+
+func TestFluxQuery(t *testing.T) {
+	expr, err := promql.Parse(`forecast_temperature{location="Home \"1\"",source=~"nws|a/b",forecast_time!="0"}`)
+	require.NoError(t, err)
+
+	measurements := []string{"forecast", "astronomy"}
+	query, err := FluxQuery("forecast", measurements, expr.(*promql.VectorSelector), 1717236000, 1717239600)
+	require.NoError(t, err)
+	assert.Equal(t, `from(bucket: "forecast")
+  |> range(start: 2024-06-01T10:00:00Z, stop: 2024-06-01T11:00:01Z)
+  |> filter(fn: (r) => r._measurement == "forecast" and r._field == "temperature" and `+
+		`r["location"] == "Home \"1\"" and r["source"] =~ /^(?:nws|a\/b)$/ and `+
+		`(not exists r["forecast_time"] or r["forecast_time"] != "0"))`, query)
+
+	expr, err = promql.Parse(`weather_forecast_precipitation_probability`)
+	require.NoError(t, err)
+	query, err = FluxQuery("forecast", []string{"weather", "weather_forecast"}, expr.(*promql.VectorSelector), 0, 0)
+	require.NoError(t, err)
+	assert.Contains(t, query, `r._measurement == "weather_forecast" and r._field == "precipitation_probability"`)
+
+	for _, name := range []string{"nounderscore", "other_temperature", "forecast_"} {
+		expr, err = promql.Parse(`{__name__="` + name + `"}`)
+		require.NoError(t, err)
+		_, err = FluxQuery("forecast", measurements, expr.(*promql.VectorSelector), 0, 0)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestSplitMetricName(t *testing.T) {
+	measurements := []string{"forecast", "weather_forecast", "astro"}
+	tests := []struct {
+		name        string
+		measurement string
+		field       string
+		ok          bool
+	}{
+		{"forecast_temperature", "forecast", "temperature", true},
+		{"forecast_precipitation_probability", "forecast", "precipitation_probability", true},
+		{"weather_forecast_temperature", "weather_forecast", "temperature", true},
+		{"astro_sun_up", "astro", "sun_up", true},
+		{"weather_temperature", "", "", false},
+		{"forecast", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			measurement, field, ok := SplitMetricName(tt.name, measurements)
+			assert.Equal(t, tt.measurement, measurement)
+			assert.Equal(t, tt.field, field)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
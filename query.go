@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/promql"
+)
+
+// maxQueryPoints is the most timestamps in a query, the same as prometheus' limit.
+const maxQueryPoints = 11000
+
+// forecastQuerier implements promql.Querier by getting forecasts from the Dispatcher.
+type forecastQuerier struct {
+	server *Server
+	// warnings are the errors of sources that failed, when others succeeded.
+	warnings []string
+}
+
+// Select implements promql.Querier by getting the forecasts for the location and sources of the
+// selector, and converting the selected metric to a series for each source. The whole forecast is
+// returned regardless of the time range.
+func (q *forecastQuerier) Select(ctx context.Context, vs *promql.VectorSelector, _, _ int64) ([]promql.Series, error) {
+	pq, err := q.server.ParseSelector(ctx, vs)
+	if err != nil {
+		return nil, err
+	}
+	forecasts, err := q.server.Dispatcher.GetForecasts(ctx, pq.Location, pq.Sources, pq.AdHoc)
+	if len(forecasts) == 0 {
+		return nil, err
+	}
+	q.warnings = append(q.warnings, warnings(err)...)
+	var series []promql.Series
+	for _, sf := range forecasts {
+		for _, s := range q.server.PromConverter.GetSeries(*sf.Forecast, pq.Metric, pq.Filters) {
+			labels := map[string]string{
+				"__name__": pq.Metric,
+				"source":   sf.Source,
+				"location": pq.Location.Name,
+			}
+			for k, v := range s.Labels {
+				labels[k] = v
+			}
+			points := make([]promql.Point, len(s.Points))
+			for i, p := range s.Points {
+				points[i] = promql.Point{T: p.Timestamp, V: p.Metric}
+			}
+			series = append(series, promql.Series{Labels: labels, Points: points})
+		}
+	}
+	return series, nil
+}
+
+// Evaluate evaluates a query with functions, operators or aggregations over forecasts for each
+// selector in it. The query's location and source labels are read from each selector.
+// It also returns warnings for sources that failed. Forecasts are abandoned when ctx is done.
+func (s *Server) Evaluate(ctx context.Context, params Params) ([]promql.Series, []string, error) {
+	q := &forecastQuerier{server: s}
+	series, err := s.engine().Query(ctx, q, params.Expr, params.Start, params.End, params.Step)
+	return series, q.warnings, err
+}
+
+// engine returns the promql.Engine used to evaluate queries. Points are valid for up to an hour,
+// since forecasts are mostly hourly.
+func (s *Server) engine() promql.Engine {
+	return promql.Engine{
+		Lookback:  time.Duration(InstantLookback) * time.Second,
+		MaxPoints: maxQueryPoints,
+	}
+}
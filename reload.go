@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/logging"
+	"github.com/tedpearson/ForecastMetrics/v3/source"
+)
+
+// Forecasters holds the enabled forecasters, which are replaced when the config is reloaded.
+type Forecasters struct {
+	lock        sync.RWMutex
+	forecasters map[string]source.Forecaster
+}
+
+// NewForecasters creates Forecasters holding forecasters.
+func NewForecasters(forecasters map[string]source.Forecaster) *Forecasters {
+	return &Forecasters{forecasters: forecasters}
+}
+
+// Get returns the enabled forecaster for a source.
+func (f *Forecasters) Get(src string) (source.Forecaster, bool) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	forecaster, ok := f.forecasters[src]
+	return forecaster, ok
+}
+
+// All returns a copy of the enabled forecasters by source.
+func (f *Forecasters) All() map[string]source.Forecaster {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return maps.Clone(f.forecasters)
+}
+
+// Names returns the sorted names of the enabled sources.
+func (f *Forecasters) Names() []string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return slices.Sorted(maps.Keys(f.forecasters))
+}
+
+// Set replaces the enabled forecasters.
+func (f *Forecasters) Set(forecasters map[string]source.Forecaster) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.forecasters = forecasters
+}
+
+// Reloader reloads the config and locations files when they change, or when the process receives SIGHUP.
+// If a file has errors, they are logged and the previous config keeps running.
+type Reloader struct {
+	ConfigService *ConfigService
+	Forecasters   *Forecasters
+	Budget        *Budget
+	// Interval is how often to check whether the files have changed.
+	Interval time.Duration
+}
+
+// Start starts the goroutine to watch for changes, until ctx is done.
+func (r Reloader) Start(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go r.run(ctx, hup)
+}
+
+// run checks the files every Interval, and reloads them unconditionally on SIGHUP.
+func (r Reloader) run(ctx context.Context, hup chan os.Signal) {
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	var lastErr string
+	for {
+		force := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading config and locations")
+			force = true
+		}
+		old, err := r.ConfigService.Reload(force)
+		if err != nil {
+			// only log each error once, instead of every interval until it's fixed
+			if msg := err.Error(); force || msg != lastErr {
+				slog.Error("Failed to reload, keeping the current config", "error", err)
+				lastErr = msg
+			}
+		} else {
+			lastErr = ""
+		}
+		if old != nil {
+			r.apply(*old, r.ConfigService.GetConfig())
+		}
+	}
+}
+
+// apply rebuilds the forecasters if the sources config changed, and changes the log level and the secrets
+// redacted from logs. Other settings are only used at startup.
+func (r Reloader) apply(old, config Config) {
+	slog.Info("Reloaded config", "file", r.ConfigService.configFile)
+	logging.SetSecrets(config.Secrets()...)
+	if old.Log.Level != config.Log.Level {
+		// the level is validated when the config is loaded
+		_ = logging.SetLevel(config.Log.Level)
+		slog.Info("Changed log level", "level", config.Log.Level)
+	}
+	oldSources, sources := old.Sources, config.Sources
+	// schedules and budgets are read by the scheduler and budget, not the forecasters
+	oldSources.Schedules, oldSources.Budgets = nil, nil
+	sources.Schedules, sources.Budgets = nil, nil
+	if !reflect.DeepEqual(oldSources, sources) || old.HttpCacheDir != config.HttpCacheDir {
+		r.Forecasters.Set(MakeForecasters(config))
+		slog.Info("Changed enabled sources", "sources", r.Forecasters.Names())
+	}
+	if r.Budget != nil {
+		r.Budget.SetLimits(config.Sources.Budgets)
+	}
+	old.Sources = config.Sources
+	old.HttpCacheDir = config.HttpCacheDir
+	old.Log.Level = config.Log.Level
+	if !reflect.DeepEqual(old, config) {
+		slog.Warn("Restart ForecastMetrics to apply config changes other than sources, locations and log level")
+	}
+}
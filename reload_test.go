@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/logging"
+	"github.com/tedpearson/ForecastMetrics/v3/source"
+)
+
+const reloadConfig = `sources:
+  enabled: [nws]
+server:
+  port: 8080
+`
+
+// newReloadConfigService creates a ConfigService reading reloadConfig and apiLocations from a temporary directory.
+func newReloadConfigService(t *testing.T) *ConfigService {
+	dir := t.TempDir()
+	configFile, locationsFile := filepath.Join(dir, "config.yaml"), filepath.Join(dir, "locations.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte(reloadConfig), 0644))
+	require.NoError(t, os.WriteFile(locationsFile, []byte(apiLocations), 0644))
+	return NewConfigService(configFile, locationsFile)
+}
+
+// captureLogs sends logs to the returned buffer until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	prev := slog.Default()
+	var buf bytes.Buffer
+	require.NoError(t, logging.Setup(&buf, "info", "text"))
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+	})
+	return &buf
+}
+
+func TestConfigService_Reload(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		locations string
+		err       string
+		// replaced is whether the config was replaced
+		replaced bool
+		// port and names are expected after reloading
+		port  int64
+		names []string
+	}{
+		{
+			name:      "changed",
+			config:    reloadConfig + "  cert_file: cert.pem\nprecip_probability: 0.3\n",
+			locations: "- name: Cabin\n  latitude: \"40\"\n  longitude: \"-78\"\n",
+			replaced:  true,
+			port:      8080,
+			names:     []string{"Cabin"},
+		},
+		{
+			name:      "invalid config",
+			config:    "sources:\n  enabled: [nws]\nserver:\n  port: 9090\nprecip_probability: 2\n",
+			locations: apiLocations,
+			err:       "precip_probability must be between 0 and 1",
+			port:      8080,
+			names:     []string{"Home", "Work"},
+		},
+		{
+			name:      "unparseable config",
+			config:    "server: [",
+			locations: apiLocations,
+			err:       "error loading config",
+			port:      8080,
+			names:     []string{"Home", "Work"},
+		},
+		{
+			name:      "invalid location",
+			config:    reloadConfig,
+			locations: apiLocations + "- name: Cabin\n  latitude: \"100\"\n  longitude: \"-78\"\n",
+			replaced:  true,
+			port:      8080,
+			names:     []string{"Home", "Work"},
+		},
+		{
+			name:      "unparseable locations",
+			config:    "sources:\n  enabled: [nws]\nserver:\n  port: 9090\n",
+			locations: "- name: [",
+			err:       "error loading locations",
+			replaced:  true,
+			port:      9090,
+			names:     []string{"Home", "Work"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newReloadConfigService(t)
+			require.NoError(t, os.WriteFile(c.configFile, []byte(tt.config), 0644))
+			require.NoError(t, os.WriteFile(c.locationsFile, []byte(tt.locations), 0644))
+			old, err := c.Reload(true)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.replaced {
+				require.NotNil(t, old)
+				assert.Equal(t, int64(8080), old.ServerConfig.Port)
+			} else {
+				assert.Nil(t, old)
+			}
+			assert.Equal(t, tt.port, c.GetConfig().ServerConfig.Port)
+			var names []string
+			for _, l := range c.GetLocations() {
+				names = append(names, l.Name)
+			}
+			assert.Equal(t, tt.names, names)
+		})
+	}
+}
+
+func TestConfigService_ReloadUnchanged(t *testing.T) {
+	c := newReloadConfigService(t)
+	old, err := c.Reload(false)
+	assert.NoError(t, err)
+	assert.Nil(t, old)
+	// files are reloaded when their size changes, even without force
+	require.NoError(t, os.WriteFile(c.configFile, []byte(reloadConfig+"precip_probability: 0.3\n"), 0644))
+	old, err = c.Reload(false)
+	assert.NoError(t, err)
+	require.NotNil(t, old)
+	assert.Equal(t, 0.3, c.GetConfig().PrecipProbability)
+}
+
+func TestReloader_Apply(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		rebuilt bool
+		restart bool
+	}{
+		{
+			name: "enabled sources",
+			change: func(c *Config) {
+				c.Sources.Enabled = []string{"metno", "nws"}
+			},
+			rebuilt: true,
+		},
+		{
+			name: "source option",
+			change: func(c *Config) {
+				c.Sources.METNorway.UserAgent = "example.com"
+			},
+			rebuilt: true,
+		},
+		{
+			name: "http cache",
+			change: func(c *Config) {
+				c.HttpCacheDir = t.TempDir()
+			},
+			rebuilt: true,
+		},
+		{
+			name: "schedules and budgets",
+			change: func(c *Config) {
+				c.Sources.Schedules = map[string]string{"nws": "0 * * * *"}
+				c.Sources.Budgets = map[string]BudgetConfig{"nws": {Daily: 10}}
+			},
+		},
+		{
+			name: "log level",
+			change: func(c *Config) {
+				c.Log.Level = "info"
+			},
+		},
+		{
+			name: "port",
+			change: func(c *Config) {
+				c.ServerConfig.Port = 9090
+			},
+			restart: true,
+		},
+		{
+			name: "port and sources",
+			change: func(c *Config) {
+				c.ServerConfig.Port = 9090
+				c.Sources.Enabled = []string{"metno"}
+			},
+			rebuilt: true,
+			restart: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			unchanged := forecasterFunc(func(context.Context, string, string) (*source.Forecast, error) {
+				return nil, nil
+			})
+			r := Reloader{
+				ConfigService: &ConfigService{},
+				Forecasters:   NewForecasters(map[string]source.Forecaster{"unchanged": unchanged}),
+			}
+			var old Config
+			old.Sources.Enabled = []string{"nws"}
+			old.HttpCacheDir = t.TempDir()
+			config := old
+			tt.change(&config)
+			r.apply(old, config)
+			if tt.rebuilt {
+				assert.Equal(t, config.Sources.Enabled, r.Forecasters.Names())
+			} else {
+				assert.Equal(t, []string{"unchanged"}, r.Forecasters.Names())
+			}
+			assert.Equal(t, tt.restart, bytes.Contains(logs.Bytes(), []byte("Restart ForecastMetrics")), logs.String())
+		})
+	}
+}
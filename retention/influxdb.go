@@ -0,0 +1,56 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// InfluxDB deletes forecasts using the InfluxDB 2.x (or 1.8+ compatibility) query and delete apis.
+type InfluxDB struct {
+	QueryApi    api.QueryAPI
+	DeleteApi   api.DeleteAPI
+	Org         string
+	Bucket      string
+	Measurement string
+	// Client is the client the apis belong to, closed by Close if not nil.
+	Client influxdb2.Client
+}
+
+// Close closes the influx client.
+func (i InfluxDB) Close() error {
+	if i.Client != nil {
+		i.Client.Close()
+	}
+	return nil
+}
+
+// ForecastTimes implements Cleaner by querying the forecast_time tag values with flux.
+func (i InfluxDB) ForecastTimes(ctx context.Context) ([]string, error) {
+	query := fmt.Sprintf(`import "influxdata/influxdb/schema"
+schema.measurementTagValues(bucket: %q, measurement: %q, tag: "forecast_time")`, i.Bucket, i.Measurement)
+	result, err := i.QueryApi.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = result.Close()
+	}()
+	var values []string
+	for result.Next() {
+		if v, ok := result.Record().Value().(string); ok {
+			values = append(values, v)
+		}
+	}
+	return values, result.Err()
+}
+
+// Delete implements Cleaner with the delete api. Forecasts extend into the future, so the
+// delete range does too.
+func (i InfluxDB) Delete(ctx context.Context, forecastTime string) error {
+	predicate := fmt.Sprintf(`_measurement="%s" AND forecast_time="%s"`, i.Measurement, forecastTime)
+	return i.DeleteApi.DeleteWithName(ctx, i.Org, i.Bucket, time.Unix(0, 0), time.Now().AddDate(1, 0, 0), predicate)
+}
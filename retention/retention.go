@@ -0,0 +1,119 @@
+// Package retention deletes old forecast series, which otherwise grow without bound when
+// every hourly forecast is written with a new forecast_time tag.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// PastForecastTime is the forecast_time of the series holding past data, which is never deleted.
+const PastForecastTime = "0"
+
+// Cleaner can list and delete forecast series in a database by their forecast_time tag.
+type Cleaner interface {
+	// ForecastTimes returns all forecast_time tag values in the database.
+	ForecastTimes(ctx context.Context) ([]string, error)
+	// Delete deletes all forecast series with the given forecast_time.
+	Delete(ctx context.Context, forecastTime string) error
+}
+
+// Policy decides which forecasts to delete.
+type Policy struct {
+	// MaxAge deletes forecasts made longer ago than this. Zero keeps forecasts forever.
+	MaxAge time.Duration
+	// DownsampleAge keeps only one forecast per day, made at DownsampleHour, once forecasts
+	// are older than this. Zero disables downsampling.
+	DownsampleAge  time.Duration
+	DownsampleHour int
+	// TimeFormat is the format of forecast_time values, which are in local time.
+	TimeFormat string
+}
+
+// Expired returns the forecast times that should be deleted, in order.
+// The past data series and values that can't be parsed are always kept.
+func (p Policy) Expired(forecastTimes []string, now time.Time) []string {
+	var expired []string
+	for _, ft := range forecastTimes {
+		if ft == PastForecastTime {
+			continue
+		}
+		t, err := time.ParseInLocation(p.TimeFormat, ft, time.Local)
+		if err != nil {
+			continue
+		}
+		age := now.Sub(t)
+		if p.MaxAge > 0 && age > p.MaxAge {
+			expired = append(expired, ft)
+		} else if p.DownsampleAge > 0 && age > p.DownsampleAge && t.Hour() != p.DownsampleHour {
+			expired = append(expired, ft)
+		}
+	}
+	slices.Sort(expired)
+	return expired
+}
+
+// Retention periodically applies a Policy to databases.
+type Retention struct {
+	Policy
+	// Cleaners are the databases to clean up, by name.
+	Cleaners map[string]Cleaner
+	// DryRun only logs what would be deleted.
+	DryRun bool
+}
+
+// Run deletes expired forecasts from every database, continuing with the other databases if one fails.
+func (r Retention) Run(ctx context.Context) {
+	for name, cleaner := range r.Cleaners {
+		deleted, err := r.clean(ctx, cleaner)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error cleaning up old forecasts", "database", name, "error", err)
+		}
+		if len(deleted) == 0 {
+			continue
+		}
+		if r.DryRun {
+			slog.InfoContext(ctx, "Dry run: would delete forecasts", "database", name, "count", len(deleted),
+				"forecast_times", deleted)
+		} else {
+			slog.InfoContext(ctx, "Deleted forecasts", "database", name, "count", len(deleted),
+				"forecast_times", deleted)
+		}
+	}
+}
+
+// Close closes the cleaners that implement io.Closer, returning their errors together.
+func (r Retention) Close() error {
+	var errs []error
+	for name, cleaner := range r.Cleaners {
+		if c, ok := cleaner.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("database %s: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// clean deletes expired forecasts from a single database, returning the forecast times deleted.
+func (r Retention) clean(ctx context.Context, cleaner Cleaner) ([]string, error) {
+	forecastTimes, err := cleaner.ForecastTimes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	expired := r.Expired(forecastTimes, time.Now())
+	if r.DryRun {
+		return expired, nil
+	}
+	for i, ft := range expired {
+		if err := cleaner.Delete(ctx, ft); err != nil {
+			return expired[:i], fmt.Errorf("failed to delete forecast_time %s: %w", ft, err)
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,129 @@
+package retention
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const timeFormat = "2006-01-02:15"
+
+func TestPolicy_Expired(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 30, 0, 0, time.Local)
+	forecastTimes := []string{
+		"0",
+		"garbage",
+		"2024-06-10:12",
+		"2024-06-09:13",
+		"2024-06-08:00",
+		"2024-06-08:07",
+		"2024-06-01:00",
+		"2024-05-31:23",
+		"2024-05-01:00",
+	}
+	tests := []struct {
+		name   string
+		policy Policy
+		want   []string
+	}{
+		{
+			name:   "max age",
+			policy: Policy{MaxAge: 216 * time.Hour, TimeFormat: timeFormat},
+			want:   []string{"2024-05-01:00", "2024-05-31:23", "2024-06-01:00"},
+		},
+		{
+			name:   "downsample",
+			policy: Policy{DownsampleAge: 24 * time.Hour, DownsampleHour: 0, TimeFormat: timeFormat},
+			want:   []string{"2024-05-31:23", "2024-06-08:07"},
+		},
+		{
+			name: "max age and downsample",
+			policy: Policy{MaxAge: 216 * time.Hour, DownsampleAge: 24 * time.Hour, DownsampleHour: 7,
+				TimeFormat: timeFormat},
+			want: []string{"2024-05-01:00", "2024-05-31:23", "2024-06-01:00", "2024-06-08:00"},
+		},
+		{
+			name:   "disabled",
+			policy: Policy{TimeFormat: timeFormat},
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Expired(forecastTimes, now))
+		})
+	}
+}
+
+// fakeCleaner is an in-memory Cleaner.
+type fakeCleaner struct {
+	forecastTimes []string
+	deleted       []string
+}
+
+func (f *fakeCleaner) ForecastTimes(context.Context) ([]string, error) {
+	return f.forecastTimes, nil
+}
+
+func (f *fakeCleaner) Delete(_ context.Context, forecastTime string) error {
+	f.deleted = append(f.deleted, forecastTime)
+	return nil
+}
+
+func TestRetention_Run(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour).Format(timeFormat)
+	recent := time.Now().Format(timeFormat)
+	policy := Policy{MaxAge: 24 * time.Hour, TimeFormat: timeFormat}
+
+	dry := &fakeCleaner{forecastTimes: []string{"0", old, recent}}
+	Retention{Policy: policy, Cleaners: map[string]Cleaner{"db": dry}, DryRun: true}.Run(context.Background())
+	assert.Empty(t, dry.deleted)
+
+	wet := &fakeCleaner{forecastTimes: []string{"0", old, recent}}
+	Retention{Policy: policy, Cleaners: map[string]Cleaner{"db": wet}}.Run(context.Background())
+	assert.Equal(t, []string{old}, wet.deleted)
+}
+
+func TestVictoriaMetrics(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "pass", pass)
+		switch r.URL.Path {
+		case "/api/v1/label/forecast_time/values":
+			assert.Equal(t, `{__name__=~"forecast_.*"}`, r.URL.Query().Get("match[]"))
+			_, _ = w.Write([]byte(`{"status":"success","data":["0","2024-06-01:00"]}`))
+		case "/api/v1/admin/tsdb/delete_series":
+			assert.Equal(t, "POST", r.Method)
+			deleted = append(deleted, r.URL.Query().Get("match[]"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	vm := VictoriaMetrics{
+		Url:          server.URL,
+		Username:     "user",
+		Password:     "pass",
+		MetricPrefix: "forecast",
+	}
+
+	forecastTimes, err := vm.ForecastTimes(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "2024-06-01:00"}, forecastTimes)
+
+	err = vm.Delete(context.Background(), "2024-06-01:00")
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{__name__=~"forecast_.*",forecast_time="2024-06-01:00"}`}, deleted)
+
+	vm.Url = server.URL + "/missing"
+	_, err = vm.ForecastTimes(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,94 @@
+package retention
+
+// https://docs.victoriametrics.com/url-examples/#apiv1admintsdbdelete_series
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// VictoriaMetrics deletes forecasts using the VictoriaMetrics (or Prometheus, with the admin api enabled)
+// label values and delete_series apis.
+type VictoriaMetrics struct {
+	// Url is the base url, e.g. http://localhost:8428
+	Url    string
+	Client *http.Client
+	// Username and Password are used for basic auth, if set.
+	Username string
+	Password string
+	// MetricPrefix limits deletion to metrics of the forecast measurement, e.g. "forecast".
+	MetricPrefix string
+}
+
+// ForecastTimes implements Cleaner using the label values api.
+func (v VictoriaMetrics) ForecastTimes(ctx context.Context) ([]string, error) {
+	q := url.Values{}
+	q.Add("match[]", v.selector(""))
+	body, err := v.do(ctx, "GET", "/api/v1/label/forecast_time/values?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Status string   `json:"status"`
+		Data   []string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("label values query failed: %s", string(body))
+	}
+	return resp.Data, nil
+}
+
+// Delete implements Cleaner using the delete_series api.
+func (v VictoriaMetrics) Delete(ctx context.Context, forecastTime string) error {
+	q := url.Values{}
+	q.Add("match[]", v.selector(forecastTime))
+	_, err := v.do(ctx, "POST", "/api/v1/admin/tsdb/delete_series?"+q.Encode())
+	return err
+}
+
+// selector returns a series selector for forecast metrics, with a forecast_time if not blank.
+func (v VictoriaMetrics) selector(forecastTime string) string {
+	matchers := []string{fmt.Sprintf(`__name__=~"%s_.*"`, v.MetricPrefix)}
+	if forecastTime != "" {
+		matchers = append(matchers, fmt.Sprintf(`forecast_time=%q`, forecastTime))
+	}
+	return "{" + strings.Join(matchers, ",") + "}"
+}
+
+// do makes a request, returning the body if successful.
+func (v VictoriaMetrics) do(ctx context.Context, method string, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(v.Url, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if v.Username != "" || v.Password != "" {
+		req.SetBasicAuth(v.Username, v.Password)
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s failed: %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
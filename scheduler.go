@@ -2,53 +2,168 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/stephenafamo/kronika"
 
+	"github.com/tedpearson/ForecastMetrics/v3/internal/cron"
+	"github.com/tedpearson/ForecastMetrics/v3/retention"
 	"github.com/tedpearson/ForecastMetrics/v3/source"
 )
 
+// defaultSchedule is the cron schedule of sources without one in the config.
+const defaultSchedule = "@hourly"
+
 // Scheduler runs regular exports of forecast metrics to the database.
 type Scheduler struct {
 	ConfigService *ConfigService
 	MetricUpdater MetricUpdater
-	Forecasters   map[string]source.Forecaster
+	Forecasters   *Forecasters
+	// Retention deletes old forecasts after each hourly export, if not nil.
+	Retention *retention.Retention
+	// Budget limits the forecasts requested from each source per day, if not nil.
+	Budget *Budget
+	// Pool fetches forecasts concurrently.
+	Pool *FetchPool
+	// Jitter is the longest random delay before each scheduled fetch.
+	Jitter time.Duration
 }
 
-// Start starts the goroutine to run regular exports.
-func (s Scheduler) Start() {
-	go s.run()
+// Start starts the goroutine to run regular exports, until ctx is done.
+// Fetches that have already started keep running in the Pool.
+func (s Scheduler) Start(ctx context.Context) {
+	go s.run(ctx)
 }
 
-// run loops and calls updateForecasts at the top of each hour.
-func (s Scheduler) run() {
-	firstRun := time.Now().Truncate(time.Hour)
-	for range kronika.Every(context.Background(), firstRun, time.Hour) {
-		s.updateForecasts()
+// run loops every minute and calls updateForecasts, then cleans up old forecasts at the top of each hour.
+func (s Scheduler) run(ctx context.Context) {
+	firstRun := time.Now().Truncate(time.Minute)
+	for t := range kronika.Every(ctx, firstRun, time.Minute) {
+		t = t.Truncate(time.Minute)
+		s.updateForecasts(t)
+		if s.Retention != nil && t.Minute() == 0 {
+			s.Retention.Run(ctx)
+		}
 	}
 }
 
-// updateForecasts calls UpdateForecast for every currently exported location.
-func (s Scheduler) updateForecasts() {
+// updateForecasts starts fetching forecasts from the sources whose schedule matches t, for every currently
+// exported location that is due this hour. Fetches that are still running from a previous run are skipped.
+// The time until every fetch started is done is recorded as the cycle duration.
+func (s Scheduler) updateForecasts(t time.Time) {
+	schedules := s.ConfigService.GetConfig().Sources.Schedules
+	var sources []string
+	for src := range s.Forecasters.All() {
+		spec, ok := schedules[src]
+		if !ok {
+			spec = defaultSchedule
+		}
+		// schedules are validated when the config is loaded
+		schedule, err := cron.Parse(spec)
+		if err == nil && schedule.Matches(t) {
+			sources = append(sources, src)
+		}
+	}
+	if len(sources) == 0 {
+		return
+	}
 	// get latest config from config svc
 	locations := s.ConfigService.GetLocations()
+	start := time.Now()
+	var cycle []*sync.WaitGroup
 	// loop through source, locations. call forecast service, metric service.
 	for _, location := range locations {
-		s.UpdateForecast(location)
+		if !isDue(location, t) {
+			continue
+		}
+		cycle = append(cycle, s.updateSources(location, sources, s.Jitter))
+	}
+	if len(cycle) == 0 {
+		return
 	}
+	go func() {
+		for _, wg := range cycle {
+			wg.Wait()
+		}
+		schedulerCycleDuration.Observe(time.Since(start).Seconds())
+	}()
+}
+
+// isDue returns whether a location should be updated in the hour containing t, according to its interval.
+// Intervals are counted from midnight in the location's time zone, so a 24h interval runs at local midnight.
+func isDue(location Location, t time.Time) bool {
+	_, offset := t.In(location.TimeZone()).Zone()
+	hours := (t.Unix() + int64(offset)) / 3600
+	return hours%int64(location.UpdateInterval()/time.Hour) == 0
 }
 
-// UpdateForecast gets the forecast and writes the metrics to the database for every enabled Forecaster.
+// UpdateForecast gets the forecast and writes the metrics to the database for every enabled Forecaster
+// the location uses, returning once they are done.
 func (s Scheduler) UpdateForecast(location Location) {
-	for src, forecaster := range s.Forecasters {
-		fmt.Printf("Getting scheduled forecast for %s from %T\n", location.Name, forecaster)
-		forecast, err := forecaster.GetForecast(location.Latitude, location.Longitude)
-		if err != nil {
-			fmt.Printf("Failed to get forecast for %+v from %s: %v\n", location, src, err)
+	s.updateSources(location, slices.Collect(maps.Keys(s.Forecasters.All())), 0).Wait()
+}
+
+// updateSources starts fetching the forecast and writing the metrics to the database for each of sources
+// that the location uses, after a random delay up to jitter. The returned WaitGroup is done when they finish.
+func (s Scheduler) updateSources(location Location, sources []string, jitter time.Duration) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		forecaster, ok := s.Forecasters.Get(src)
+		if !ok || !location.UsesSource(src) {
 			continue
 		}
-		s.MetricUpdater.WriteMetrics(*forecast, location.Name, src)
+		key := CacheKey{Name: location.Name, Latitude: location.Latitude, Longitude: location.Longitude, Source: src}
+		var delay time.Duration
+		if jitter > 0 {
+			delay = rand.N(jitter)
+		}
+		wg.Add(1)
+		started := s.Pool.Go(key, delay, func(ctx context.Context) {
+			s.updateSource(ctx, location, src, forecaster)
+		}, wg.Done)
+		if !started {
+			wg.Done()
+			slog.Warn("Skipping scheduled forecast, the previous one is still running", "location", location.Name,
+				"source", src)
+		}
+	}
+	return &wg
+}
+
+// updateSource gets the forecast for a location from a single source and writes the metrics to the database.
+// It is skipped if the source's daily budget is used up.
+func (s Scheduler) updateSource(ctx context.Context, location Location, src string, forecaster source.Forecaster) {
+	if s.Budget != nil && !s.Budget.Take(src, false) {
+		slog.Warn("Skipping scheduled forecast", "location", location.Name, "source", src, "error", ErrBudgetExhausted)
+		return
+	}
+	slog.Info("Getting scheduled forecast", "location", location.Name, "source", src)
+	forecast, err := fetchForecast(ctx, forecaster, location, src, false)
+	if err != nil {
+		slog.Error("Failed to get forecast", "location", location.Name, "source", src, "error", err)
+		return
+	}
+	AddAstronomy(forecast, location)
+	s.MetricUpdater.WriteMetrics(ctx, *forecast, location, src)
+}
+
+// AddAstronomy computes astronomy forecasts for the location if the forecaster didn't return any,
+// covering the same period as the weather forecast.
+func AddAstronomy(forecast *source.Forecast, location Location) {
+	records := forecast.WeatherRecords
+	if len(forecast.AstroEvents) > 0 || len(records) == 0 {
+		return
+	}
+	events, err := source.ComputeAstroEvents(location.Latitude, location.Longitude,
+		records[0].Time, records[len(records)-1].Time)
+	if err != nil {
+		slog.Error("Failed to compute astronomy", "location", location.Name, "error", err)
+		return
 	}
+	forecast.AstroEvents = events
 }
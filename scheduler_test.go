@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsDue(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	tests := []struct {
+		name     string
+		location Location
+		time     time.Time
+		expected bool
+	}{
+		{
+			name:     "hourly by default",
+			location: Location{Timezone: "UTC"},
+			time:     time.Date(2024, 6, 1, 7, 30, 0, 0, time.UTC),
+			expected: true,
+		},
+		{
+			name:     "invalid interval is hourly",
+			location: Location{Interval: "20m", Timezone: "UTC"},
+			time:     time.Date(2024, 6, 1, 7, 0, 0, 0, time.UTC),
+			expected: true,
+		},
+		{
+			name:     "every 3 hours from midnight",
+			location: Location{Interval: "3h", Timezone: "UTC"},
+			time:     time.Date(2024, 6, 1, 9, 15, 0, 0, time.UTC),
+			expected: true,
+		},
+		{
+			name:     "not due between intervals",
+			location: Location{Interval: "3h", Timezone: "UTC"},
+			time:     time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+			expected: false,
+		},
+		{
+			name:     "daily at local midnight",
+			location: Location{Interval: "24h", Timezone: "America/New_York"},
+			time:     time.Date(2024, 6, 1, 0, 0, 0, 0, newYork),
+			expected: true,
+		},
+		{
+			name:     "not at UTC midnight",
+			location: Location{Interval: "24h", Timezone: "America/New_York"},
+			time:     time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			expected: false,
+		},
+		{
+			name:     "local midnight in winter",
+			location: Location{Interval: "24h", Timezone: "America/New_York"},
+			time:     time.Date(2024, 12, 1, 0, 0, 0, 0, newYork),
+			expected: true,
+		},
+		{
+			name:     "6 hours from local midnight",
+			location: Location{Interval: "6h", Timezone: "America/New_York"},
+			time:     time.Date(2024, 6, 1, 18, 0, 0, 0, newYork),
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isDue(tt.location, tt.time))
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/logging"
+	"github.com/tedpearson/ForecastMetrics/v3/internal/selfmetrics"
+	"github.com/tedpearson/ForecastMetrics/v3/output"
+	"github.com/tedpearson/ForecastMetrics/v3/source"
+)
+
+// selfMetrics holds the metrics below, which are reported on /metrics.
+var selfMetrics selfmetrics.Registry
+
+var (
+	forecastFetches = selfMetrics.NewCounter("forecastmetrics_forecast_fetches_total",
+		"Forecasts fetched from each source, by result. Ad-hoc fetches have an empty location.",
+		"source", "location", "type", "result")
+	forecastFetchDuration = selfMetrics.NewHistogram("forecastmetrics_forecast_fetch_duration_seconds",
+		"Time taken to fetch forecasts from each source.", selfmetrics.DefaultBuckets, "source", "type")
+	httpRetries = selfMetrics.NewCounter("forecastmetrics_http_retries_total",
+		"Requests to each source retried after an error.", "source")
+	dispatcherCacheHits = selfMetrics.NewCounter("forecastmetrics_dispatcher_cache_hits_total",
+		"Ad-hoc forecasts answered from the cache.", "source")
+	dispatcherCacheMisses = selfMetrics.NewCounter("forecastmetrics_dispatcher_cache_misses_total",
+		"Ad-hoc forecasts not found in the cache.", "source")
+	dispatcherFetches = selfMetrics.NewGauge("forecastmetrics_dispatcher_fetches_in_flight",
+		"Ad-hoc forecasts being fetched.")
+	dispatcherWaiting = selfMetrics.NewGauge("forecastmetrics_dispatcher_requests_waiting",
+		"Ad-hoc requests waiting for a forecast being fetched.")
+	geocodeCacheHits = selfMetrics.NewCounter("forecastmetrics_geocode_cache_hits_total",
+		"Locations in queries found in the cache.")
+	geocodeCacheMisses = selfMetrics.NewCounter("forecastmetrics_geocode_cache_misses_total",
+		"Locations in queries that had to be parsed or looked up.")
+	pointsWritten = selfMetrics.NewCounter("forecastmetrics_points_written_total",
+		"Points written to the outputs.", "measurement")
+	pointWriteFailures = selfMetrics.NewCounter("forecastmetrics_point_write_failures_total",
+		"Points that failed to be written to the outputs.", "measurement")
+	spoolBacklogBatches = selfMetrics.NewGauge("forecastmetrics_spool_backlog_batches",
+		"Batches of points spooled for each output, waiting to be replayed.", "output")
+	spoolBacklogBytes = selfMetrics.NewGauge("forecastmetrics_spool_backlog_bytes",
+		"Size of the batches spooled for each output.", "output")
+	schedulerCycleDuration = selfMetrics.NewHistogram("forecastmetrics_scheduler_cycle_duration_seconds",
+		"Time taken to fetch and write every scheduled forecast due at once.",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1800})
+)
+
+// fetchForecast gets the forecast for a location from a forecaster, recording how long it took and
+// whether it failed. Ad-hoc fetches aren't labeled with the location, since queries may ask for any place.
+func fetchForecast(ctx context.Context, forecaster source.Forecaster, location Location, src string,
+	adHoc bool) (*source.Forecast, error) {
+	kind, name := "scheduled", location.Name
+	if adHoc {
+		kind, name = "adhoc", ""
+	}
+	start := time.Now()
+	forecast, err := forecaster.GetForecast(ctx, location.Latitude, location.Longitude)
+	result := "success"
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.Canceled):
+		// nobody is waiting for the forecast anymore
+		result = "cancelled"
+	case err != nil:
+		result = "error"
+	}
+	forecastFetches.Inc(src, name, kind, result)
+	forecastFetchDuration.Observe(time.Since(start).Seconds(), src, kind)
+	return forecast, err
+}
+
+// SelfMetricsHandler serves metrics about ForecastMetrics itself in the prometheus text format.
+type SelfMetricsHandler struct {
+	Budget  *Budget
+	Outputs *output.Fanout
+}
+
+// ServeHTTP implements http.Handler.
+func (h SelfMetricsHandler) ServeHTTP(resp http.ResponseWriter, _ *http.Request) {
+	var b strings.Builder
+	// writing to a strings.Builder can't fail
+	_ = selfMetrics.WriteText(&b)
+	if h.Budget != nil {
+		remaining, limits := h.Budget.Remaining()
+		sources := slices.Sorted(maps.Keys(remaining))
+		writeGauge(&b, "forecastmetrics_budget_remaining",
+			"Forecasts that can still be requested from the source today (UTC).", sources,
+			func(src string) int { return remaining[src] })
+		writeGauge(&b, "forecastmetrics_budget_daily", "Forecasts that can be requested from the source per day.",
+			sources, func(src string) int { return limits[src].Daily })
+		writeGauge(&b, "forecastmetrics_budget_reserved",
+			"Forecasts per day that only scheduled locations may request from the source.", sources,
+			func(src string) int { return limits[src].Reserved })
+	}
+	if h.Outputs != nil {
+		_ = writeOutputStatus(&b, h.Outputs.Status())
+	}
+	resp.Header().Set("content-type", "text/plain; version=0.0.4; charset=utf-8")
+	_, err := resp.Write([]byte(b.String()))
+	if err != nil {
+		slog.Warn("Error writing response to client", "error", err)
+	}
+}
+
+// writeOutputStatus writes whether each output is up, and when it last succeeded and failed, along with the
+// last error. A new registry is used each time so that errors that are no longer the last aren't reported.
+func writeOutputStatus(w io.Writer, status map[string]output.Status) error {
+	var r selfmetrics.Registry
+	up := r.NewGauge("forecastmetrics_output_up", "Whether the last write to the output succeeded.", "output")
+	writes := r.NewCounter("forecastmetrics_output_writes_total", "Writes to the output, by result.",
+		"output", "result")
+	lastSuccess := r.NewGauge("forecastmetrics_output_last_success_timestamp_seconds",
+		"When the last successful write to the output finished.", "output")
+	lastFailure := r.NewGauge("forecastmetrics_output_last_failure_timestamp_seconds",
+		"When the last failed write to the output finished.", "output")
+	lastError := r.NewGauge("forecastmetrics_output_last_error_info",
+		"The error of the last failed write to the output.", "output", "error")
+	for name, s := range status {
+		if s.LastSuccess.After(s.LastFailure) {
+			up.Set(1, name)
+		} else {
+			up.Set(0, name)
+		}
+		writes.Add(float64(s.Successes), name, "success")
+		writes.Add(float64(s.Failures), name, "error")
+		if !s.LastSuccess.IsZero() {
+			lastSuccess.Set(float64(s.LastSuccess.Unix()), name)
+		}
+		if s.LastError != nil {
+			lastFailure.Set(float64(s.LastFailure.Unix()), name)
+			lastError.Set(1, name, logging.Redact(s.LastError.Error()))
+		}
+	}
+	return r.WriteText(w)
+}
+
+// writeGauge writes a gauge with a value for each source.
+func writeGauge(b *strings.Builder, name, help string, sources []string, value func(src string) int) {
+	if len(sources) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, src := range sources {
+		fmt.Fprintf(b, "%s{source=%q} %d\n", name, src, value(src))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tedpearson/ForecastMetrics/v3/output"
+)
+
+func TestWriteOutputStatus(t *testing.T) {
+	ok := time.Unix(1700000000, 0)
+	failed := ok.Add(time.Minute)
+	var b strings.Builder
+	require.NoError(t, writeOutputStatus(&b, map[string]output.Status{
+		"influx": {Successes: 3, LastSuccess: ok},
+		"victoria": {Successes: 1, Failures: 2, LastSuccess: ok, LastFailure: failed,
+			LastError: errors.New("connection refused")},
+	}))
+	expected := `# HELP forecastmetrics_output_up Whether the last write to the output succeeded.
+# TYPE forecastmetrics_output_up gauge
+forecastmetrics_output_up{output="influx"} 1
+forecastmetrics_output_up{output="victoria"} 0
+# HELP forecastmetrics_output_writes_total Writes to the output, by result.
+# TYPE forecastmetrics_output_writes_total counter
+forecastmetrics_output_writes_total{output="influx",result="error"} 0
+forecastmetrics_output_writes_total{output="influx",result="success"} 3
+forecastmetrics_output_writes_total{output="victoria",result="error"} 2
+forecastmetrics_output_writes_total{output="victoria",result="success"} 1
+# HELP forecastmetrics_output_last_success_timestamp_seconds When the last successful write to the output finished.
+# TYPE forecastmetrics_output_last_success_timestamp_seconds gauge
+forecastmetrics_output_last_success_timestamp_seconds{output="influx"} 1.7e+09
+forecastmetrics_output_last_success_timestamp_seconds{output="victoria"} 1.7e+09
+# HELP forecastmetrics_output_last_failure_timestamp_seconds When the last failed write to the output finished.
+# TYPE forecastmetrics_output_last_failure_timestamp_seconds gauge
+forecastmetrics_output_last_failure_timestamp_seconds{output="victoria"} 1.70000006e+09
+# HELP forecastmetrics_output_last_error_info The error of the last failed write to the output.
+# TYPE forecastmetrics_output_last_error_info gauge
+forecastmetrics_output_last_error_info{output="victoria",error="connection refused"} 1
+`
+	assert.Equal(t, expected, b.String())
+}
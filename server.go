@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
-	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/logging"
+	"github.com/tedpearson/ForecastMetrics/v3/internal/promql"
+	"github.com/tedpearson/ForecastMetrics/v3/output"
+	"github.com/tedpearson/ForecastMetrics/v3/proxy"
 )
 
 // Server provides the promethus endpoint for ForecastMetrics.
@@ -22,12 +31,25 @@ type Server struct {
 	PromConverter      PromConverter
 	AuthToken          string
 	AllowedMetricNames []string
+	// ConfigService and Forecasters list the scheduled locations and enabled sources for autocompletion.
+	ConfigService *ConfigService
+	Forecasters   *Forecasters
+	// PrometheusProxy or InfluxProxy, if set, answer queries for scheduled locations from the database.
+	PrometheusProxy *proxy.Prometheus
+	InfluxProxy     promql.Querier
+	// LocationsApi manages scheduled locations, if not nil.
+	LocationsApi *LocationsApi
+	// Budget and Outputs are reported on /metrics, if not nil.
+	Budget  *Budget
+	Outputs *output.Fanout
 }
 
-// Start starts the prometheus endpoint.
-func (s *Server) Start(config ServerConfig) {
+// Start starts the prometheus endpoint in the background, returning the http.Server so it can be shut down.
+// It panics if the server fails, other than by being shut down.
+func (s *Server) Start(config ServerConfig) *http.Server {
 	server := &http.Server{
-		Addr: fmt.Sprintf(":%d", config.Port),
+		Addr:    fmt.Sprintf(":%d", config.Port),
+		Handler: withRequestID(http.DefaultServeMux),
 		TLSConfig: &tls.Config{
 			MinVersion: tls.VersionTLS13,
 			CurvePreferences: []tls.CurveID{
@@ -36,27 +58,47 @@ func (s *Server) Start(config ServerConfig) {
 			},
 		},
 	}
-	// don't 404 on other prometheus endpoints
-	http.HandleFunc("/api/v1/", func(writer http.ResponseWriter, request *http.Request) {
-		writer.WriteHeader(204)
-	})
+	http.Handle("/api/v1/", DiscoveryHandler{s})
+	http.Handle("/metrics", SelfMetricsHandler{Budget: s.Budget, Outputs: s.Outputs})
+	if s.LocationsApi != nil {
+		s.LocationsApi.Register(http.DefaultServeMux)
+	}
 	http.Handle("/api/v1/query_range", s)
-	if len(config.CertFile) > 0 && len(config.KeyFile) > 0 {
-		err := server.ListenAndServeTLS(config.CertFile, config.KeyFile)
-		if err != nil {
-			panic(err)
+	http.Handle("/api/v1/query", s)
+	// listen before returning, so that startup errors such as the port being in use panic right away
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		if len(config.CertFile) > 0 && len(config.KeyFile) > 0 {
+			err = server.ServeTLS(listener, config.CertFile, config.KeyFile)
+		} else {
+			err = server.Serve(listener)
 		}
-	} else {
-		err := server.ListenAndServe()
-		if err != nil {
+		if !errors.Is(err, http.ErrServerClosed) {
 			panic(err)
 		}
-	}
+	}()
+	return server
+}
+
+// withRequestID gives each request a request id, which is logged with everything done for the request
+// and returned in the X-Request-Id header. Clients and proxies may choose the id by sending the header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get("X-Request-Id")
+		if id == "" || len(id) > 64 {
+			id = logging.NewRequestID()
+		}
+		resp.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(resp, req.WithContext(logging.WithRequestID(req.Context(), id)))
+	})
 }
 
 // ServeHTTP implements http.Handler by serving prometheus metrics for specially formed
-// prometheus http requests. If a parsed location is already written to the database,
-// we proxy the prometheus request to the database.
+// prometheus http range and instant queries. If a parsed location is already written to the database,
+// and a proxy is configured, we proxy the prometheus request to the database.
 func (s *Server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	// handle auth
 	if !Auth(req.Header.Get("Authorization"), s.AuthToken) {
@@ -67,28 +109,62 @@ func (s *Server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	// get params
 	err := req.ParseForm()
 	if err != nil {
-		fmt.Printf("Failed to parse form: %s", err)
+		slog.WarnContext(req.Context(), "Failed to parse form", "error", err)
 		resp.WriteHeader(http.StatusBadRequest)
 		errorJson(err, resp)
 		return
 	}
-	params, err := s.ParseParams(req.Form)
+	instant := req.URL.Path == "/api/v1/query"
+	var params *Params
+	if instant {
+		params, err = s.ParseInstantParams(req.Context(), req.Form)
+	} else {
+		params, err = s.ParseParams(req.Context(), req.Form)
+	}
 	if err != nil {
-		fmt.Printf("Failed to parse params: %s: %+v\n", err, req.Form)
+		slog.WarnContext(req.Context(), "Failed to parse params", "error", err, "form", req.Form.Encode())
 		resp.WriteHeader(http.StatusBadRequest)
 		errorJson(err, resp)
 		return
 	}
 
-	forecast, err := s.Dispatcher.GetForecast(params.Location, params.Source, params.AdHoc)
-	if err != nil {
-		fmt.Printf("Error getting forecast: %+v\n", err)
-		resp.WriteHeader(http.StatusInternalServerError)
-		errorJson(err, resp)
+	if s.proxyQuery(req.Context(), params.Expr) {
+		s.serveProxy(resp, req, *params, instant)
 		return
 	}
-	// convert to prometheus response.
-	promResponse := s.PromConverter.ConvertToTimeSeries(*forecast, *params)
+	var promResponse PromResponse
+	if _, ok := params.Expr.(*promql.VectorSelector); ok {
+		forecasts, err := s.Dispatcher.GetForecasts(req.Context(), params.Location, params.Sources, params.AdHoc)
+		if len(forecasts) == 0 {
+			slog.ErrorContext(req.Context(), "Error getting forecast", "location", params.Location.Name, "error", err)
+			resp.WriteHeader(http.StatusInternalServerError)
+			errorJson(err, resp)
+			return
+		}
+		// convert to prometheus response.
+		if instant {
+			promResponse = s.PromConverter.ConvertToVector(forecasts, *params)
+		} else {
+			promResponse = s.PromConverter.ConvertToTimeSeries(forecasts, *params)
+		}
+		// return the sources that worked, with the others as warnings
+		if err != nil {
+			slog.WarnContext(req.Context(), "Error getting forecast from some sources",
+				"location", params.Location.Name, "error", err)
+		}
+		promResponse.Warnings = warnings(err)
+	} else {
+		// evaluate functions, operators and aggregations over the forecasts
+		series, warns, err := s.Evaluate(req.Context(), *params)
+		if err != nil {
+			slog.WarnContext(req.Context(), "Error evaluating query", "query", params.Expr.String(), "error", err)
+			resp.WriteHeader(http.StatusUnprocessableEntity)
+			errorJson(err, resp)
+			return
+		}
+		promResponse = SeriesToResponse(series, instant)
+		promResponse.Warnings = warns
+	}
 	// send prom response as json to client
 	resp.Header().Add("content-type", "application/json")
 	respJson, err := json.Marshal(promResponse)
@@ -99,22 +175,116 @@ func (s *Server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	}
 	_, err = resp.Write(respJson)
 	if err != nil {
-		fmt.Printf("Error writing response to client: %+v\n", err)
+		slog.WarnContext(req.Context(), "Error writing response to client", "error", err)
+	}
+}
+
+// proxyQuery returns true if a proxy is configured and every selector in the query is for a scheduled location
+// and a metric that is written to the database. If so, the selectors are rewritten to match the database:
+// the location is replaced by its name, and the save tag is removed.
+func (s *Server) proxyQuery(ctx context.Context, expr promql.Expr) bool {
+	if s.PrometheusProxy == nil && s.InfluxProxy == nil {
+		return false
+	}
+	selectors := promql.Selectors(expr)
+	names := make([]string, len(selectors))
+	scheduled := s.ConfigService.GetLocations()
+	for i, vs := range selectors {
+		// accumulated_precip is computed from the forecast and isn't in the database
+		if vs.Name == "accumulated_precip" {
+			return false
+		}
+		pq, err := s.ParseSelector(ctx, vs)
+		if err != nil {
+			return false
+		}
+		if !slices.ContainsFunc(scheduled, func(l Location) bool {
+			return l.Name == pq.Location.Name && sameCoordinates(l, pq.Location)
+		}) {
+			return false
+		}
+		names[i] = pq.Location.Name
+	}
+	for i, vs := range selectors {
+		vs.Matchers = slices.DeleteFunc(vs.Matchers, func(m *promql.Matcher) bool {
+			return m.Name == "save"
+		})
+		for j, m := range vs.Matchers {
+			if m.Name == "location" {
+				vs.Matchers[j], _ = promql.NewMatcher("location", promql.MatchEqual, names[i])
+			}
+		}
+	}
+	return true
+}
+
+// serveProxy answers a query for scheduled locations from the database.
+func (s *Server) serveProxy(resp http.ResponseWriter, req *http.Request, params Params, instant bool) {
+	slog.DebugContext(req.Context(), "Proxying query to the database", "query", params.Expr.String())
+	if s.PrometheusProxy != nil {
+		status, contentType, body, err := s.PrometheusProxy.Forward(req.Context(), req.URL.Path,
+			params.Expr.String(), req.Form)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error proxying query", "query", params.Expr.String(), "error", err)
+			resp.WriteHeader(http.StatusBadGateway)
+			errorJson(err, resp)
+			return
+		}
+		resp.Header().Set("content-type", contentType)
+		resp.WriteHeader(status)
+		_, err = resp.Write(body)
+		if err != nil {
+			slog.WarnContext(req.Context(), "Error writing response to client", "error", err)
+		}
+		return
+	}
+	series, err := s.engine().Query(req.Context(), s.InfluxProxy, params.Expr, params.Start, params.End, params.Step)
+	if err != nil {
+		slog.WarnContext(req.Context(), "Error evaluating query", "query", params.Expr.String(), "error", err)
+		resp.WriteHeader(http.StatusUnprocessableEntity)
+		errorJson(err, resp)
+		return
+	}
+	respJson, err := json.Marshal(SeriesToResponse(series, instant))
+	if err != nil {
+		resp.WriteHeader(http.StatusInternalServerError)
+		errorJson(err, resp)
+		return
+	}
+	resp.Header().Add("content-type", "application/json")
+	_, err = resp.Write(respJson)
+	if err != nil {
+		slog.WarnContext(req.Context(), "Error writing response to client", "error", err)
 	}
 }
 
+// warnings converts the errors of sources that failed to prometheus warnings.
+func warnings(err error) []string {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var warns []string
+		for _, e := range joined.Unwrap() {
+			warns = append(warns, e.Error())
+		}
+		return warns
+	}
+	return []string{err.Error()}
+}
+
 func errorJson(err error, resp http.ResponseWriter) {
 	respJson, err := json.Marshal(PromResponse{
 		Status: "error",
 		Error:  err.Error(),
 	})
 	if err != nil {
-		fmt.Printf("Error marshalling json: %s\n", err)
+		slog.Error("Error marshalling json", "error", err)
 		return
 	}
 	_, err = resp.Write(respJson)
 	if err != nil {
-		fmt.Printf("Error writing response to client: %+v\n", err)
+		slog.Warn("Error writing response to client", "error", err)
 	}
 }
 
@@ -125,7 +295,7 @@ func Auth(authHeader, authToken string) bool {
 		if err != nil {
 			return false
 		}
-		return string(b) == authToken
+		return subtle.ConstantTimeCompare(b, []byte(authToken)) == 1
 	}
 	return false
 }
@@ -134,8 +304,11 @@ func Auth(authHeader, authToken string) bool {
 type ParsedQuery struct {
 	Metric   string
 	Location Location
-	Source   string
-	AdHoc    bool
+	// Sources are the enabled sources matching the query's source tag.
+	Sources []string
+	AdHoc   bool
+	// Filters are matchers for any other tags in the query, used to select series such as hazards.
+	Filters []*promql.Matcher
 }
 
 // Params are the timestamps of the query range along with the query string information.
@@ -143,37 +316,63 @@ type Params struct {
 	Start int64
 	End   int64
 	Step  int64
+	// Expr is the parsed query. ParsedQuery is only set if it is a single vector selector.
+	Expr promql.Expr
 	ParsedQuery
 }
 
 // String implements Stringer by printing the parsed prometheus query string.
 func (p Params) String() string {
-	return fmt.Sprintf("Metric:%s Location:%s Source:%s Adhoc:%t\n", p.Metric, p.Location.Name, p.Source, p.AdHoc)
+	return fmt.Sprintf("Metric:%s Location:%s Sources:%v Adhoc:%t\n", p.Metric, p.Location.Name, p.Sources, p.AdHoc)
 }
 
-var queryRE = regexp.MustCompile(`^(\w+)\{(.+)}$`)
-var tagRE = regexp.MustCompile(`(\w+)="([^"]+)",?`)
-
-// ParseQuery parses the information in the prometheus query string.
-func (s *Server) ParseQuery(query string) (*ParsedQuery, error) {
-	matches := queryRE.FindStringSubmatch(query)
-	if len(matches) == 0 {
-		return nil, errors.New("no matches found")
+// ParseQuery parses the information in the prometheus query string, which must be a single
+// vector selector such as forecast_temperature{source="nws",location="place"}.
+func (s *Server) ParseQuery(ctx context.Context, query string) (*ParsedQuery, error) {
+	expr, err := promql.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	vs, ok := expr.(*promql.VectorSelector)
+	if !ok {
+		return nil, fmt.Errorf("not a vector selector: %s", query)
 	}
+	return s.ParseSelector(ctx, vs)
+}
+
+// ParseSelector gets the information needed to get a forecast from the labels of a vector selector.
+// Looking up the location gives up when ctx is done.
+func (s *Server) ParseSelector(ctx context.Context, vs *promql.VectorSelector) (*ParsedQuery, error) {
 	pq := &ParsedQuery{
-		Metric: matches[1],
+		Metric: vs.Name,
 	}
-	validMetric := slices.ContainsFunc(s.AllowedMetricNames, func(str string) bool {
+	validMetric := pq.Metric != "" && slices.ContainsFunc(s.AllowedMetricNames, func(str string) bool {
 		return strings.HasPrefix(pq.Metric, str)
 	})
 	if !validMetric {
 		return nil, fmt.Errorf("invalid metric name: %s", pq.Metric)
 	}
 
-	tagMatches := tagRE.FindAllStringSubmatch(matches[2], -1)
 	tags := make(map[string]string)
-	for _, tagMatch := range tagMatches {
-		tags[tagMatch[1]] = tagMatch[2]
+	for _, m := range vs.Matchers {
+		switch m.Name {
+		case "__name__":
+		case "location", "save":
+			if m.Type != promql.MatchEqual {
+				return nil, fmt.Errorf("only = is supported for tag %s", m.Name)
+			}
+			tags[m.Name] = m.Value
+		case "source":
+			// any matcher type selects sources, e.g. source=~"nws|metno"
+			pq.Sources = slices.DeleteFunc(s.Forecasters.Names(), func(src string) bool {
+				return !m.Matches(src) || (pq.Sources != nil && !slices.Contains(pq.Sources, src))
+			})
+			if len(pq.Sources) == 0 {
+				return nil, fmt.Errorf("no enabled source matches %s", m)
+			}
+		default:
+			pq.Filters = append(pq.Filters, m)
+		}
 	}
 	adhoc := true
 	if save := tags["save"]; save == "true" {
@@ -183,21 +382,42 @@ func (s *Server) ParseQuery(query string) (*ParsedQuery, error) {
 	if !ok {
 		return nil, errors.New("no location tag found")
 	}
-	location, err := s.LocationService.ParseLocation(loc)
+	location, err := s.LocationService.ParseLocation(ctx, loc)
 	if err != nil {
 		return nil, err
 	}
 	pq.Location = *location
 	pq.AdHoc = adhoc
-	if pq.Source, ok = tags["source"]; !ok {
+	if pq.Sources == nil {
 		return nil, errors.New("no source tag found")
 	}
+	if !adhoc && len(pq.Sources) < len(s.Forecasters.Names()) {
+		// only schedule the sources that were asked for
+		pq.Location.Sources = slices.Clone(pq.Sources)
+	}
 	return pq, nil
 }
 
+// parseExpr parses a query, and the information in it if it's a single vector selector.
+// Selectors in other expressions are parsed when they are evaluated.
+func (s *Server) parseExpr(ctx context.Context, query string) (promql.Expr, *ParsedQuery, error) {
+	expr, err := promql.Parse(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	pq := &ParsedQuery{}
+	if vs, ok := expr.(*promql.VectorSelector); ok {
+		pq, err = s.ParseSelector(ctx, vs)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return expr, pq, nil
+}
+
 // ParseParams parses all the information needed from the prometheus request.
-func (s *Server) ParseParams(Form url.Values) (*Params, error) {
-	pq, err := s.ParseQuery(Form.Get("query"))
+func (s *Server) ParseParams(ctx context.Context, Form url.Values) (*Params, error) {
+	expr, pq, err := s.parseExpr(ctx, Form.Get("query"))
 	if err != nil {
 		return nil, err
 	}
@@ -208,6 +428,42 @@ func (s *Server) ParseParams(Form url.Values) (*Params, error) {
 		Start:       start,
 		End:         end,
 		Step:        step,
+		Expr:        expr,
+		ParsedQuery: *pq,
+	}, nil
+}
+
+// ParseInstantParams parses the information needed from a prometheus instant query request.
+// The query time is Start and End, and defaults to now.
+func (s *Server) ParseInstantParams(ctx context.Context, Form url.Values) (*Params, error) {
+	expr, pq, err := s.parseExpr(ctx, Form.Get("query"))
+	if err != nil {
+		return nil, err
+	}
+	t := time.Now().Unix()
+	if ts := Form.Get("time"); ts != "" {
+		t, err = parseTimeParam(ts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Params{
+		Start:       t,
+		End:         t,
+		Step:        InstantLookback,
+		Expr:        expr,
 		ParsedQuery: *pq,
 	}, nil
 }
+
+// parseTimeParam parses a prometheus api timestamp, either unix seconds with optional decimals, or RFC 3339.
+func parseTimeParam(s string) (int64, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return int64(f), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s: must be a unix timestamp or RFC 3339", s)
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tedpearson/ForecastMetrics/v3/geocode"
+	"github.com/tedpearson/ForecastMetrics/v3/internal/promql"
+	"github.com/tedpearson/ForecastMetrics/v3/proxy"
+	"github.com/tedpearson/ForecastMetrics/v3/source"
+)
+
+// newTestServer creates a Server with the locations in apiLocations scheduled, and nws and metno enabled.
+func newTestServer(t *testing.T) *Server {
+	noForecast := forecasterFunc(func(context.Context, string, string) (*source.Forecast, error) {
+		return &source.Forecast{}, nil
+	})
+	return &Server{
+		LocationService: newTestLocationService(geocoderFunc(func(string) (*geocode.Result, error) {
+			return nil, geocode.ErrNotFound
+		})),
+		AllowedMetricNames: []string{"forecast", "astronomy", "accumulated_precip"},
+		PromConverter: PromConverter{
+			ForecastMeasurementName:  "forecast",
+			AstronomyMeasurementName: "astronomy",
+			PrecipProbability:        0.5,
+		},
+		AuthToken:     apiToken,
+		ConfigService: newTestConfigService(t, apiLocations),
+		Forecasters:   NewForecasters(map[string]source.Forecaster{"nws": noForecast, "metno": noForecast}),
+	}
+}
+
+func TestServer_ProxyQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		noProxy bool
+		proxied bool
+		// expected is the query after rewriting, if it's proxied
+		expected string
+	}{
+		{
+			name:     "scheduled location",
+			query:    `forecast_temperature{location="38.9,-77.03|Home",source="nws"}`,
+			proxied:  true,
+			expected: `forecast_temperature{location="Home",source="nws"}`,
+		},
+		{
+			name:     "coordinates written differently",
+			query:    `forecast_temperature{location="38.90, -77.030|Home",source="nws"}`,
+			proxied:  true,
+			expected: `forecast_temperature{location="Home",source="nws"}`,
+		},
+		{
+			name:     "save is removed",
+			query:    `forecast_temperature{location="38.9,-77.03|Home",source="nws",save="true"}`,
+			proxied:  true,
+			expected: `forecast_temperature{location="Home",source="nws"}`,
+		},
+		{
+			name:     "every selector is scheduled",
+			query:    `forecast_temperature{location="38.9,-77.03|Home",source="nws"} - forecast_temperature{location="39.1,-77.2|Work",source="nws"}`,
+			proxied:  true,
+			expected: `forecast_temperature{location="Home",source="nws"} - forecast_temperature{location="Work",source="nws"}`,
+		},
+		{
+			name:  "one selector isn't scheduled",
+			query: `forecast_temperature{location="38.9,-77.03|Home",source="nws"} - forecast_temperature{location="40,-78|Cabin",source="nws"}`,
+		},
+		{
+			name:  "other name",
+			query: `forecast_temperature{location="38.9,-77.03|Office",source="nws"}`,
+		},
+		{
+			name:  "other coordinates",
+			query: `forecast_temperature{location="38.95,-77.03|Home",source="nws"}`,
+		},
+		{
+			name:  "computed metric",
+			query: `accumulated_precip{location="38.9,-77.03|Home",source="nws"}`,
+		},
+		{
+			name:  "invalid selector",
+			query: `forecast_temperature{location="38.9,-77.03|Home"}`,
+		},
+		{
+			name:    "no proxy",
+			query:   `forecast_temperature{location="38.9,-77.03|Home",source="nws"}`,
+			noProxy: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			if !tt.noProxy {
+				s.PrometheusProxy = &proxy.Prometheus{}
+			}
+			expr, err := promql.Parse(tt.query)
+			require.NoError(t, err)
+			original := expr.String()
+			assert.Equal(t, tt.proxied, s.proxyQuery(context.Background(), expr))
+			if tt.proxied {
+				assert.Equal(t, tt.expected, expr.String())
+			} else {
+				assert.Equal(t, original, expr.String())
+			}
+		})
+	}
+}
+
+func TestServer_ParseSelectorSources(t *testing.T) {
+	tests := []struct {
+		name     string
+		matchers string
+		sources  []string
+		// scheduled are the sources saved with the location
+		scheduled []string
+		err       string
+	}{
+		{name: "equal", matchers: `source="nws"`, sources: []string{"nws"}},
+		{name: "not equal", matchers: `source!="nws"`, sources: []string{"metno"}},
+		{name: "regex", matchers: `source=~"nws|metno"`, sources: []string{"metno", "nws"}},
+		{name: "regex is anchored", matchers: `source=~"n"`, err: `no enabled source matches source=~"n"`},
+		{name: "not regex", matchers: `source!~"n.*"`, sources: []string{"metno"}},
+		{name: "every matcher applies", matchers: `source=~".+",source!="metno"`, sources: []string{"nws"}},
+		{name: "no match", matchers: `source=~"met.*",source!="metno"`, err: `no enabled source matches source!="metno"`},
+		{name: "disabled source", matchers: `source="visualcrossing"`, err: `no enabled source matches source="visualcrossing"`},
+		{name: "no source", matchers: `save="true"`, err: "no source tag found"},
+		{name: "save some sources", matchers: `source="nws",save="true"`, sources: []string{"nws"}, scheduled: []string{"nws"}},
+		{name: "save every source", matchers: `source=~".*",save="true"`, sources: []string{"metno", "nws"}},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pq, err := s.ParseQuery(context.Background(), `forecast_temperature{location="38.9,-77.03|Home",`+tt.matchers+`}`)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.sources, pq.Sources)
+			assert.Equal(t, tt.scheduled, pq.Location.Sources)
+		})
+	}
+}
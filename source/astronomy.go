@@ -0,0 +1,114 @@
+package source
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/tedpearson/ForecastMetrics/v3/internal/astronomy"
+	"github.com/tedpearson/ForecastMetrics/v3/internal/convert"
+)
+
+// ComputeAstroEvents calculates astronomy forecasts for sources that don't provide them.
+// Like VisualCrossing, it creates a point every hour from start to end, plus a point at each sunrise and sunset,
+// with the moon phase at sunset. It also adds moonrise and moonset, and civil, nautical and astronomical
+// dawn and dusk, with the twilight fields set in the hourly points too.
+func ComputeAstroEvents(lat string, lon string, start time.Time, end time.Time) ([]AstroEvent, error) {
+	latF, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid latitude %s: %w", lat, err)
+	}
+	lonF, err := strconv.ParseFloat(lon, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid longitude %s: %w", lon, err)
+	}
+	one := 1
+	zero := 0
+	inRange := func(t time.Time) bool {
+		return !t.IsZero() && !t.Before(start) && !t.After(end)
+	}
+	var events []AstroEvent
+	// sunrise and sunset for each day, calculated near local solar noon
+	seen := make(map[time.Time]bool)
+	for day := start.UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour); !day.After(end); day = day.Add(24 * time.Hour) {
+		noon := day.Add(time.Duration((12 - lonF/15) * float64(time.Hour)))
+		times := astronomy.GetSunTimes(noon, latF, lonF)
+		if inRange(times.Sunrise) && !seen[times.Sunrise] {
+			seen[times.Sunrise] = true
+			events = append(events, AstroEvent{
+				Time:  times.Sunrise,
+				SunUp: &one,
+			})
+		}
+		if inRange(times.Sunset) && !seen[times.Sunset] {
+			seen[times.Sunset] = true
+			// same as visualcrossing: 0 = new moon, 1 = full moon
+			phase := astronomy.GetMoonIllumination(times.Sunset).Phase
+			moonRatio := 1 - convert.Round(2.0*math.Abs(phase-0.5), 2)
+			events = append(events, AstroEvent{
+				Time:          times.Sunset,
+				SunUp:         &zero,
+				FullMoonRatio: &moonRatio,
+			})
+		}
+		// dawn and dusk of each twilight
+		for _, change := range []struct {
+			t   time.Time
+			set func(e *AstroEvent)
+		}{
+			{times.CivilDawn, func(e *AstroEvent) { e.CivilTwilight = &one }},
+			{times.CivilDusk, func(e *AstroEvent) { e.CivilTwilight = &zero }},
+			{times.NauticalDawn, func(e *AstroEvent) { e.NauticalTwilight = &one }},
+			{times.NauticalDusk, func(e *AstroEvent) { e.NauticalTwilight = &zero }},
+			{times.AstronomicalDawn, func(e *AstroEvent) { e.AstronomicalTwilight = &one }},
+			{times.AstronomicalDusk, func(e *AstroEvent) { e.AstronomicalTwilight = &zero }},
+		} {
+			if inRange(change.t) && !seen[change.t] {
+				seen[change.t] = true
+				e := AstroEvent{Time: change.t}
+				change.set(&e)
+				events = append(events, e)
+			}
+		}
+	}
+	// moonrise and moonset
+	for day := start; !day.After(end); day = day.Add(24 * time.Hour) {
+		times := astronomy.GetMoonTimes(day, latF, lonF)
+		if inRange(times.Rise) {
+			events = append(events, AstroEvent{
+				Time:   times.Rise,
+				MoonUp: &one,
+			})
+		}
+		if inRange(times.Set) {
+			events = append(events, AstroEvent{
+				Time:   times.Set,
+				MoonUp: &zero,
+			})
+		}
+	}
+	// hourly points
+	above := func(altitude, angle float64) *int {
+		if altitude > angle {
+			return &one
+		}
+		return &zero
+	}
+	for t := start.Truncate(time.Hour); !t.After(end); t = t.Add(time.Hour) {
+		sun := astronomy.SunAltitude(t, latF, lonF)
+		events = append(events, AstroEvent{
+			Time:                 t,
+			SunUp:                above(sun, astronomy.SunriseAngle),
+			MoonUp:               above(astronomy.MoonAltitude(t, latF, lonF), astronomy.MoonriseAngle),
+			CivilTwilight:        above(sun, astronomy.CivilAngle),
+			NauticalTwilight:     above(sun, astronomy.NauticalAngle),
+			AstronomicalTwilight: above(sun, astronomy.AstronomicalAngle),
+		})
+	}
+	slices.SortStableFunc(events, func(a, b AstroEvent) int {
+		return a.Time.Compare(b.Time)
+	})
+	return events, nil
+}
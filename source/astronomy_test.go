@@ -0,0 +1,83 @@
+package source
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeAstroEvents(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(72 * time.Hour)
+	events, err := ComputeAstroEvents("38.8895", "-77.0352", start, end)
+	require.NoError(t, err)
+
+	var hourly, sunrises, sunsets, moonrises, moonsets int
+	twilights := make(map[string]int)
+	for i, e := range events {
+		if i > 0 {
+			assert.False(t, e.Time.Before(events[i-1].Time), "events are sorted")
+		}
+		switch {
+		case e.Time.Equal(e.Time.Truncate(time.Hour)):
+			hourly++
+			require.NotNil(t, e.SunUp)
+			require.NotNil(t, e.MoonUp)
+			// each twilight includes the brighter ones
+			assert.GreaterOrEqual(t, *e.CivilTwilight, *e.SunUp)
+			assert.GreaterOrEqual(t, *e.NauticalTwilight, *e.CivilTwilight)
+			assert.GreaterOrEqual(t, *e.AstronomicalTwilight, *e.NauticalTwilight)
+		case e.FullMoonRatio != nil:
+			sunsets++
+			assert.Equal(t, 0, *e.SunUp)
+			// new moon was June 6th
+			assert.Less(t, *e.FullMoonRatio, 0.5)
+		case e.SunUp != nil:
+			sunrises++
+			assert.Equal(t, 1, *e.SunUp)
+		case e.MoonUp != nil && *e.MoonUp == 1:
+			moonrises++
+		case e.MoonUp != nil:
+			moonsets++
+		case e.CivilTwilight != nil:
+			twilights["civil"]++
+		case e.NauticalTwilight != nil:
+			twilights["nautical"]++
+		case e.AstronomicalTwilight != nil:
+			twilights["astronomical"]++
+		}
+	}
+	assert.Equal(t, 73, hourly)
+	assert.Equal(t, 3, sunrises)
+	assert.Equal(t, 3, sunsets)
+	assert.InDelta(t, 3, moonrises, 1)
+	assert.InDelta(t, 3, moonsets, 1)
+	// 3 dawns and 3 or 4 dusks, since the window starts in the evening in Washington
+	for _, twilight := range []string{"civil", "nautical", "astronomical"} {
+		assert.InDelta(t, 6.5, twilights[twilight], 0.5, twilight)
+	}
+
+	// the sun is up at noon local time and down at midnight
+	for _, e := range events {
+		if e.Time.Equal(time.Date(2024, 6, 1, 16, 0, 0, 0, time.UTC)) {
+			assert.Equal(t, 1, *e.SunUp)
+		}
+		if e.Time.Equal(time.Date(2024, 6, 1, 4, 0, 0, 0, time.UTC)) {
+			assert.Equal(t, 0, *e.SunUp)
+			assert.Equal(t, 0, *e.AstronomicalTwilight)
+		}
+		if e.Time.Equal(time.Date(2024, 6, 2, 1, 0, 0, 0, time.UTC)) {
+			// 9pm: civil dusk was 8:59pm
+			assert.Equal(t, 0, *e.SunUp)
+			assert.Equal(t, 0, *e.CivilTwilight)
+			assert.Equal(t, 1, *e.NauticalTwilight)
+		}
+	}
+}
+
+func TestComputeAstroEventsInvalid(t *testing.T) {
+	_, err := ComputeAstroEvents("north", "-77", time.Now(), time.Now())
+	assert.Error(t, err)
+}
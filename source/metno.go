@@ -0,0 +1,210 @@
+package source
+
+// https://api.met.no/weatherapi/locationforecast/2.0/documentation
+// https://api.met.no/doc/TermsOfService
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+
+	"github.com/tedpearson/ForecastMetrics/v3/http"
+	"github.com/tedpearson/ForecastMetrics/v3/internal/convert"
+)
+
+const metNorwayBaseUrl = "https://api.met.no"
+
+// METNorway provides weather forecasts from the Norwegian Meteorological Institute (yr.no).
+// METNorway does not support astronomy forecasts.
+type METNorway struct {
+	Retryer http.Retryer
+	// BaseUrl overrides the api host. Defaults to the public api.
+	BaseUrl string
+	// cache is required by the terms of service: don't request again before Expires,
+	// and use If-Modified-Since afterward.
+	cache http.ConditionalCache
+}
+
+// GetForecast implements Forecaster by returning the MET Norway weather forecast.
+func (m *METNorway) GetForecast(ctx context.Context, lat string, lon string) (*Forecast, error) {
+	base := m.BaseUrl
+	if base == "" {
+		base = metNorwayBaseUrl
+	}
+	// the terms of service require at most 4 decimals, to improve caching
+	lat, err := truncateCoordinate(lat)
+	if err != nil {
+		return nil, err
+	}
+	lon, err = truncateCoordinate(lon)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Add("lat", lat)
+	q.Add("lon", lon)
+	off := backoff.NewExponentialBackOff()
+	off.MaxElapsedTime = 10 * time.Second
+	body, err := m.Retryer.RetryConditionalRequest(ctx, base+"/weatherapi/locationforecast/2.0/complete?"+q.Encode(), off, &m.cache)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup(body)
+
+	var forecast metForecast
+	err = json.NewDecoder(body).Decode(&forecast)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := m.transformForecast(forecast)
+	if err != nil {
+		return nil, err
+	}
+	return &Forecast{
+		WeatherRecords: records,
+	}, nil
+}
+
+// transformForecast converts the forecast to a format suitable for the database or prometheus metrics.
+// Instant values are valid until the next point in the timeseries, which is hourly for the first few days
+// and then every 6 hours. Precipitation over 6 hours is split evenly into hourly amounts.
+func (m *METNorway) transformForecast(forecast metForecast) ([]WeatherRecord, error) {
+	var temperature, dewpoint, skyCover, windDirection, windSpeed, windGust, precipProb, precipAmount nwsForecastMeasurements
+	add := func(measurements *nwsForecastMeasurements, validTime string, value *float64) {
+		if value != nil {
+			measurements.Values = append(measurements.Values, nwsForecastMeasurement{
+				ValidTime: validTime,
+				Value:     *value,
+			})
+		}
+	}
+	series := forecast.Properties.Timeseries
+	for i, point := range series {
+		t, err := time.Parse(time.RFC3339, point.Time)
+		if err != nil {
+			return nil, err
+		}
+		period := point.Data.Next1Hours
+		periodHours := 1
+		if period == nil {
+			period = point.Data.Next6Hours
+			periodHours = 6
+		}
+		// instant values are valid until the next point
+		hours := 1
+		if i+1 < len(series) {
+			next, err := time.Parse(time.RFC3339, series[i+1].Time)
+			if err != nil {
+				return nil, err
+			}
+			hours = max(1, int(next.Sub(t).Hours()))
+		}
+		start := t.UTC().Format(time.RFC3339)
+		instantTime := fmt.Sprintf("%s/PT%dH", start, hours)
+		instant := point.Data.Instant.Details
+		add(&temperature, instantTime, instant.AirTemperature)
+		add(&dewpoint, instantTime, instant.DewPointTemperature)
+		add(&skyCover, instantTime, instant.CloudAreaFraction)
+		add(&windDirection, instantTime, instant.WindFromDirection)
+		add(&windSpeed, instantTime, instant.WindSpeed)
+		add(&windGust, instantTime, instant.WindSpeedOfGust)
+		if period != nil {
+			periodTime := fmt.Sprintf("%s/PT%dH", start, periodHours)
+			add(&precipProb, periodTime, period.Details.ProbabilityOfPrecipitation)
+			add(&precipAmount, periodTime, period.Details.PrecipitationAmount)
+		}
+	}
+
+	var table = []transformation{
+		{
+			measurements: temperature,
+			setter:       SetTemperature,
+			conversion:   convert.CToF,
+		},
+		{
+			measurements: dewpoint,
+			setter:       SetDewpoint,
+			conversion:   convert.CToF,
+		},
+		{
+			measurements: skyCover,
+			setter:       SetSkyCover,
+			conversion:   convert.PercentToRatio,
+		},
+		{
+			measurements: windDirection,
+			setter:       SetWindDirection,
+			conversion:   convert.Identity,
+		},
+		{
+			measurements: windSpeed,
+			setter:       SetWindSpeed,
+			conversion:   convert.MpsToMph,
+		},
+		{
+			measurements: windGust,
+			setter:       SetWindGust,
+			conversion:   convert.MpsToMph,
+		},
+		{
+			measurements: precipProb,
+			setter:       SetPrecipitationProbability,
+			conversion:   convert.PercentToRatio,
+		},
+		{
+			measurements: precipAmount,
+			setter:       SetPreciptationAmount,
+			conversion:   convert.MmToIn,
+			aggregation: func(hours int, val float64) float64 {
+				return val / float64(hours)
+			},
+		},
+	}
+	return runTransformations(table)
+}
+
+// truncateCoordinate rounds a coordinate to 4 decimals.
+func truncateCoordinate(coord string) (string, error) {
+	f, err := strconv.ParseFloat(coord, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid coordinate %s: %w", coord, err)
+	}
+	return strconv.FormatFloat(convert.Round(f, 4), 'f', -1, 64), nil
+}
+
+// metPeriod is the json representation of a forecast summary for the next 1 or 6 hours.
+type metPeriod struct {
+	Details struct {
+		PrecipitationAmount        *float64 `json:"precipitation_amount"`
+		ProbabilityOfPrecipitation *float64 `json:"probability_of_precipitation"`
+	} `json:"details"`
+}
+
+// metForecast is the json representation of a forecast from MET Norway.
+type metForecast struct {
+	Properties struct {
+		Timeseries []struct {
+			Time string `json:"time"`
+			Data struct {
+				Instant struct {
+					Details struct {
+						AirTemperature      *float64 `json:"air_temperature"`
+						DewPointTemperature *float64 `json:"dew_point_temperature"`
+						CloudAreaFraction   *float64 `json:"cloud_area_fraction"`
+						WindFromDirection   *float64 `json:"wind_from_direction"`
+						WindSpeed           *float64 `json:"wind_speed"`
+						WindSpeedOfGust     *float64 `json:"wind_speed_of_gust"`
+					} `json:"details"`
+				} `json:"instant"`
+				Next1Hours *metPeriod `json:"next_1_hours"`
+				Next6Hours *metPeriod `json:"next_6_hours"`
+			} `json:"data"`
+		} `json:"timeseries"`
+	} `json:"properties"`
+}
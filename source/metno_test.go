@@ -0,0 +1,95 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	myhttp "github.com/tedpearson/ForecastMetrics/v3/http"
+)
+
+func TestMETNorway_GetForecast(t *testing.T) {
+	lastModified := "Sat, 01 Jun 2024 09:12:04 GMT"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/weatherapi/locationforecast/2.0/complete", r.URL.Path)
+		assert.Equal(t, "59.9133", r.URL.Query().Get("lat"))
+		assert.Equal(t, "10.75", r.URL.Query().Get("lon"))
+		assert.NotEmpty(t, r.Header.Get("User-Agent"))
+		// already expired, so the next request is conditional
+		w.Header().Set("Expires", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+		w.Header().Set("Last-Modified", lastModified)
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		http.ServeFile(w, r, "testdata/metno.json")
+	}))
+	defer server.Close()
+
+	m := METNorway{
+		Retryer: myhttp.Retryer{Client: server.Client()},
+		BaseUrl: server.URL,
+	}
+	forecast, err := m.GetForecast(context.Background(), "59.91331234", "10.75")
+	require.NoError(t, err)
+
+	records := forecast.WeatherRecords
+	require.Len(t, records, 9)
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i, record := range records[:8] {
+		assert.Equal(t, start.Add(time.Duration(i)*time.Hour), record.Time)
+	}
+	assert.Equal(t, 68.0, *records[0].Temperature)
+	assert.Equal(t, 50.0, *records[0].Dewpoint)
+	assert.Equal(t, 0.5, *records[0].SkyCover)
+	assert.Equal(t, 200.0, *records[0].WindDirection)
+	assert.Equal(t, 11.18, *records[0].WindSpeed)
+	assert.Equal(t, 22.37, *records[0].WindGust)
+	assert.Equal(t, 0.8, *records[0].PrecipitationProbability)
+	assert.Equal(t, 0.1, *records[0].PrecipitationAmount)
+	assert.Equal(t, 77.0, *records[1].Temperature)
+	assert.Nil(t, records[2].WindGust)
+	// 6 hour values are spread over each hour
+	for _, record := range records[2:8] {
+		assert.Equal(t, 50.0, *record.Temperature)
+		assert.Equal(t, 0.6, *record.PrecipitationProbability)
+		assert.Equal(t, 0.1, *record.PrecipitationAmount)
+	}
+	// the last point has no precipitation period
+	assert.Equal(t, time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC), records[8].Time)
+	assert.Equal(t, 32.0, *records[8].Temperature)
+	assert.Nil(t, records[8].PrecipitationAmount)
+
+	// the second request is conditional and reuses the previous response
+	forecast2, err := m.GetForecast(context.Background(), "59.9133", "10.75")
+	require.NoError(t, err)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, forecast, forecast2)
+}
+
+func TestMETNorway_GetForecastNotExpired(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Expires", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		http.ServeFile(w, r, "testdata/metno.json")
+	}))
+	defer server.Close()
+
+	m := METNorway{
+		Retryer: myhttp.Retryer{Client: server.Client()},
+		BaseUrl: server.URL,
+	}
+	_, err := m.GetForecast(context.Background(), "59.9133", "10.75")
+	require.NoError(t, err)
+	_, err = m.GetForecast(context.Background(), "59.9133", "10.75")
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests)
+}
@@ -5,9 +5,11 @@ package source
 // https://www.weather.gov/documentation/services-web-api#/default/get_gridpoints__wfo___x___y_
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"math"
 	"slices"
 	"strings"
@@ -27,13 +29,13 @@ type NWS struct {
 }
 
 // GetForecast implements Forecaster by returning the NWS weather forecast.
-func (n *NWS) GetForecast(lat string, lon string) (*Forecast, error) {
+func (n *NWS) GetForecast(ctx context.Context, lat string, lon string) (*Forecast, error) {
 	// find gridpoint
 	url := fmt.Sprintf("https://api.weather.gov/points/%s,%s", lat, lon)
 
 	off := backoff.NewExponentialBackOff()
 	off.MaxElapsedTime = 22 * time.Second
-	body1, err := n.Retryer.RetryRequest(url, off)
+	body1, err := n.Retryer.RetryRequest(ctx, url, off)
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +47,7 @@ func (n *NWS) GetForecast(lat string, lon string) (*Forecast, error) {
 	}
 	gridpointUrl := jsonResponse["properties"].(map[string]interface{})["forecastGridData"].(string)
 	// okay we have a gridpoint url. get it and turn it into an object and do fun things with it
-	body2, err := n.Retryer.RetryRequest(gridpointUrl, off)
+	body2, err := n.Retryer.RetryRequest(ctx, gridpointUrl, off)
 	if err != nil {
 		return nil, err
 	}
@@ -61,11 +63,56 @@ func (n *NWS) GetForecast(lat string, lon string) (*Forecast, error) {
 	if err != nil {
 		return nil, err
 	}
+	hazards, err := n.transformHazards(forecast)
+	if err != nil {
+		return nil, err
+	}
 	return &Forecast{
 		WeatherRecords: records,
+		Hazards:        hazards,
 	}, nil
 }
 
+// transformHazards converts the forecast hazards to an hourly Hazard for each hour they are active.
+// Hazards with the same phenomenon and significance in the same hour, such as separate events or
+// overlapping periods, are only included once.
+func (n *NWS) transformHazards(forecast nwsForecast) ([]Hazard, error) {
+	type hazardKey struct {
+		hour                     int64
+		phenomenon, significance string
+	}
+	var hazards []Hazard
+	seen := make(map[hazardKey]bool)
+	for _, value := range forecast.Properties.Hazards.Values {
+		hours, err := durationStrToHours(value.ValidTime)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range value.Value {
+			significance := ""
+			if s, ok := h.Significance.(string); ok {
+				significance = s
+			}
+			for _, hour := range hours {
+				key := hazardKey{hour.Unix(), h.Phenomenon, significance}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				hazards = append(hazards, Hazard{
+					Time:         hour,
+					Phenomenon:   h.Phenomenon,
+					Significance: significance,
+				})
+			}
+		}
+	}
+	slices.SortStableFunc(hazards, func(a, b Hazard) int {
+		return a.Time.Compare(b.Time)
+	})
+	return hazards, nil
+}
+
 // transformForecast converts the forecast to a format suitable for the database or prometheus metrics.
 func (n *NWS) transformForecast(forecast nwsForecast) ([]WeatherRecord, error) {
 	props := forecast.Properties
@@ -136,6 +183,11 @@ func (n *NWS) transformForecast(forecast nwsForecast) ([]WeatherRecord, error) {
 		},
 	}
 
+	return runTransformations(table)
+}
+
+// runTransformations runs every transformation, merging the results into hourly WeatherRecords sorted by time.
+func runTransformations(table []transformation) ([]WeatherRecord, error) {
 	recordMap := make(map[time.Time]WeatherRecord)
 	for _, items := range table {
 		err := processMeasurement(&recordMap, items)
@@ -210,7 +262,7 @@ func durationStrToHours(dateString string) ([]time.Time, error) {
 // cleanup closes an io.Closer, printing any error that occurs.
 func cleanup(closer io.Closer) {
 	if err := closer.Close(); err != nil {
-		fmt.Printf("error closing response body: %s\n", err)
+		slog.Warn("Error closing response body", "error", err)
 	}
 }
 
@@ -225,11 +277,14 @@ type transformation struct {
 
 // nwsForecastMeasurements is the json structure of most forecast information from NWS.
 type nwsForecastMeasurements struct {
-	Uom    string `json:"uom"`
-	Values []struct {
-		ValidTime string  `json:"validTime"`
-		Value     float64 `json:"value"`
-	}
+	Uom    string                   `json:"uom"`
+	Values []nwsForecastMeasurement `json:"values"`
+}
+
+// nwsForecastMeasurement is a single value valid for a time period in ISO-8601 format.
+type nwsForecastMeasurement struct {
+	ValidTime string  `json:"validTime"`
+	Value     float64 `json:"value"`
 }
 
 // nwsForecast is the json structure of the NWS forecast.
@@ -1,10 +1,12 @@
 package source
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStr2Times(t *testing.T) {
@@ -45,3 +47,39 @@ func TestStr2Times(t *testing.T) {
 		})
 	}
 }
+
+func TestNWS_transformHazards(t *testing.T) {
+	var forecast nwsForecast
+	err := json.Unmarshal([]byte(`{"properties":{"hazards":{"values":[
+		{"validTime":"2020-08-28T17:00:00+00:00/PT2H","value":[
+			{"phenomenon":"WS","significance":"W","event_number":4},
+			{"phenomenon":"WC","significance":"Y","event_number":null}
+		]},
+		{"validTime":"2020-08-28T16:00:00+00:00/PT1H","value":[
+			{"phenomenon":"WS","significance":"A","event_number":3}
+		]},
+		{"validTime":"2020-08-28T18:00:00+00:00/PT2H","value":[
+			{"phenomenon":"WS","significance":"W","event_number":5},
+			{"phenomenon":"WS","significance":"W","event_number":6}
+		]}
+	]}}}`), &forecast)
+	require.NoError(t, err)
+	n := NWS{}
+	hazards, err := n.transformHazards(forecast)
+	require.NoError(t, err)
+	h := func(hour int, phenomenon, significance string) Hazard {
+		return Hazard{
+			Time:         time.Date(2020, 8, 28, hour, 0, 0, 0, time.UTC),
+			Phenomenon:   phenomenon,
+			Significance: significance,
+		}
+	}
+	assert.Equal(t, []Hazard{
+		h(16, "WS", "A"),
+		h(17, "WS", "W"),
+		h(17, "WC", "Y"),
+		h(18, "WS", "W"),
+		h(18, "WC", "Y"),
+		h(19, "WS", "W"),
+	}, hazards)
+}
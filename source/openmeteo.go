@@ -0,0 +1,186 @@
+package source
+
+// https://open-meteo.com/en/docs
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+
+	"github.com/tedpearson/ForecastMetrics/v3/http"
+	"github.com/tedpearson/ForecastMetrics/v3/internal/convert"
+)
+
+const openMeteoBaseUrl = "https://api.open-meteo.com"
+
+// OpenMeteo provides weather forecasts from https://open-meteo.com
+// OpenMeteo supports sunrise and sunset astronomy forecasts, but not moon phase.
+type OpenMeteo struct {
+	Retryer http.Retryer
+	// BaseUrl overrides the api host, e.g. for a self-hosted instance. Defaults to the public api.
+	BaseUrl string
+}
+
+// GetForecast implements Forecaster by returning the OpenMeteo weather and astronomy forecasts.
+func (o *OpenMeteo) GetForecast(ctx context.Context, lat string, lon string) (*Forecast, error) {
+	base := o.BaseUrl
+	if base == "" {
+		base = openMeteoBaseUrl
+	}
+	q := url.Values{}
+	q.Add("latitude", lat)
+	q.Add("longitude", lon)
+	q.Add("hourly", "temperature_2m,dew_point_2m,apparent_temperature,cloud_cover,wind_direction_10m,"+
+		"wind_speed_10m,wind_gusts_10m,precipitation_probability,precipitation,snowfall")
+	q.Add("daily", "sunrise,sunset")
+	q.Add("temperature_unit", "fahrenheit")
+	q.Add("wind_speed_unit", "mph")
+	q.Add("precipitation_unit", "inch")
+	q.Add("timeformat", "unixtime")
+	// days in the location's time zone, so that each day's sunset isn't on the next day in UTC
+	q.Add("timezone", "auto")
+	q.Add("forecast_days", "16")
+	off := backoff.NewExponentialBackOff()
+	off.MaxElapsedTime = 10 * time.Second
+	body, err := o.Retryer.RetryRequest(ctx, base+"/v1/forecast?"+q.Encode(), off)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup(body)
+
+	var forecast omForecast
+	err = json.NewDecoder(body).Decode(&forecast)
+	if err != nil {
+		return nil, err
+	}
+
+	h := forecast.Hourly
+	weatherRecords := make([]WeatherRecord, 0, len(h.Time))
+	for i, ts := range h.Time {
+		temp := omValue(h.Temperature, i)
+		// hours beyond the model horizon are returned with null values, skip them.
+		if temp == nil {
+			continue
+		}
+		record := WeatherRecord{
+			Time:                time.Unix(ts, 0).UTC(),
+			Temperature:         temp,
+			Dewpoint:            omValue(h.Dewpoint, i),
+			FeelsLike:           omValue(h.ApparentTemperature, i),
+			WindDirection:       omValue(h.WindDirection, i),
+			WindSpeed:           omValue(h.WindSpeed, i),
+			WindGust:            omValue(h.WindGust, i),
+			PrecipitationAmount: omValue(h.Precipitation, i),
+			SnowAmount:          convert.NilToZero(omValue(h.Snowfall, i)),
+		}
+		if cc := omValue(h.CloudCover, i); cc != nil {
+			skyCover := convert.PercentToRatio(*cc)
+			record.SkyCover = &skyCover
+		}
+		if pop := omValue(h.PrecipitationProbability, i); pop != nil {
+			precipProb := convert.PercentToRatio(*pop)
+			record.PrecipitationProbability = &precipProb
+		}
+		weatherRecords = append(weatherRecords, record)
+	}
+
+	astroEvents := o.astroEvents(weatherRecords, forecast.Daily)
+	return &Forecast{
+		WeatherRecords: weatherRecords,
+		AstroEvents:    astroEvents,
+	}, nil
+}
+
+// astroEvents creates a sun up point for every hour in the weather forecast, plus a point
+// at each sunrise and sunset.
+func (o *OpenMeteo) astroEvents(records []WeatherRecord, daily omDaily) []AstroEvent {
+	one := 1
+	zero := 0
+	// sunrises and sunsets in order. polar days and nights have neither.
+	var changes []AstroEvent
+	for _, times := range []struct {
+		times []*int64
+		sunUp *int
+	}{{daily.Sunrise, &one}, {daily.Sunset, &zero}} {
+		for _, ts := range times.times {
+			if ts != nil {
+				changes = append(changes, AstroEvent{Time: time.Unix(*ts, 0).UTC(), SunUp: times.sunUp})
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	slices.SortFunc(changes, func(a, b AstroEvent) int {
+		return a.Time.Compare(b.Time)
+	})
+	// add 32 points for sunrise and sunset each day
+	astroEvents := make([]AstroEvent, 0, len(records)+32)
+	for _, record := range records {
+		t := record.Time
+		// the sun is up if the last change by this hour was a sunrise, or if the next one is a sunset
+		next := sort.Search(len(changes), func(i int) bool {
+			return changes[i].Time.After(t)
+		})
+		var sunUp *int
+		if next > 0 {
+			sunUp = changes[next-1].SunUp
+		} else if *changes[0].SunUp == 1 {
+			sunUp = &zero
+		} else {
+			sunUp = &one
+		}
+		astroEvents = append(astroEvents, AstroEvent{
+			Time:  t,
+			SunUp: sunUp,
+		})
+		// insert the sunrise or sunset during this hour
+		for _, change := range changes {
+			if change.Time.Truncate(time.Hour).Equal(t) && change.Time.After(t) {
+				astroEvents = append(astroEvents, change)
+			}
+		}
+	}
+	return astroEvents
+}
+
+// omValue safely gets the value at index i, returning nil if it's missing.
+func omValue(values []*float64, i int) *float64 {
+	if i >= len(values) {
+		return nil
+	}
+	return values[i]
+}
+
+// omHourly is the json representation of the hourly forecast from OpenMeteo.
+type omHourly struct {
+	Time                     []int64    `json:"time"`
+	Temperature              []*float64 `json:"temperature_2m"`
+	Dewpoint                 []*float64 `json:"dew_point_2m"`
+	ApparentTemperature      []*float64 `json:"apparent_temperature"`
+	CloudCover               []*float64 `json:"cloud_cover"`
+	WindDirection            []*float64 `json:"wind_direction_10m"`
+	WindSpeed                []*float64 `json:"wind_speed_10m"`
+	WindGust                 []*float64 `json:"wind_gusts_10m"`
+	PrecipitationProbability []*float64 `json:"precipitation_probability"`
+	Precipitation            []*float64 `json:"precipitation"`
+	Snowfall                 []*float64 `json:"snowfall"`
+}
+
+// omDaily is the json representation of the daily forecast from OpenMeteo.
+type omDaily struct {
+	Time    []int64  `json:"time"`
+	Sunrise []*int64 `json:"sunrise"`
+	Sunset  []*int64 `json:"sunset"`
+}
+
+// omForecast is the json representation of a forecast from OpenMeteo.
+type omForecast struct {
+	Hourly omHourly `json:"hourly"`
+	Daily  omDaily  `json:"daily"`
+}
@@ -0,0 +1,95 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	myhttp "github.com/tedpearson/ForecastMetrics/v3/http"
+)
+
+func TestOpenMeteo_GetForecast(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/forecast", r.URL.Path)
+		assert.Equal(t, "38.89", r.URL.Query().Get("latitude"))
+		assert.Equal(t, "-77.03", r.URL.Query().Get("longitude"))
+		assert.Equal(t, "auto", r.URL.Query().Get("timezone"))
+		http.ServeFile(w, r, "testdata/openmeteo.json")
+	}))
+	defer server.Close()
+
+	o := OpenMeteo{
+		Retryer: myhttp.Retryer{Client: server.Client()},
+		BaseUrl: server.URL,
+	}
+	forecast, err := o.GetForecast(context.Background(), "38.89", "-77.03")
+	require.NoError(t, err)
+
+	// the last hour has a null temperature and is skipped
+	require.Len(t, forecast.WeatherRecords, 3)
+	first := forecast.WeatherRecords[0]
+	assert.Equal(t, time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC), first.Time)
+	assert.Equal(t, 61.5, *first.Temperature)
+	assert.Equal(t, 55.1, *first.Dewpoint)
+	assert.Equal(t, 60.9, *first.FeelsLike)
+	assert.Equal(t, 1.0, *first.SkyCover)
+	assert.Equal(t, 180.0, *first.WindDirection)
+	assert.Equal(t, 4.5, *first.WindSpeed)
+	assert.Equal(t, 9.2, *first.WindGust)
+	assert.Equal(t, 0.35, *first.PrecipitationProbability)
+	assert.Equal(t, 0.02, *first.PrecipitationAmount)
+	assert.Equal(t, 0.0, *first.SnowAmount)
+	assert.Equal(t, 0.0, *forecast.WeatherRecords[1].SnowAmount)
+	assert.Nil(t, forecast.WeatherRecords[2].PrecipitationProbability)
+
+	type sun struct {
+		Time  time.Time
+		SunUp int
+	}
+	actual := make([]sun, len(forecast.AstroEvents))
+	for i, e := range forecast.AstroEvents {
+		actual[i] = sun{e.Time, *e.SunUp}
+	}
+	// sunset in DC is after midnight UTC, on the next UTC day
+	expected := []sun{
+		{time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC), 1},
+		{time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC), 1},
+		{time.Date(2024, 6, 2, 0, 29, 0, 0, time.UTC), 0},
+		{time.Date(2024, 6, 2, 1, 0, 0, 0, time.UTC), 0},
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestOpenMeteo_astroEvents(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2024, 6, 1, hour, 0, 0, 0, time.UTC)
+	}
+	sunrise := at(9).Add(45 * time.Minute).Unix()
+	sunset := at(24).Add(29 * time.Minute).Unix()
+	var tests = []struct {
+		name     string
+		daily    omDaily
+		hour     int
+		expected []int
+	}{
+		{"before the first sunrise", omDaily{Sunrise: []*int64{&sunrise}, Sunset: []*int64{&sunset}}, 8, []int{0}},
+		{"during sunrise", omDaily{Sunrise: []*int64{&sunrise}, Sunset: []*int64{&sunset}}, 9, []int{0, 1}},
+		{"before the first sunset", omDaily{Sunrise: []*int64{nil}, Sunset: []*int64{&sunset}}, 20, []int{1}},
+		{"polar night", omDaily{Sunrise: []*int64{nil}, Sunset: []*int64{nil}}, 12, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := (&OpenMeteo{}).astroEvents([]WeatherRecord{{Time: at(tt.hour)}}, tt.daily)
+			var actual []int
+			for _, e := range events {
+				actual = append(actual, *e.SunUp)
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
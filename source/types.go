@@ -1,13 +1,15 @@
 package source
 
 import (
+	"context"
 	"time"
 )
 
-// Forecast holds a weather forecast and an astronomy forecast.
+// Forecast holds a weather forecast, an astronomy forecast, and any weather hazards.
 type Forecast struct {
 	WeatherRecords []WeatherRecord
 	AstroEvents    []AstroEvent
+	Hazards        []Hazard
 }
 
 // WeatherRecord is a weather forecast for a single point in time.
@@ -33,6 +35,19 @@ type AstroEvent struct {
 	MoonUp *int
 	// this is hard to name. It's not "how bright is the moon" - it's "ratio of current moon phase to the full moon".
 	FullMoonRatio *float64
+	// CivilTwilight, NauticalTwilight and AstronomicalTwilight are 1 from dawn to dusk, while the sun is above
+	// -6°, -12° and -18°. They are only computed locally, for sources without astronomy forecasts.
+	CivilTwilight        *int
+	NauticalTwilight     *int
+	AstronomicalTwilight *int
+}
+
+// Hazard is a weather hazard active for a single hour, such as a watch or warning.
+// Phenomenon and Significance are NWS VTEC codes, e.g. "WS" and "W" for a winter storm warning.
+type Hazard struct {
+	Time         time.Time
+	Phenomenon   string
+	Significance string
 }
 
 // InfluxPointer is either a WeatherRecord or an AstroEvent.
@@ -42,7 +57,8 @@ type InfluxPointer interface {
 
 // Forecaster can return a forecast for a given geo coordinate.
 type Forecaster interface {
-	GetForecast(lat string, lon string) (*Forecast, error)
+	// GetForecast gets the forecast for a location, giving up when ctx is done.
+	GetForecast(ctx context.Context, lat string, lon string) (*Forecast, error)
 }
 
 func SetTemperature(r *WeatherRecord, v float64) {
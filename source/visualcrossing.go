@@ -1,6 +1,7 @@
 package source
 
 import (
+	"context"
 	"encoding/json"
 	"math"
 	"net/url"
@@ -20,7 +21,7 @@ type VisualCrossing struct {
 }
 
 // GetForecast implements Forecaster by returning the VisualCrossing weather and astronomy forecasts.
-func (v *VisualCrossing) GetForecast(lat string, lon string) (*Forecast, error) {
+func (v *VisualCrossing) GetForecast(ctx context.Context, lat string, lon string) (*Forecast, error) {
 	base := "https://weather.visualcrossing.com/VisualCrossingWebServices/rest/services/weatherdata/forecast?"
 	q := url.Values{}
 	q.Add("aggregateHours", "1")
@@ -33,7 +34,7 @@ func (v *VisualCrossing) GetForecast(lat string, lon string) (*Forecast, error)
 	off := backoff.NewExponentialBackOff()
 	// note: low number of retries because we are using free tier (250 results/day)
 	off.MaxElapsedTime = 4 * time.Second
-	body, err := v.Retryer.RetryRequest(base+q.Encode(), off)
+	body, err := v.Retryer.RetryRequest(ctx, base+q.Encode(), off)
 	if err != nil {
 		return nil, err
 	}